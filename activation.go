@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service (SD_LISTEN_FDS_START)
+const listenFDsStart = 3
+
+// activationListener returns the listening socket systemd passed to the
+// server, or nil when it was not socket activated
+func activationListener() (net.Listener, error) {
+	return inheritedListener(listenFDsStart)
+}
+
+// inheritedListener implements the LISTEN_FDS protocol for a single TCP
+// socket starting at fd. The variables are unset, as sd_listen_fds does, so
+// processes the server starts do not believe the socket is theirs.
+func inheritedListener(fd int) (net.Listener, error) {
+	pidValue, fdsValue := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	if fdsValue == "" {
+		return nil, nil
+	}
+	// Meant for another process, e.g. the parent that passed its environment on
+	if pid, err := strconv.Atoi(pidValue); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(fdsValue)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fdsValue)
+	}
+	if count > 1 {
+		return nil, fmt.Errorf("expected one socket from systemd, got %d", count)
+	}
+
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+	defer func() { _ = f.Close() }()
+
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket passed by systemd is unusable: %w", err)
+	}
+	if _, ok := listener.Addr().(*net.TCPAddr); !ok {
+		_ = listener.Close()
+		return nil, fmt.Errorf("socket passed by systemd is not a TCP socket: %s", listener.Addr())
+	}
+	return listener, nil
+}
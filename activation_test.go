@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passSocket simulates systemd passing a listening socket to this process,
+// returning the descriptor it would find at SD_LISTEN_FDS_START
+func passSocket(t *testing.T) (fd, port int) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	f, err := listener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	// A descriptor of its own, which inheritedListener takes over
+	fd, err = syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	return fd, listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestInheritedListener(t *testing.T) {
+	fd, port := passSocket(t)
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "claude-review.socket")
+
+	listener, err := inheritedListener(fd)
+	require.NoError(t, err)
+	require.NotNil(t, listener)
+	defer func() { _ = listener.Close() }()
+	assert.Equal(t, port, listener.Addr().(*net.TCPAddr).Port)
+
+	// Not passed on to processes the server starts
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_, set := os.LookupEnv(name)
+		assert.False(t, set, name)
+	}
+
+	// Connections queued on the socket reach the server
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	accepted, err := listener.Accept()
+	require.NoError(t, err)
+	_ = accepted.Close()
+}
+
+func TestInheritedListener_NotActivated(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  string
+		fds  string
+	}{
+		{"no variables", "", ""},
+		{"meant for another process", strconv.Itoa(os.Getpid() + 1), "1"},
+		{"no LISTEN_PID", "", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			// Never touched, so any descriptor will do
+			listener, err := inheritedListener(-1)
+			assert.NoError(t, err)
+			assert.Nil(t, listener)
+		})
+	}
+}
+
+func TestInheritedListener_Invalid(t *testing.T) {
+	t.Run("count", func(t *testing.T) {
+		for _, fds := range []string{"0", "many", "2"} {
+			t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+			t.Setenv("LISTEN_FDS", fds)
+			_, err := inheritedListener(-1)
+			assert.Error(t, err, fds)
+		}
+	})
+
+	t.Run("not a socket", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "not-a-socket")
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		fd, err := syscall.Dup(int(f.Fd()))
+		require.NoError(t, err)
+
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "1")
+		_, err = inheritedListener(fd)
+		assert.ErrorContains(t, err, "unusable")
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// apiPrefix is the mount point of the versioned JSON API
+const apiPrefix = "/api/v1"
+
+// Machine-readable error codes returned in the JSON error envelope.
+// These are part of the public API contract (see api/openapi.json).
+const (
+	errCodeInvalidJSON       = "invalid_json"
+	errCodeInvalidParameter  = "invalid_parameter"
+	errCodeValidationFailed  = "validation_failed"
+	errCodeCommentHasReplies = "comment_has_replies"
+	errCodeNotFound          = "not_found"
+	errCodeMethodNotAllowed  = "method_not_allowed"
+	errCodeForbiddenHost     = "forbidden_host"
+	errCodeForbiddenOrigin   = "forbidden_origin"
+	errCodeInvalidToken      = "invalid_token"
+	errCodeRequestTooLarge   = "request_too_large"
+	errCodeInternal          = "internal_error"
+)
+
+// apiErrorCodes lists every error code the API can return
+var apiErrorCodes = []string{
+	errCodeInvalidJSON,
+	errCodeInvalidParameter,
+	errCodeValidationFailed,
+	errCodeCommentHasReplies,
+	errCodeNotFound,
+	errCodeMethodNotAllowed,
+	errCodeForbiddenHost,
+	errCodeForbiddenOrigin,
+	errCodeInvalidToken,
+	errCodeRequestTooLarge,
+	errCodeInternal,
+}
+
+// APIError is the body of every error response from the JSON API:
+//
+//	{"error": {"code": "not_found", "message": "Comment not found"}}
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID is set on internal errors, whose details are only logged
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type apiErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode JSON response", "error", err)
+	}
+}
+
+// writeError writes the JSON error envelope with the given status and code
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorEnvelope{Error: APIError{Code: code, Message: message}})
+}
+
+// decodeJSONBody decodes the request body into v, enforcing maxRequestBodyBytes.
+// On failure it writes the error response and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, errCodeRequestTooLarge,
+				fmt.Sprintf("Request body must be at most %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, errCodeInvalidJSON, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeInternalError reports an unexpected server-side failure. The error may
+// hold paths and SQL, so it is only logged; the response carries the
+// request's ID to find it in the log.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	requestLogger(r).Error("Internal error", "error", err)
+	writeJSON(w, http.StatusInternalServerError, apiErrorEnvelope{Error: APIError{
+		Code:      errCodeInternal,
+		Message:   "Internal server error",
+		RequestID: w.Header().Get("X-Request-Id"),
+	}})
+}
+
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, errCodeNotFound, "No such API route: "+r.URL.Path)
+}
+
+func handleAPIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
+
+// handleOpenAPISpec serves the embedded OpenAPI document describing the JSON API
+func handleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(openAPISpec); err != nil {
+		requestLogger(r).Warn("Failed to write OpenAPI spec", "error", err)
+	}
+}
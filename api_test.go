@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Properties map[string]openAPISchema `json:"properties"`
+	Enum       []string                 `json:"enum"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]json.RawMessage `json:"responses"`
+}
+
+func loadOpenAPIDoc(t *testing.T) openAPIDoc {
+	t.Helper()
+
+	var doc openAPIDoc
+	require.NoError(t, json.Unmarshal(openAPISpec, &doc))
+	return doc
+}
+
+// specPathForRoute maps a chi route pattern to its OpenAPI path
+func specPathForRoute(route string) string {
+	if route == apiPrefix+"/projects/*" {
+		return apiPrefix + "/projects/{project_directory}/summary"
+	}
+	return route
+}
+
+// jsonFieldNames returns the JSON names of the exported fields of a struct type
+func jsonFieldNames(v interface{}) []string {
+	var names []string
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if tag != "" && tag != "-" {
+			names = append(names, tag)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestOpenAPI_RoutesMatchSpec(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+
+	handler, err := newServer(newMemoryStore(), testAPIToken).routes()
+	require.NoError(t, err)
+
+	var fromCode []string
+	err = chi.Walk(handler.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/api/") {
+			fromCode = append(fromCode, strings.ToLower(method)+" "+specPathForRoute(route))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var fromSpec []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			fromSpec = append(fromSpec, method+" "+path)
+		}
+	}
+
+	sort.Strings(fromCode)
+	sort.Strings(fromSpec)
+	assert.Equal(t, fromSpec, fromCode, "api/openapi.json paths must match the registered routes")
+}
+
+func TestOpenAPI_ErrorCodesMatchSpec(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+
+	codes := doc.Components.Schemas["Error"].Properties["error"].Properties["code"].Enum
+	expected := append([]string{}, apiErrorCodes...)
+	sort.Strings(codes)
+	sort.Strings(expected)
+	assert.Equal(t, expected, codes)
+}
+
+func TestOpenAPI_SchemasMatchStructs(t *testing.T) {
+	doc := loadOpenAPIDoc(t)
+
+	assert.Equal(t, jsonFieldNames(Comment{}), sortedKeys(doc.Components.Schemas["Comment"].Properties))
+	assert.Equal(t, jsonFieldNames(FileSummary{}), sortedKeys(doc.Components.Schemas["FileSummary"].Properties))
+	assert.Equal(t, jsonFieldNames(WatcherStatus{}), sortedKeys(doc.Components.Schemas["WatcherStatus"].Properties))
+	assert.Equal(t, jsonFieldNames(IdleStatus{}), sortedKeys(doc.Components.Schemas["IdleStatus"].Properties))
+	assert.Equal(t, jsonFieldNames(VersionInfo{}), sortedKeys(doc.Components.Schemas["VersionInfo"].Properties))
+	assert.Equal(t, jsonFieldNames(APIError{}), sortedKeys(doc.Components.Schemas["Error"].Properties["error"].Properties))
+}
+
+func TestAPI_ErrorEnvelope(t *testing.T) {
+	ts := newTestServer(t)
+	doc := loadOpenAPIDoc(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		specPath string
+		body     interface{}
+		status   int
+		code     string
+	}{
+		{"invalid json", http.MethodPost, "/api/v1/comments", "/api/v1/comments", "not an object", http.StatusBadRequest, errCodeInvalidJSON},
+		{"validation", http.MethodPost, "/api/v1/comments", "/api/v1/comments", map[string]string{}, http.StatusBadRequest, errCodeValidationFailed},
+		{"bad id", http.MethodDelete, "/api/v1/comments/abc", "/api/v1/comments/{id}", nil, http.StatusBadRequest, errCodeInvalidParameter},
+		{"missing thread", http.MethodGet, "/api/v1/threads/42", "/api/v1/threads/{id}", nil, http.StatusNotFound, errCodeNotFound},
+		{"missing project", http.MethodGet, "/api/v1/projects/nope/summary", "/api/v1/projects/{project_directory}/summary", nil, http.StatusNotFound, errCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, tt.method, tt.path, tt.body)
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var envelope apiErrorEnvelope
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&envelope))
+			assert.Equal(t, tt.code, envelope.Error.Code)
+			assert.NotEmpty(t, envelope.Error.Message)
+
+			// The status code must be documented for the operation
+			var op openAPIOperation
+			require.NoError(t, json.Unmarshal(doc.Paths[tt.specPath][strings.ToLower(tt.method)], &op))
+			assert.Contains(t, op.Responses, strconv.Itoa(tt.status))
+		})
+	}
+
+	t.Run("unknown route", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/nope", nil)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		var envelope apiErrorEnvelope
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&envelope))
+		assert.Equal(t, errCodeNotFound, envelope.Error.Code)
+	})
+
+	t.Run("internal error", func(t *testing.T) {
+		buf := captureLogs(t, logConfig{level: slog.LevelInfo})
+		db, err := openSQLiteStore(filepath.Join(t.TempDir(), "comments.db"))
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+		handler, err := newServer(db, testAPIToken).routes()
+		require.NoError(t, err)
+		broken := &testServer{handler: handler, projectDir: ts.projectDir}
+
+		rec := broken.do(t, http.MethodGet, "/api/v1/comments?project_directory="+url.QueryEscape(ts.projectDir), nil)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		var envelope apiErrorEnvelope
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&envelope))
+		assert.Equal(t, APIError{Code: errCodeInternal, Message: "Internal server error", RequestID: rec.Header().Get("X-Request-Id")}, envelope.Error)
+		assert.Contains(t, buf.String(), "msg=\"Internal error\" request_id="+envelope.Error.RequestID+` error="sql: database is closed"`)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		rec := ts.do(t, http.MethodPut, "/api/v1/comments", nil)
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+		var envelope apiErrorEnvelope
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&envelope))
+		assert.Equal(t, errCodeMethodNotAllowed, envelope.Error.Code)
+	})
+}
+
+func TestAPI_ServesOpenAPISpec(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodGet, "/api/v1/openapi.json", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, string(openAPISpec), rec.Body.String())
+}
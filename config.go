@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// projectConfigName is the per-project config file, in the project directory
+const projectConfigName = ".claude-review.toml"
+
+// Config holds the user's settings. Each comes from, in increasing order of
+// precedence: its default, the global config file, the project's
+// .claude-review.toml and, where a key has one, an environment variable.
+type Config struct {
+	Port        int
+	IgnoredDirs []string
+	Extensions  []string
+	Theme       string
+	// TrustedProjects are the projects whose documents may use classes, ids
+	// and inline styles
+	TrustedProjects []string
+	Title           string
+	UserName        string
+	AgentName       string
+
+	// sources records where each key's value came from
+	sources map[string]string
+}
+
+// configKey describes one setting of the config files
+type configKey struct {
+	name string
+	help string
+	// env overrides the config files when set
+	env string
+	// globalOnly keys cannot be set per project: one daemon serves them all
+	globalOnly bool
+	// field returns a pointer to the key's field: *int, *string or *[]string
+	field    func(c *Config) interface{}
+	validate func(value interface{}) error
+}
+
+// configKeys are the settings, in the order `config list` prints them
+var configKeys = []configKey{
+	{
+		name:       "port",
+		help:       "Port the server tries first",
+		env:        "CR_LISTEN_PORT",
+		globalOnly: true,
+		field:      func(c *Config) interface{} { return &c.Port },
+		validate: func(value interface{}) error {
+			if port := value.(int); port < 1 || port > 65535 {
+				return fmt.Errorf("%d is not a port number", port)
+			}
+			return nil
+		},
+	},
+	{
+		name:  "ignored_dirs",
+		help:  "Directories left out of listings and live updates",
+		field: func(c *Config) interface{} { return &c.IgnoredDirs },
+		validate: func(value interface{}) error {
+			for _, name := range value.([]string) {
+				if strings.ContainsRune(name, filepath.Separator) {
+					return fmt.Errorf("%q is a path, not a directory name", name)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name:  "extensions",
+		help:  "File extensions rendered as Markdown documents",
+		field: func(c *Config) interface{} { return &c.Extensions },
+		validate: func(value interface{}) error {
+			extensions := value.([]string)
+			if len(extensions) == 0 {
+				return errors.New("at least one extension is needed")
+			}
+			for _, ext := range extensions {
+				if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsRune(ext, filepath.Separator) {
+					return fmt.Errorf("%q is not an extension such as .md", ext)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name:  "theme",
+		help:  "Chroma style of highlighted code blocks",
+		field: func(c *Config) interface{} { return &c.Theme },
+		validate: func(value interface{}) error {
+			if _, ok := styles.Registry[value.(string)]; !ok {
+				return fmt.Errorf("unknown theme %q", value)
+			}
+			return nil
+		},
+	},
+	{
+		// Trust is only granted from the global config, so that a repository
+		// cannot vouch for itself
+		name:       "trusted_projects",
+		help:       "Projects whose documents may use classes, ids and inline styles",
+		globalOnly: true,
+		field:      func(c *Config) interface{} { return &c.TrustedProjects },
+		validate: func(value interface{}) error {
+			for _, dir := range value.([]string) {
+				if !filepath.IsAbs(dir) {
+					return fmt.Errorf("%q is not an absolute path", dir)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name:     "labels.title",
+		help:     "Name shown in page titles and the home page heading",
+		field:    func(c *Config) interface{} { return &c.Title },
+		validate: requireText,
+	},
+	{
+		name:     "authors.user",
+		help:     "Name shown on your comments",
+		field:    func(c *Config) interface{} { return &c.UserName },
+		validate: requireText,
+	},
+	{
+		name:     "authors.agent",
+		help:     "Name shown on the agent's replies",
+		field:    func(c *Config) interface{} { return &c.AgentName },
+		validate: requireText,
+	},
+}
+
+func requireText(value interface{}) error {
+	if strings.TrimSpace(value.(string)) == "" {
+		return errors.New("must not be empty")
+	}
+	return nil
+}
+
+// defaultConfig returns the settings without any config file
+func defaultConfig() *Config {
+	port, _ := strconv.Atoi(defaultPort)
+	c := &Config{
+		Port: port,
+		IgnoredDirs: []string{
+			".DS_Store", ".direnv", ".git", ".idea", ".mypy_cache", ".next", ".nuxt", ".pytest_cache",
+			".ruff_cache", ".venv", ".vim", ".vscode", "__pycache__", "build", "dist", "node_modules",
+			"target", "vendor", "venv",
+		},
+		Extensions:      []string{".md"},
+		Theme:           defaultTheme,
+		TrustedProjects: []string{},
+		Title:           "Claude Review",
+		UserName:        "User",
+		AgentName:       "Agent",
+		sources:         make(map[string]string),
+	}
+	for _, key := range configKeys {
+		c.sources[key.name] = "default"
+	}
+	return c
+}
+
+// lookupConfigKey returns the key with the given name
+func lookupConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key, nil
+		}
+	}
+	return configKey{}, fmt.Errorf("unknown config key %q", name)
+}
+
+// get returns the value of key
+func (c *Config) get(key configKey) interface{} {
+	switch field := key.field(c).(type) {
+	case *int:
+		return *field
+	case *string:
+		return *field
+	case *[]string:
+		return *field
+	}
+	panic("unsupported config field for " + key.name)
+}
+
+// set validates value and stores it as key's, recording where it came from
+func (c *Config) set(key configKey, value interface{}, source string) error {
+	if err := key.validate(value); err != nil {
+		return fmt.Errorf("invalid %s: %w", key.name, err)
+	}
+	switch field := key.field(c).(type) {
+	case *int:
+		*field = value.(int)
+	case *string:
+		*field = value.(string)
+	case *[]string:
+		*field = value.([]string)
+	}
+	c.sources[key.name] = source
+	return nil
+}
+
+// skipDir reports whether directories with this name are ignored
+func (c *Config) skipDir(name string) bool {
+	for _, ignored := range c.IgnoredDirs {
+		if name == ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// isDocument reports whether a file with this name is rendered as Markdown.
+// Extensions may come from a project's own config file, so dotfiles and names
+// the raw file policy treats as secrets never qualify.
+func (c *Config) isDocument(name string) bool {
+	if isSecretFileName(name) {
+		return false
+	}
+	lower := strings.ToLower(name)
+	for _, ext := range c.Extensions {
+		if strings.HasSuffix(lower, strings.ToLower(ext)) {
+			return true
+		}
+	}
+	return false
+}
+
+// documentSource returns the sanitizer policy for the documents of projectDir
+func (c *Config) documentSource(projectDir string) htmlSource {
+	for _, dir := range c.TrustedProjects {
+		if filepath.Clean(dir) == filepath.Clean(projectDir) {
+			return sourceTrustedDocument
+		}
+	}
+	return sourceDocument
+}
+
+// getGlobalConfigPath returns the path to the global config file, under the
+// XDG config directory
+func getGlobalConfigPath() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configDir, "claude-review", "config.toml"), nil
+}
+
+// loadConfig returns the settings for a project, or the global settings if
+// projectDir is empty
+func loadConfig(projectDir string) (*Config, error) {
+	c := defaultConfig()
+
+	globalPath, err := getGlobalConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.apply(globalPath, false); err != nil {
+		return nil, err
+	}
+	if projectDir != "" {
+		if err := c.apply(filepath.Join(projectDir, projectConfigName), true); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range configKeys {
+		if key.env == "" {
+			continue
+		}
+		raw := os.Getenv(key.env)
+		if raw == "" {
+			continue
+		}
+		value, err := parseConfigValue(key, raw)
+		if err == nil {
+			err = c.set(key, value, "$"+key.env)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key.env, err)
+		}
+	}
+	return c, nil
+}
+
+// apply sets the keys of the config file at path, if it exists
+func (c *Config) apply(path string, project bool) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, key := range configKeys {
+		value, ok := values[key.name]
+		if !ok {
+			continue
+		}
+		if project && key.globalOnly {
+			return fmt.Errorf("%s: %s can only be set in the global config", path, key.name)
+		}
+		if err := c.set(key, value, path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// configFor returns the settings for a project, for the daemon's use. A broken
+// config file is logged and skipped rather than failing requests.
+func configFor(projectDir string) *Config {
+	c, err := loadConfig(projectDir)
+	if err == nil {
+		return c
+	}
+	slog.Warn("Ignoring invalid configuration", "project", projectDir, "error", err)
+	if projectDir != "" {
+		if c, err := loadConfig(""); err == nil {
+			return c
+		}
+	}
+	return defaultConfig()
+}
+
+// cachedConfigFile is a parsed config file and the state it was read in
+type cachedConfigFile struct {
+	modTime time.Time
+	size    int64
+	values  map[string]interface{}
+}
+
+// configFileCache saves the daemon parsing config files on every request
+var configFileCache = struct {
+	sync.Mutex
+	files map[string]cachedConfigFile
+}{files: make(map[string]cachedConfigFile)}
+
+// readConfigFile returns the values of the config file at path by key name,
+// converted to the key's type. A missing file has no values.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	configFileCache.Lock()
+	cached, ok := configFileCache.files[path]
+	configFileCache.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.values, nil
+	}
+
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	values := make(map[string]interface{})
+	for name, value := range flattenConfig("", raw) {
+		key, err := lookupConfigKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		converted, err := convertConfigValue(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		values[name] = converted
+	}
+
+	configFileCache.Lock()
+	configFileCache.files[path] = cachedConfigFile{modTime: info.ModTime(), size: info.Size(), values: values}
+	configFileCache.Unlock()
+	return values, nil
+}
+
+// flattenConfig names the values of TOML tables by their dotted path
+func flattenConfig(prefix string, table map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for name, value := range table {
+		if sub, ok := value.(map[string]interface{}); ok {
+			for subName, subValue := range flattenConfig(prefix+name+".", sub) {
+				flat[subName] = subValue
+			}
+			continue
+		}
+		flat[prefix+name] = value
+	}
+	return flat
+}
+
+// convertConfigValue converts a decoded TOML value to the type of key
+func convertConfigValue(key configKey, value interface{}) (interface{}, error) {
+	switch key.field(&Config{}).(type) {
+	case *int:
+		if n, ok := value.(int64); ok {
+			return int(n), nil
+		}
+		return nil, fmt.Errorf("%s must be a number", key.name)
+	case *string:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%s must be a string", key.name)
+	default:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of strings", key.name)
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", key.name)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+}
+
+// parseConfigValue parses a value given on the command line or in an
+// environment variable. Lists are comma-separated.
+func parseConfigValue(key configKey, raw string) (interface{}, error) {
+	switch key.field(&Config{}).(type) {
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return n, nil
+	case *string:
+		return raw, nil
+	default:
+		list := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list, nil
+	}
+}
+
+// formatConfigValue formats a value the way parseConfigValue reads it
+func formatConfigValue(value interface{}) string {
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeConfigValue sets key to value in the config file at path. Only the
+// key's line changes, so comments and the order of the other settings
+// survive. A layout the line edit cannot handle, such as a value spread over
+// several lines, makes it rewrite the whole file instead.
+func writeConfigValue(path string, key configKey, value interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	raw := make(map[string]interface{})
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	// Encode the value on its own, and work out the settings the edited file
+	// must decode to
+	parts := strings.Split(key.name, ".")
+	leaf := parts[len(parts)-1]
+	var encoded bytes.Buffer
+	if err := toml.NewEncoder(&encoded).Encode(map[string]interface{}{leaf: value}); err != nil {
+		return err
+	}
+	var decoded map[string]interface{}
+	if _, err := toml.Decode(encoded.String(), &decoded); err != nil {
+		return err
+	}
+	want := flattenConfig("", raw)
+	want[key.name] = decoded[leaf]
+	_, valueText, _ := strings.Cut(strings.TrimSpace(encoded.String()), " = ")
+
+	var content string
+	for _, keepComment := range []bool{true, false} {
+		candidate := editConfigLine(string(data), key.name, valueText, keepComment)
+		var edited map[string]interface{}
+		if _, err := toml.Decode(candidate, &edited); err == nil && reflect.DeepEqual(flattenConfig("", edited), want) {
+			content = candidate
+			break
+		}
+	}
+	if content == "" {
+		// Descend into the key's table, creating it if needed
+		table := raw
+		for _, part := range parts[:len(parts)-1] {
+			sub, ok := table[part].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				table[part] = sub
+			}
+			table = sub
+		}
+		table[leaf] = value
+
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+			return err
+		}
+		content = buf.String()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+// editConfigLine sets the key called name to value, a TOML value, in the text
+// of a config file. It replaces the key's line, keeping a comment at its end
+// if keepComment is set, or adds a line at the end of the key's table.
+func editConfigLine(content, name, value string, keepComment bool) string {
+	table, leaf := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		table, leaf = name[:i], name[i+1:]
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n"
+	}
+
+	current, tableSeen := "", table == ""
+	insertAt, firstHeader := -1, len(lines)
+	for i, line := range lines {
+		code := strings.TrimSpace(line)
+		if code == "" || strings.HasPrefix(code, "#") {
+			continue
+		}
+		if strings.HasPrefix(code, "[") {
+			current = ""
+			if end := strings.Index(code, "]"); end > 0 && !strings.HasPrefix(code, "[[") {
+				current = normalizeConfigName(code[1:end])
+			}
+			firstHeader = min(firstHeader, i)
+			if current == table {
+				tableSeen, insertAt = true, i+1
+			}
+			continue
+		}
+		if current != table {
+			continue
+		}
+		insertAt = i + 1
+
+		keyText, _, found := strings.Cut(line, "=")
+		if !found || normalizeConfigName(keyText) != leaf {
+			continue
+		}
+		replacement := strings.TrimRight(keyText, " \t") + " = " + value
+		if hash := strings.LastIndex(line, "#"); keepComment && hash > len(keyText) {
+			replacement = fmt.Sprintf("%-*s%s", hash, replacement+" ", strings.TrimRight(line[hash:], "\n"))
+		}
+		lines[i] = replacement + "\n"
+		return strings.Join(lines, "")
+	}
+
+	switch {
+	case !tableSeen:
+		if len(lines) > 0 {
+			lines = append(lines, "\n")
+		}
+		lines = append(lines, "["+table+"]\n")
+		insertAt = len(lines)
+	case insertAt < 0:
+		// A top-level key goes before the first table
+		insertAt = firstHeader
+	}
+	return strings.Join(slices.Insert(lines, insertAt, leaf+" = "+value+"\n"), "")
+}
+
+// normalizeConfigName strips the spaces and quotes of a dotted TOML key
+func normalizeConfigName(raw string) string {
+	parts := strings.Split(raw, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+// printConfig writes every setting with where its value came from
+func printConfig(w io.Writer, c *Config) {
+	width := 0
+	for _, key := range configKeys {
+		width = max(width, len(key.name))
+	}
+	for _, key := range configKeys {
+		fmt.Fprintf(w, "%-*s = %s  (%s)\n", width, key.name, formatConfigValue(c.get(key)), c.sources[key.name])
+	}
+}
+
+// setConfig validates and writes a setting to the global config, or to the
+// project's .claude-review.toml if projectDir is set
+func setConfig(name, raw, projectDir string) (string, error) {
+	key, err := lookupConfigKey(name)
+	if err != nil {
+		return "", err
+	}
+	if projectDir != "" && key.globalOnly {
+		return "", fmt.Errorf("%s can only be set in the global config", name)
+	}
+	value, err := parseConfigValue(key, raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if err := key.validate(value); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	path := filepath.Join(projectDir, projectConfigName)
+	if projectDir == "" {
+		if path, err = getGlobalConfigPath(); err != nil {
+			return "", err
+		}
+	}
+	return path, writeConfigValue(path, key, value)
+}
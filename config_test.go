@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupConfigDirs points the global config at a temporary directory and
+// returns its path and a project directory
+func setupConfigDirs(t *testing.T) (globalPath, projectDir string) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("CR_LISTEN_PORT", "")
+
+	globalPath, err := getGlobalConfigPath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(globalPath), 0755))
+	return globalPath, t.TempDir()
+}
+
+func TestLoadConfig(t *testing.T) {
+	globalPath, projectDir := setupConfigDirs(t)
+
+	cfg, err := loadConfig(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, 4779, cfg.Port)
+	assert.Equal(t, []string{".md"}, cfg.Extensions)
+	assert.Equal(t, defaultTheme, cfg.Theme)
+	assert.True(t, cfg.skipDir("node_modules"))
+	assert.Equal(t, "default", cfg.sources["theme"])
+
+	require.NoError(t, os.WriteFile(globalPath, []byte(`
+port = 4800
+theme = "monokai"
+extensions = [".md", ".markdown"]
+
+[authors]
+user = "Alex"
+`), 0644))
+	projectPath := filepath.Join(projectDir, projectConfigName)
+	require.NoError(t, os.WriteFile(projectPath, []byte(`
+theme = "github"
+ignored_dirs = ["generated"]
+
+[authors]
+agent = "Claude"
+`), 0644))
+
+	cfg, err = loadConfig(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, 4800, cfg.Port)
+	assert.Equal(t, "github", cfg.Theme, "the project overrides the global config")
+	assert.Equal(t, projectPath, cfg.sources["theme"])
+	assert.Equal(t, []string{".md", ".markdown"}, cfg.Extensions)
+	assert.Equal(t, globalPath, cfg.sources["extensions"])
+	assert.True(t, cfg.isDocument("README.MARKDOWN"))
+	assert.Equal(t, []string{"generated"}, cfg.IgnoredDirs, "lists are replaced, not merged")
+	assert.False(t, cfg.skipDir("node_modules"))
+	assert.Equal(t, "Alex", cfg.UserName)
+	assert.Equal(t, "Claude", cfg.AgentName)
+
+	// Without a project, only the global config applies
+	cfg, err = loadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "monokai", cfg.Theme)
+
+	t.Setenv("CR_LISTEN_PORT", "4900")
+	cfg, err = loadConfig(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, 4900, cfg.Port, "the environment overrides the config files")
+	assert.Equal(t, "$CR_LISTEN_PORT", cfg.sources["port"])
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	for name, tt := range map[string]struct {
+		global, project string
+		wantErr         string
+	}{
+		"syntax":             {global: "theme = ", wantErr: "failed to parse"},
+		"unknown key":        {global: "colour = \"red\"\n", wantErr: `unknown config key "colour"`},
+		"unknown table key":  {global: "[authors]\nbot = \"x\"\n", wantErr: `unknown config key "authors.bot"`},
+		"wrong type":         {global: "port = \"4800\"\n", wantErr: "port must be a number"},
+		"wrong list type":    {global: "extensions = \".md\"\n", wantErr: "extensions must be a list of strings"},
+		"bad port":           {global: "port = 70000\n", wantErr: "70000 is not a port number"},
+		"bad extension":      {global: "extensions = [\"md\"]\n", wantErr: `"md" is not an extension`},
+		"no extensions":      {global: "extensions = []\n", wantErr: "at least one extension"},
+		"unknown theme":      {global: "theme = \"neon\"\n", wantErr: `unknown theme "neon"`},
+		"empty name":         {global: "[authors]\nuser = \" \"\n", wantErr: "must not be empty"},
+		"port in project":    {project: "port = 4800\n", wantErr: "port can only be set in the global config"},
+		"project theme type": {project: "theme = 1\n", wantErr: "theme must be a string"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			globalPath, projectDir := setupConfigDirs(t)
+			if tt.global != "" {
+				require.NoError(t, os.WriteFile(globalPath, []byte(tt.global), 0644))
+			}
+			if tt.project != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(projectDir, projectConfigName), []byte(tt.project), 0644))
+			}
+
+			_, err := loadConfig(projectDir)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("environment", func(t *testing.T) {
+		setupConfigDirs(t)
+		t.Setenv("CR_LISTEN_PORT", "http")
+		_, err := loadConfig("")
+		assert.ErrorContains(t, err, "invalid CR_LISTEN_PORT")
+	})
+}
+
+func TestConfigFor_InvalidProject(t *testing.T) {
+	globalPath, projectDir := setupConfigDirs(t)
+	require.NoError(t, os.WriteFile(globalPath, []byte("theme = \"monokai\"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, projectConfigName), []byte("theme = \"neon\"\n"), 0644))
+
+	assert.Equal(t, "monokai", configFor(projectDir).Theme, "a broken project config falls back to the global one")
+}
+
+func TestDocumentSource(t *testing.T) {
+	globalPath, projectDir := setupConfigDirs(t)
+	cfg, err := loadConfig(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, sourceDocument, cfg.documentSource(projectDir))
+
+	require.NoError(t, os.WriteFile(globalPath, []byte(fmt.Sprintf("trusted_projects = [%q]\n", projectDir+"/")), 0644))
+	cfg, err = loadConfig(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, sourceTrustedDocument, cfg.documentSource(projectDir))
+	assert.Equal(t, sourceDocument, cfg.documentSource(t.TempDir()))
+
+	// A project cannot trust itself
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, projectConfigName), []byte(fmt.Sprintf("trusted_projects = [%q]\n", projectDir)), 0644))
+	_, err = loadConfig(projectDir)
+	assert.ErrorContains(t, err, "trusted_projects can only be set in the global config")
+}
+
+func TestIsDocument_Secrets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Extensions = []string{".md", ".env", ".pem", ".key"}
+
+	assert.True(t, cfg.isDocument("plan.md"))
+	assert.False(t, cfg.isDocument(".env"), "dotfiles are never documents")
+	assert.False(t, cfg.isDocument("server.pem"))
+	assert.False(t, cfg.isDocument("deploy.KEY"))
+	assert.False(t, cfg.isDocument(".notes.md"))
+}
+
+func TestSetConfig(t *testing.T) {
+	globalPath, projectDir := setupConfigDirs(t)
+
+	path, err := setConfig("extensions", ".md, .markdown", "")
+	require.NoError(t, err)
+	assert.Equal(t, globalPath, path)
+	path, err = setConfig("authors.agent", "Claude", "")
+	require.NoError(t, err)
+	assert.Equal(t, globalPath, path)
+
+	path, err = setConfig("theme", "github", projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(projectDir, projectConfigName), path)
+
+	cfg, err := loadConfig(projectDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{".md", ".markdown"}, cfg.Extensions)
+	assert.Equal(t, "Claude", cfg.AgentName)
+	assert.Equal(t, "github", cfg.Theme)
+
+	// Rewriting a file keeps its other settings
+	_, err = setConfig("authors.user", "Alex", "")
+	require.NoError(t, err)
+	cfg, err = loadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "Claude", cfg.AgentName)
+	assert.Equal(t, "Alex", cfg.UserName)
+
+	_, err = setConfig("port", "4800", projectDir)
+	assert.ErrorContains(t, err, "can only be set in the global config")
+	_, err = setConfig("port", "many", "")
+	assert.ErrorContains(t, err, `"many" is not a number`)
+	_, err = setConfig("theme", "neon", "")
+	assert.ErrorContains(t, err, `unknown theme "neon"`)
+	_, err = setConfig("colour", "red", "")
+	assert.ErrorContains(t, err, `unknown config key "colour"`)
+
+	// Only the key's line changes
+	require.NoError(t, os.WriteFile(globalPath, []byte(`# Shared settings
+theme = "github"                    # Chroma style of code blocks
+extensions = [".md", ".markdown"]   # Files rendered as documents
+
+[authors]
+user = "Alex"   # shown on comments
+`), 0644))
+	_, err = setConfig("theme", "monokai", "")
+	require.NoError(t, err)
+	_, err = setConfig("authors.user", "Sam", "")
+	require.NoError(t, err)
+	_, err = setConfig("authors.agent", "Claude", "")
+	require.NoError(t, err)
+	_, err = setConfig("labels.title", "Team Review", "")
+	require.NoError(t, err)
+	_, err = setConfig("ignored_dirs", "generated", "")
+	require.NoError(t, err)
+	data, err := os.ReadFile(globalPath)
+	require.NoError(t, err)
+	assert.Equal(t, `# Shared settings
+theme = "monokai"                   # Chroma style of code blocks
+extensions = [".md", ".markdown"]   # Files rendered as documents
+ignored_dirs = ["generated"]
+
+[authors]
+user = "Sam"    # shown on comments
+agent = "Claude"
+
+[labels]
+title = "Team Review"
+`, string(data))
+
+	// A layout the line edit cannot handle is rewritten
+	require.NoError(t, os.WriteFile(globalPath, []byte("ignored_dirs = [\n  \"a\",\n]\ntheme = \"github\"\n"), 0644))
+	_, err = setConfig("ignored_dirs", "b", "")
+	require.NoError(t, err)
+	rewritten, err := loadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, rewritten.IgnoredDirs)
+	assert.Equal(t, "github", rewritten.Theme)
+
+	var out bytes.Buffer
+	printConfig(&out, cfg)
+	assert.Contains(t, out.String(), "port             = 4779  (default)\n")
+	assert.Contains(t, out.String(), "extensions       = .md,.markdown  ("+globalPath+")\n")
+}
+
+func TestHandlers_Config(t *testing.T) {
+	ts := newTestServer(t)
+	require.NoError(t, os.WriteFile(filepath.Join(ts.projectDir, projectConfigName), []byte(`
+extensions = [".md", ".markdown"]
+ignored_dirs = ["drafts"]
+
+[labels]
+title = "Team Review"
+
+[authors]
+user = "Alex"
+agent = "Claude"
+`), 0644))
+	for _, dir := range []string{"drafts", "node_modules"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(ts.projectDir, dir), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(ts.projectDir, dir, "notes.md"), []byte("# Notes\n"), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(ts.projectDir, "plan.markdown"), []byte("# Plan\n"), 0644))
+
+	rec := ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "plan.markdown")
+	assert.Contains(t, body, "node_modules/", "the project's list replaces the default")
+	assert.NotContains(t, body, "drafts/")
+	assert.Contains(t, body, " - Team Review</title>")
+
+	rec = ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/plan.markdown", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	body = rec.Body.String()
+	assert.Contains(t, body, "data-line-start", "rendered as a document")
+	assert.Contains(t, body, `<meta name="claude-review-author-user" content="Alex" />`)
+	assert.Contains(t, body, `<meta name="claude-review-author-agent" content="Claude" />`)
+}
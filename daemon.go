@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 // getPIDFilePath returns the path to the PID file
@@ -20,18 +27,14 @@ func getPIDFilePath() (string, error) {
 	return filepath.Join(dataDir, "server.pid"), nil
 }
 
-// writePIDFile writes the current process PID to the PID file
+// writePIDFile writes the current process PID to the PID file. It is for
+// humans and scripts: the instance lock decides whether a server runs.
 func writePIDFile() error {
 	pidFile, err := getPIDFilePath()
 	if err != nil {
 		return err
 	}
 
-	// Check if already running
-	if isServerRunning() {
-		return fmt.Errorf("server is already running (PID file exists: %s)", pidFile)
-	}
-
 	pid := os.Getpid()
 	return os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", pid)), 0644)
 }
@@ -49,67 +52,36 @@ func removePIDFile() error {
 	return nil
 }
 
-// readPID reads the PID from the PID file
-func readPID() (int, error) {
-	pidFile, err := getPIDFilePath()
-	if err != nil {
-		return 0, err
-	}
-
-	data, err := os.ReadFile(pidFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil
-		}
-		return 0, err
-	}
-
-	pid, err := strconv.Atoi(string(data))
-	if err != nil {
-		return 0, fmt.Errorf("invalid PID in file: %w", err)
-	}
-
-	return pid, nil
-}
-
-// isProcessRunning checks if a process with the given PID is running
-func isProcessRunning(pid int) bool {
-	if pid <= 0 {
-		return false
-	}
-
-	// Send signal 0 to check if process exists
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
-	}
-
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
-}
-
-// isServerRunning checks if the server is running based on PID file
+// isServerRunning checks if a server holds the instance lock. A PID file left
+// behind by a server that is gone is cleaned up; its PID may well belong to
+// another process by now.
 func isServerRunning() bool {
-	pid, err := readPID()
-	if err != nil || pid == 0 {
-		return false
+	if instanceLocked() {
+		return true
 	}
-
-	if !isProcessRunning(pid) {
-		// PID file exists but process is not running - clean up stale PID file
-		_ = removePIDFile()
-		return false
-	}
-
-	return true
+	_ = removePIDFile()
+	return false
 }
 
-// daemonize starts the process as a daemon using double-fork
+// daemonize starts the process as a daemon using double-fork, unless the
+// systemd service is installed
 func daemonize() error {
 	// Check if server is already running
 	if isServerRunning() {
-		pidFile, _ := getPIDFilePath()
-		return fmt.Errorf("server is already running (PID file exists: %s)", pidFile)
+		lockFile, _ := getLockFilePath()
+		return fmt.Errorf("server is already running (lock held: %s)", lockFile)
+	}
+
+	// Once the service is installed, systemd runs the server
+	if address, ok := installedSocketAddress(); ok {
+		state, err := activateService(address)
+		if err == nil {
+			fmt.Printf("Server started by systemd (%s)\n", socketUnitName)
+			fmt.Printf("Port: %d\n", state.Port)
+			fmt.Printf("URL: %s\n", state.URL)
+			return nil
+		}
+		log.Printf("Socket activation through %s failed, starting the server directly: %v", address, err)
 	}
 
 	// Get the executable path
@@ -148,57 +120,123 @@ func daemonize() error {
 		return fmt.Errorf("failed to start daemon: %w", err)
 	}
 
-	// Parent exits immediately
-	pidFile, _ := getPIDFilePath()
-	port := os.Getenv("CR_LISTEN_PORT")
-	if port == "" {
-		port = "4779"
+	// Wait until the daemon knows its port, which may not be the preferred one
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	state, err := waitForDaemonState(cmd.Process.Pid, exited, 10*time.Second)
+	if err != nil {
+		// Another command may have started a server at the same moment, in
+		// which case ours lost the race for the lock. Use the winner.
+		if winner, waitErr := waitForRunningServer(5 * time.Second); waitErr == nil {
+			fmt.Printf("Server already started by another process (PID: %d)\n", winner.PID)
+			fmt.Printf("URL: %s\n", winner.URL)
+			return nil
+		}
+		return fmt.Errorf("%w (see %s)", err, logFile)
 	}
+
+	pidFile, _ := getPIDFilePath()
 	fmt.Printf("Server started as daemon\n")
-	fmt.Printf("Port: %s\n", port)
+	fmt.Printf("Port: %d\n", state.Port)
+	fmt.Printf("URL: %s\n", state.URL)
 	fmt.Printf("PID file: %s\n", pidFile)
 	fmt.Printf("Log file: %s\n", logFile)
 	return nil
 }
 
-// setupSignalHandlers sets up graceful shutdown on SIGTERM/SIGINT
-func setupSignalHandlers() {
+// defaultDrainTimeout is how long shutdown waits for in-flight requests when
+// CR_DRAIN_TIMEOUT is unset
+const defaultDrainTimeout = 10 * time.Second
+
+// getDrainTimeout reads CR_DRAIN_TIMEOUT (e.g. "30s")
+func getDrainTimeout() (time.Duration, error) {
+	value := os.Getenv("CR_DRAIN_TIMEOUT")
+	if value == "" {
+		return defaultDrainTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid CR_DRAIN_TIMEOUT %q", value)
+	}
+	return timeout, nil
+}
+
+// setupSignalHandlers sets up graceful shutdown on SIGTERM/SIGINT, or once
+// idle is closed. srv serves HTTP and rpcSrv the Unix socket. The returned
+// channel is closed once shutdown has completed.
+func setupSignalHandlers(srv, rpcSrv *http.Server, store Store, drainTimeout time.Duration, idle <-chan struct{}) <-chan struct{} {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
 
+	stopped := make(chan struct{})
 	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal: %v, shutting down gracefully...", sig)
-
-		// Cleanup PID file
-		if err := removePIDFile(); err != nil {
-			log.Printf("Failed to remove PID file: %v", err)
+		select {
+		case sig := <-sigChan:
+			slog.Info("Received signal, shutting down gracefully", "signal", sig.String())
+		case <-idle:
+			slog.Info("No SSE clients or API calls, shutting down gracefully", "idle_timeout", idleShutdown.timeout)
 		}
+		shutdownServer(srv, rpcSrv, store, drainTimeout)
+		close(stopped)
+	}()
+	return stopped
+}
 
-		// Close database
-		if db != nil {
-			_ = db.Close()
+// shutdownServer stops the daemon. The listeners close first and SSE clients
+// receive server_stopping (see SSEHub.shutdown). In-flight requests and queued
+// stream writes get up to drainTimeout to finish before their connections are
+// closed. Then the watcher and database are closed, and the PID file goes
+// last, so the daemon counts as running until it is really done.
+func shutdownServer(srv, rpcSrv *http.Server, store Store, drainTimeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, s := range []*http.Server{srv, rpcSrv} {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Warn("Requests still in flight, closing their connections", "drain_timeout", drainTimeout, "error", err)
+			_ = s.Close()
 		}
+	}
 
-		os.Exit(0)
-	}()
-}
+	// CLI commands write the database themselves from now on
+	if err := removeSocketFile(); err != nil {
+		slog.Error("Failed to remove socket", "error", err)
+	}
 
-// stopDaemon stops the running daemon
-func stopDaemon() error {
-	pid, err := readPID()
-	if err != nil {
-		return fmt.Errorf("failed to read PID file: %w", err)
+	if fileWatcher != nil {
+		_ = fileWatcher.close()
+	}
+
+	// Close database
+	if store != nil {
+		_ = store.Close()
+	}
+
+	if err := removeStateFile(); err != nil {
+		slog.Error("Failed to remove state file", "error", err)
 	}
 
-	if pid == 0 {
-		return fmt.Errorf("server is not running (no PID file found)")
+	// Cleanup PID file
+	if err := removePIDFile(); err != nil {
+		slog.Error("Failed to remove PID file", "error", err)
 	}
 
-	if !isProcessRunning(pid) {
-		// Clean up stale PID file
-		_ = removePIDFile()
-		return fmt.Errorf("server is not running (stale PID file)")
+	slog.Info("Server stopped")
+}
+
+// stopHandshakeTimeout is how long stopDaemon waits for the server to answer
+// before it falls back on the PID file
+var stopHandshakeTimeout = 5 * time.Second
+
+// stopDaemon sends SIGTERM to the running daemon. With force, a daemon that
+// still holds the instance lock once the drain timeout has passed is killed.
+func stopDaemon(force bool) error {
+	if !isServerRunning() {
+		return errServerNotRunning
+	}
+
+	pid, err := runningServerPID()
+	if err != nil {
+		return err
 	}
 
 	// Send SIGTERM
@@ -212,40 +250,188 @@ func stopDaemon() error {
 	}
 
 	fmt.Printf("Sent SIGTERM to server (PID: %d)\n", pid)
-	return nil
+	if !force {
+		return nil
+	}
+
+	timeout := stopTimeout()
+	if waitForServerStopped(timeout) == nil {
+		return nil
+	}
+	if err := process.Signal(syscall.SIGKILL); err != nil {
+		return fmt.Errorf("failed to send SIGKILL: %w", err)
+	}
+	fmt.Printf("Server did not stop within %s, sent SIGKILL (PID: %d)\n", timeout, pid)
+	return waitForServerStopped(5 * time.Second)
 }
 
-// statusDaemon checks and prints the daemon status
-// Returns nil (exit 0) if server is running, error (exit 1) if not running
-func statusDaemon() error {
-	pid, err := readPID()
+// runningServerPID returns the PID of the server holding the instance lock.
+// Only a server that passes the handshake proves its PID: the PID file may
+// name an unrelated process that reused the PID. A server that hangs cannot
+// answer, though, so then the PID file is trusted if it names a live process
+// of this user, as the lock shows the server that wrote it has not exited.
+func runningServerPID() (int, error) {
+	state, err := waitForRunningServer(stopHandshakeTimeout)
+	if err == nil {
+		return state.PID, nil
+	}
+	if errors.Is(err, errServerNotRunning) {
+		return 0, err
+	}
+
+	pidFile, pathErr := getPIDFilePath()
+	if pathErr != nil {
+		return 0, err
+	}
+	data, readErr := os.ReadFile(pidFile)
+	if readErr != nil {
+		return 0, fmt.Errorf("%w, and there is no PID file to fall back on", err)
+	}
+	pid, parseErr := strconv.Atoi(strings.TrimSpace(string(data)))
+	if parseErr != nil || !ownProcessAlive(pid) {
+		return 0, fmt.Errorf("%w, and %s names no live process of this user", err, pidFile)
+	}
+	if !instanceLocked() {
+		return 0, errServerNotRunning
+	}
+	log.Printf("Server does not answer (%v), using PID %d from %s", err, pid, pidFile)
+	return pid, nil
+}
+
+// ownProcessAlive reports whether pid is a live process this user may signal.
+// Signal 0 checks both without sending anything; short of root, a user may
+// only signal their own processes.
+func ownProcessAlive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// shutdownSlack is how long a stopped server may take to exit beyond the
+// drain timeout, closing the store and the listeners
+var shutdownSlack = 5 * time.Second
+
+// stopTimeout is how long a stopped server may take to exit
+func stopTimeout() time.Duration {
+	drainTimeout, err := getDrainTimeout()
 	if err != nil {
-		return fmt.Errorf("failed to read PID file: %w", err)
+		drainTimeout = defaultDrainTimeout
 	}
+	return drainTimeout + shutdownSlack
+}
 
-	if pid == 0 {
+// statusDaemon checks and prints the daemon status, and its metrics if verbose
+// Returns nil (exit 0) if server is running, error (exit 1) if not running
+func statusDaemon(verbose bool) error {
+	if !isServerRunning() {
 		fmt.Println("Server is not running")
-		return fmt.Errorf("server not running")
+		return errServerNotRunning
 	}
 
-	if !isProcessRunning(pid) {
-		// Clean up stale PID file
-		_ = removePIDFile()
-		fmt.Println("Server is not running (stale PID file removed)")
-		return fmt.Errorf("server not running")
+	state, err := waitForRunningServer(5 * time.Second)
+	if err != nil {
+		fmt.Printf("Server is not responding: %v\n", err)
+		return err
 	}
 
 	pidFile, _ := getPIDFilePath()
 	dataDir, _ := getDataDir()
 	logFile := filepath.Join(dataDir, "server.log")
-	port := os.Getenv("CR_LISTEN_PORT")
-	if port == "" {
-		port = "4779"
-	}
 
-	fmt.Printf("Server is running (PID: %d)\n", pid)
-	fmt.Printf("Port: %s\n", port)
+	fmt.Printf("Server is running (PID: %d)\n", state.PID)
+	fmt.Printf("Port: %d\n", state.Port)
+	fmt.Printf("URL: %s\n", state.URL)
 	fmt.Printf("PID file: %s\n", pidFile)
 	fmt.Printf("Log file: %s\n", logFile)
+
+	status, err := fetchDaemonStatus(state.URL)
+	if err != nil {
+		fmt.Printf("Watcher: unknown (%v)\n", err)
+		return nil
+	}
+	if idle := status.Idle; idle != nil {
+		if idle.SSEClients > 0 {
+			fmt.Printf("Idle shutdown: paused while %d SSE client(s) are connected (after %s idle)\n", idle.SSEClients, idle.Timeout)
+		} else {
+			fmt.Printf("Idle shutdown: in %s (after %s idle)\n", idle.Remaining, idle.Timeout)
+		}
+	} else {
+		fmt.Println("Idle shutdown: disabled")
+	}
+	if w := status.Watcher; w != nil {
+		switch {
+		case w.Backend == watchBackendPoll && w.FallbackReason != "":
+			fmt.Printf("Watcher: poll every %s (fell back from fsnotify: %s)\n", w.PollInterval, w.FallbackReason)
+		case w.Backend == watchBackendPoll:
+			fmt.Printf("Watcher: poll every %s\n", w.PollInterval)
+		default:
+			fmt.Printf("Watcher: %s\n", w.Backend)
+		}
+	}
+	if verbose && status.Metrics != nil {
+		fmt.Println("Metrics:")
+		printMetrics(os.Stdout, *status.Metrics)
+	}
+	return nil
+}
+
+// fetchDaemonStatus asks the running daemon for its runtime state
+func fetchDaemonStatus(baseURL string) (*DaemonStatus, error) {
+	var status DaemonStatus
+	if err := getDaemonJSON(baseURL+apiPrefix+"/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// fetchDaemonVersion asks the running daemon which build it runs
+func fetchDaemonVersion(baseURL string) (*VersionInfo, error) {
+	var info VersionInfo
+	if err := getDaemonJSON(baseURL+"/api/version", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// getDaemonJSON fetches a JSON document from the daemon
+func getDaemonJSON(url string, v interface{}) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// restartDaemon stops the running server, if any, and starts a daemon from
+// this binary
+func restartDaemon() error {
+	if isServerRunning() {
+		if err := stopDaemon(false); err != nil {
+			return err
+		}
+
+		if err := waitForServerStopped(stopTimeout()); err != nil {
+			return err
+		}
+	}
+	return daemonize()
+}
+
+// waitForServerStopped waits until the running server has released the
+// instance lock
+func waitForServerStopped(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for instanceLocked() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server did not stop within %s", timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 	return nil
 }
@@ -3,7 +3,8 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,14 +13,6 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var debugSQL = os.Getenv("DEBUG_SQL") == "1"
-
-func logQuery(query string, args ...interface{}) {
-	if debugSQL {
-		log.Printf("[SQL] %s | args: %v", query, args)
-	}
-}
-
 type Project struct {
 	Directory string    `json:"directory"`
 	CreatedAt time.Time `json:"created_at"`
@@ -41,8 +34,6 @@ type Comment struct {
 	ResolvedBy       *string    `json:"resolved_by,omitempty"`
 }
 
-var db *sql.DB
-
 // getDataDir returns the data directory for claude-review and ensures it exists
 func getDataDir() (string, error) {
 	var dataDir string
@@ -66,18 +57,43 @@ func getDataDir() (string, error) {
 	return dataDir, nil
 }
 
-func initDB() error {
-	// Get data directory (ensures it exists)
+// SQLiteStore is the Store implementation backed by a SQLite database file
+type SQLiteStore struct {
+	db *sql.DB
+	// logger receives the queries at DEBUG; nil means the default logger
+	logger *slog.Logger
+}
+
+// withLogger returns a view of the store that logs its queries to logger,
+// such as one carrying a request ID
+func (s *SQLiteStore) withLogger(logger *slog.Logger) Store {
+	return &SQLiteStore{db: s.db, logger: logger}
+}
+
+// logQuery logs a query and its arguments at DEBUG
+func (s *SQLiteStore) logQuery(query string, args ...interface{}) {
+	logger := s.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Debug("SQL query", "query", strings.Join(strings.Fields(query), " "), "args", args)
+}
+
+// openStore opens the default SQLite store in the data directory
+func openStore() (Store, error) {
 	dbDir, err := getDataDir()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Open database
-	dbPath := filepath.Join(dbDir, "comments.db")
-	db, err = sql.Open("sqlite3", dbPath)
+	return openSQLiteStore(filepath.Join(dbDir, "comments.db"))
+}
+
+// openSQLiteStore opens (creating if needed) the SQLite database at dbPath and ensures the schema exists
+func openSQLiteStore(dbPath string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Create tables
@@ -107,27 +123,69 @@ func initDB() error {
 	CREATE INDEX IF NOT EXISTS idx_comments_thread ON comments(root_id, created_at);
 	`
 
-	logQuery(schema)
+	store := &SQLiteStore{db: db}
+	store.logQuery(schema)
 	if _, err := db.Exec(schema); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return store, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// checkDatabaseIntegrity runs SQLite's integrity check on the database at
+// dbPath. It opens the file read-only, so it neither creates nor changes it,
+// and it can run next to a live server.
+func checkDatabaseIntegrity(dbPath string) error {
+	dsn := (&url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro&_busy_timeout=5000"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
 	}
+	defer func() { _ = db.Close() }()
 
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
 	return nil
 }
 
-func createProject(directory string) (*Project, error) {
+func (s *SQLiteStore) CreateProject(directory string) (*Project, error) {
 	// Idempotent insert
 	query := "INSERT OR IGNORE INTO projects (directory) VALUES (?)"
-	logQuery(query, directory)
-	_, err := db.Exec(query, directory)
+	s.logQuery(query, directory)
+	_, err := s.db.Exec(query, directory)
 	if err != nil {
 		return nil, err
 	}
 
 	var project Project
 	query = "SELECT directory, created_at FROM projects WHERE directory = ?"
-	logQuery(query, directory)
-	err = db.QueryRow(query, directory).
+	s.logQuery(query, directory)
+	err = s.db.QueryRow(query, directory).
 		Scan(&project.Directory, &project.CreatedAt)
 	if err != nil {
 		return nil, err
@@ -136,10 +194,26 @@ func createProject(directory string) (*Project, error) {
 	return &project, nil
 }
 
-func getAllProjects() ([]Project, error) {
+func (s *SQLiteStore) GetProject(directory string) (*Project, error) {
+	query := "SELECT directory, created_at FROM projects WHERE directory = ?"
+	s.logQuery(query, directory)
+
+	var project Project
+	err := s.db.QueryRow(query, directory).Scan(&project.Directory, &project.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+func (s *SQLiteStore) GetAllProjects() ([]Project, error) {
 	query := "SELECT directory, created_at FROM projects ORDER BY created_at DESC"
-	logQuery(query)
-	rows, err := db.Query(query)
+	s.logQuery(query)
+	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -157,14 +231,43 @@ func getAllProjects() ([]Project, error) {
 	return projects, nil
 }
 
-func createComment(c *Comment) error {
+func (s *SQLiteStore) GetFileSummaries(projectDir string) ([]FileSummary, error) {
+	query := `
+		SELECT file_path,
+			SUM(CASE WHEN resolved_at IS NULL AND root_id IS NULL THEN 1 ELSE 0 END),
+			SUM(CASE WHEN resolved_at IS NULL THEN 1 ELSE 0 END),
+			SUM(CASE WHEN resolved_at IS NOT NULL THEN 1 ELSE 0 END)
+		FROM comments
+		WHERE project_directory = ?
+		GROUP BY file_path
+		ORDER BY file_path ASC`
+	s.logQuery(query, projectDir)
+	rows, err := s.db.Query(query, projectDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var summaries []FileSummary
+	for rows.Next() {
+		var fs FileSummary
+		if err := rows.Scan(&fs.FilePath, &fs.UnresolvedThreads, &fs.UnresolvedComments, &fs.ResolvedComments); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, fs)
+	}
+
+	return summaries, nil
+}
+
+func (s *SQLiteStore) CreateComment(c *Comment) error {
 	// Generate timestamp in Go
 	c.CreatedAt = time.Now()
 
 	query := `
 		INSERT INTO comments (project_directory, file_path, line_start, line_end, selected_text, comment_text, root_id, author, created_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	logQuery(
+	s.logQuery(
 		query,
 		c.ProjectDirectory,
 		c.FilePath,
@@ -176,7 +279,7 @@ func createComment(c *Comment) error {
 		c.Author,
 		c.CreatedAt,
 	)
-	result, err := db.Exec(
+	result, err := s.db.Exec(
 		query,
 		c.ProjectDirectory,
 		c.FilePath,
@@ -201,7 +304,7 @@ func createComment(c *Comment) error {
 	return nil
 }
 
-func getComments(projectDir, filePath string, resolved bool) ([]Comment, error) {
+func (s *SQLiteStore) GetComments(projectDir, filePath string, resolved bool) ([]Comment, error) {
 	var query string
 	if resolved {
 		query = `
@@ -216,8 +319,8 @@ func getComments(projectDir, filePath string, resolved bool) ([]Comment, error)
 			WHERE project_directory = ? AND file_path = ? AND resolved_at IS NULL
 			ORDER BY COALESCE(root_id, id) ASC, created_at ASC`
 	}
-	logQuery(query, projectDir, filePath)
-	rows, err := db.Query(query, projectDir, filePath)
+	s.logQuery(query, projectDir, filePath)
+	rows, err := s.db.Query(query, projectDir, filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -235,32 +338,64 @@ func getComments(projectDir, filePath string, resolved bool) ([]Comment, error)
 	return comments, nil
 }
 
-func updateComment(commentID, commentText string) error {
+func (s *SQLiteStore) ListComments(filter CommentFilter) ([]Comment, error) {
+	query := `
+		SELECT id, project_directory, file_path, line_start, line_end, selected_text, comment_text, created_at, resolved_at, root_id, author, resolved_by
+		FROM comments
+		WHERE project_directory = ?`
+	args := []interface{}{filter.ProjectDirectory}
+
+	if filter.FilePath != "" {
+		query += " AND file_path = ?"
+		args = append(args, filter.FilePath)
+	}
+
+	switch filter.Status {
+	case commentStatusResolved:
+		query += " AND resolved_at IS NOT NULL"
+	case commentStatusAll:
+	default:
+		query += " AND resolved_at IS NULL"
+	}
+
+	query += " ORDER BY file_path ASC, COALESCE(root_id, id) ASC, created_at ASC"
+
+	s.logQuery(query, args...)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanComments(rows)
+}
+
+func (s *SQLiteStore) UpdateComment(commentID int, commentText string) error {
 	query := `
 		UPDATE comments
 		SET comment_text = ?
 		WHERE id = ?`
-	logQuery(query, commentText, commentID)
-	_, err := db.Exec(query, commentText, commentID)
+	s.logQuery(query, commentText, commentID)
+	_, err := s.db.Exec(query, commentText, commentID)
 	return err
 }
 
-func deleteComment(commentID string) error {
+func (s *SQLiteStore) DeleteComment(commentID int) error {
 	query := `
 		DELETE FROM comments
-		WHERE id = ?`
-	logQuery(query, commentID)
-	_, err := db.Exec(query, commentID)
+		WHERE id = ? OR root_id = ?`
+	s.logQuery(query, commentID, commentID)
+	_, err := s.db.Exec(query, commentID, commentID)
 	return err
 }
 
-func resolveComments(projectDir, filePath string) (int, error) {
+func (s *SQLiteStore) ResolveComments(projectDir, filePath string) (int, error) {
 	query := `
 		UPDATE comments
 		SET resolved_at = CURRENT_TIMESTAMP, resolved_by = 'user'
 		WHERE project_directory = ? AND file_path = ? AND resolved_at IS NULL`
-	logQuery(query, projectDir, filePath)
-	result, err := db.Exec(query, projectDir, filePath)
+	s.logQuery(query, projectDir, filePath)
+	result, err := s.db.Exec(query, projectDir, filePath)
 	if err != nil {
 		return 0, err
 	}
@@ -273,15 +408,15 @@ func resolveComments(projectDir, filePath string) (int, error) {
 	return int(count), nil
 }
 
-func getCommentByID(commentID int) (*Comment, error) {
+func (s *SQLiteStore) GetCommentByID(commentID int) (*Comment, error) {
 	query := `
 		SELECT id, project_directory, file_path, line_start, line_end, selected_text, comment_text, created_at, resolved_at, root_id, author, resolved_by
 		FROM comments
 		WHERE id = ?`
-	logQuery(query, commentID)
+	s.logQuery(query, commentID)
 
 	var c Comment
-	err := db.QueryRow(query, commentID).Scan(
+	err := s.db.QueryRow(query, commentID).Scan(
 		&c.ID, &c.ProjectDirectory, &c.FilePath, &c.LineStart, &c.LineEnd,
 		&c.SelectedText, &c.CommentText, &c.CreatedAt,
 		&c.ResolvedAt, &c.RootID, &c.Author, &c.ResolvedBy,
@@ -296,13 +431,13 @@ func getCommentByID(commentID int) (*Comment, error) {
 	return &c, nil
 }
 
-func resolveThread(rootCommentID int, resolvedBy string) (int, error) {
+func (s *SQLiteStore) ResolveThread(rootCommentID int, resolvedBy string) (int, error) {
 	query := `
 		UPDATE comments
 		SET resolved_at = CURRENT_TIMESTAMP, resolved_by = ?
 		WHERE (id = ? OR root_id = ?) AND resolved_at IS NULL`
-	logQuery(query, resolvedBy, rootCommentID, rootCommentID)
-	result, err := db.Exec(query, resolvedBy, rootCommentID, rootCommentID)
+	s.logQuery(query, resolvedBy, rootCommentID, rootCommentID)
+	result, err := s.db.Exec(query, resolvedBy, rootCommentID, rootCommentID)
 	if err != nil {
 		return 0, err
 	}
@@ -315,13 +450,29 @@ func resolveThread(rootCommentID int, resolvedBy string) (int, error) {
 	return int(count), nil
 }
 
-func hasReplies(commentID int) (bool, error) {
+func (s *SQLiteStore) GetThread(rootCommentID int) ([]Comment, error) {
+	query := `
+		SELECT id, project_directory, file_path, line_start, line_end, selected_text, comment_text, created_at, resolved_at, root_id, author, resolved_by
+		FROM comments
+		WHERE id = ? OR root_id = ?
+		ORDER BY CASE WHEN id = ? THEN 0 ELSE 1 END, created_at ASC, id ASC`
+	s.logQuery(query, rootCommentID, rootCommentID, rootCommentID)
+	rows, err := s.db.Query(query, rootCommentID, rootCommentID, rootCommentID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	return scanComments(rows)
+}
+
+func (s *SQLiteStore) HasReplies(commentID int) (bool, error) {
 	query := `
 		SELECT COUNT(*) FROM comments WHERE root_id = ?`
-	logQuery(query, commentID)
+	s.logQuery(query, commentID)
 
 	var count int
-	err := db.QueryRow(query, commentID).Scan(&count)
+	err := s.db.QueryRow(query, commentID).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -329,16 +480,16 @@ func hasReplies(commentID int) (bool, error) {
 	return count > 0, nil
 }
 
-// renderCommentsAsHTML renders the comment_text field of each comment as HTML
-// and stores it in the RenderedHTML field for web UI display
-func renderCommentsAsHTML(comments []Comment) error {
-	for i := range comments {
-		rendered, err := RenderMarkdown([]byte(comments[i].CommentText))
-		if err != nil {
-			return fmt.Errorf("failed to render comment markdown: %w", err)
+// scanComments reads all rows of a comment SELECT (in the standard column order)
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.ProjectDirectory, &c.FilePath, &c.LineStart, &c.LineEnd, &c.SelectedText, &c.CommentText, &c.CreatedAt, &c.ResolvedAt, &c.RootID, &c.Author, &c.ResolvedBy); err != nil {
+			return nil, err
 		}
-		// Trim whitespace to avoid issues in inline JavaScript
-		comments[i].RenderedHTML = strings.TrimSpace(string(rendered))
+		comments = append(comments, c)
 	}
-	return nil
+
+	return comments, rows.Err()
 }
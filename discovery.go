@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// defaultPort is the preferred port unless the config sets one
+const defaultPort = "4779"
+
+// portFallbackAttempts is how many ports after the preferred one are tried
+// before letting the OS pick any free port
+const portFallbackAttempts = 10
+
+// DaemonState is written to server.json in the data directory by the running
+// server, so CLI commands can find it wherever it ended up listening
+type DaemonState struct {
+	PID       int       `json:"pid"`
+	Port      int       `json:"port"`
+	URL       string    `json:"url"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// getStateFilePath returns the path to the daemon state file
+func getStateFilePath() (string, error) {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "server.json"), nil
+}
+
+// preferredPort returns the port the server tries first: CR_LISTEN_PORT, the
+// global config's port or 4779
+func preferredPort() string {
+	return strconv.Itoa(configFor("").Port)
+}
+
+// listenLoopback listens on the preferred port, or on a nearby free port if
+// it is taken. Nearby ports keep URLs predictable; the OS picks one as a last
+// resort.
+func listenLoopback() (net.Listener, error) {
+	port := preferredPort()
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+		return listener, err
+	}
+
+	first, convErr := strconv.Atoi(port)
+	if convErr == nil {
+		for p := first + 1; p < first+portFallbackAttempts && p <= 65535; p++ {
+			if listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(p))); err == nil {
+				slog.Warn("Preferred port is in use", "preferred_port", port, "port", p)
+				return listener, nil
+			}
+		}
+	}
+
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	slog.Warn("Preferred port is in use", "preferred_port", port, "port", listener.Addr().(*net.TCPAddr).Port)
+	return listener, nil
+}
+
+// writeStateFile records the address this process listens on
+func writeStateFile(port int) error {
+	stateFile, err := getStateFilePath()
+	if err != nil {
+		return err
+	}
+
+	state := DaemonState{
+		PID:       os.Getpid(),
+		Port:      port,
+		URL:       fmt.Sprintf("http://localhost:%d", port),
+		StartedAt: time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Written atomically, so readers never see half a file
+	tmp := stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stateFile)
+}
+
+// removeStateFile removes the state file if it belongs to this process
+func removeStateFile() error {
+	state, err := readStateFile()
+	if err != nil || state == nil || state.PID != os.Getpid() {
+		return err
+	}
+
+	stateFile, err := getStateFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readStateFile returns the recorded daemon state, or nil if there is none
+func readStateFile() (*DaemonState, error) {
+	stateFile, err := getStateFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state DaemonState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", stateFile, err)
+	}
+	return &state, nil
+}
+
+// runningDaemonState returns the state of the running server, or nil if the
+// state file is missing or was left behind by a server that is gone
+func runningDaemonState() *DaemonState {
+	if !instanceLocked() {
+		return nil
+	}
+	state, err := readStateFile()
+	if err != nil {
+		log.Printf("Failed to read daemon state: %v", err)
+		return nil
+	}
+	return state
+}
+
+// daemonURL returns the base URL of the running server, if one has recorded
+// it. There is no guessing at the preferred port: whatever else listens there
+// must not receive requests meant for the server, let alone its API token.
+func daemonURL() (string, bool) {
+	if state := runningDaemonState(); state != nil {
+		return state.URL, true
+	}
+	return "", false
+}
+
+// waitForDaemonState waits until the server with the given PID has recorded
+// its address, and gives up early if the process exits
+func waitForDaemonState(pid int, exited <-chan error, timeout time.Duration) (*DaemonState, error) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if state, _ := readStateFile(); state != nil && state.PID == pid {
+			return state, nil
+		}
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("server exited during startup: %v", err)
+		case <-deadline:
+			return nil, fmt.Errorf("server did not start listening within %s", timeout)
+		case <-ticker.C:
+		}
+	}
+}
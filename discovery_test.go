@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenLoopback_FallsBackWhenPortTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = taken.Close() }()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+	t.Setenv("CR_LISTEN_PORT", strconv.Itoa(takenPort))
+
+	listener, err := listenLoopback()
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	assert.NotEqual(t, takenPort, port)
+	assert.Equal(t, "127.0.0.1", listener.Addr().(*net.TCPAddr).IP.String())
+}
+
+func TestListenLoopback_PreferredPort(t *testing.T) {
+	// Find a free port, then ask for it
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	free := probe.Addr().(*net.TCPAddr).Port
+	require.NoError(t, probe.Close())
+	t.Setenv("CR_LISTEN_PORT", strconv.Itoa(free))
+
+	listener, err := listenLoopback()
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	assert.Equal(t, free, listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestStateFile(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	t.Setenv("CR_LISTEN_PORT", "4801")
+
+	// Nothing recorded: no guessing at the preferred port
+	_, ok := daemonURL()
+	assert.False(t, ok)
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	defer func() { _ = lock.Close() }()
+
+	require.NoError(t, writeStateFile(4805))
+	state, err := readStateFile()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, os.Getpid(), state.PID)
+	assert.Equal(t, 4805, state.Port)
+	url, ok := daemonURL()
+	assert.True(t, ok)
+	assert.Equal(t, "http://localhost:4805", url)
+
+	require.NoError(t, removeStateFile())
+	state, err = readStateFile()
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
+
+func TestStateFile_StaleOrForeign(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	t.Setenv("CR_LISTEN_PORT", "4801")
+	stateFile, err := getStateFilePath()
+	require.NoError(t, err)
+
+	// Left behind by a server that is gone, whatever process has its PID now
+	require.NoError(t, os.WriteFile(stateFile, []byte(fmt.Sprintf(`{"pid": %d, "port": 4805, "url": "http://localhost:4805"}`, os.Getpid()+1)), 0644))
+	assert.Nil(t, runningDaemonState())
+	_, ok := daemonURL()
+	assert.False(t, ok)
+
+	// Another process's file is not ours to remove
+	require.NoError(t, removeStateFile())
+	_, err = os.Stat(stateFile)
+	assert.NoError(t, err)
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	defer func() { _ = lock.Close() }()
+	require.NoError(t, os.WriteFile(stateFile, []byte("not json"), 0644))
+	_, err = readStateFile()
+	assert.Error(t, err)
+	_, ok = daemonURL()
+	assert.False(t, ok)
+}
+
+func TestWaitForDaemonState(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+
+	t.Run("server exits during startup", func(t *testing.T) {
+		exited := make(chan error, 1)
+		exited <- errors.New("exit status 1")
+		_, err := waitForDaemonState(os.Getpid(), exited, time.Second)
+		assert.ErrorContains(t, err, "exited during startup")
+	})
+
+	t.Run("state recorded", func(t *testing.T) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = writeStateFile(4806)
+		}()
+		state, err := waitForDaemonState(os.Getpid(), make(chan error), 2*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, 4806, state.Port)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, err := waitForDaemonState(os.Getpid()+1, make(chan error), 100*time.Millisecond)
+		assert.ErrorContains(t, err, "did not start listening")
+	})
+}
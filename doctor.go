@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkStatus is the outcome of one of the doctor's checks
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkSkipped
+	checkWarn
+	checkFailed
+)
+
+func (s checkStatus) String() string {
+	switch s {
+	case checkOK:
+		return "ok"
+	case checkSkipped:
+		return "skip"
+	case checkWarn:
+		return "warn"
+	default:
+		return "FAIL"
+	}
+}
+
+// checkResult is what a check found and, if something is wrong, what to do
+// about it
+type checkResult struct {
+	status checkStatus
+	detail string
+	fix    string
+}
+
+// doctorCheck is one of the checks `claude-review doctor` runs
+type doctorCheck struct {
+	name string
+	run  func() checkResult
+}
+
+// doctorChecks are run in this order. A failure does not stop the checks
+// after it, since problems often come in pairs and are fixed together.
+var doctorChecks = []doctorCheck{
+	{"PATH", checkPath},
+	{"Configuration", checkConfig},
+	{"Data directory", checkDataDir},
+	{"Templates", checkTemplates},
+	{"Database", checkDatabase},
+	{"PID file", checkPIDFile},
+	{"Server", checkServer},
+	{"Port", checkPort},
+	{"inotify limits", checkInotifyLimits},
+	{"Slash commands", checkSlashCommands},
+}
+
+// runDoctorChecks runs the checks and prints each result, with its fix if
+// the check did not pass
+func runDoctorChecks(w io.Writer, checks []doctorCheck) (warnings, failures int) {
+	for _, check := range checks {
+		result := check.run()
+		_, _ = fmt.Fprintf(w, "%-6s %s: %s\n", "["+result.status.String()+"]", check.name, result.detail)
+		if result.status >= checkWarn && result.fix != "" {
+			_, _ = fmt.Fprintf(w, "       Fix: %s\n", result.fix)
+		}
+
+		switch result.status {
+		case checkWarn:
+			warnings++
+		case checkFailed:
+			failures++
+		}
+	}
+	return warnings, failures
+}
+
+// checkPath makes sure the slash commands, which run claude-review by name,
+// find this binary
+func checkPath() checkResult {
+	self, err := os.Executable()
+	if err == nil {
+		self, err = filepath.EvalSymlinks(self)
+	}
+	if err != nil {
+		return checkResult{status: checkWarn, detail: fmt.Sprintf("cannot locate this binary: %v", err)}
+	}
+
+	found, err := exec.LookPath(serviceName)
+	if err != nil {
+		return checkResult{
+			status: checkFailed,
+			detail: "claude-review is not on PATH, so the slash commands cannot run it",
+			fix:    fmt.Sprintf("Add %s to PATH, or move the binary to a directory on it such as ~/.local/bin", filepath.Dir(self)),
+		}
+	}
+	resolved, err := filepath.EvalSymlinks(found)
+	if err == nil && resolved != self {
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("PATH finds %s, not this binary (%s)", found, self),
+			fix:    fmt.Sprintf("Remove the other copy, or put %s earlier on PATH", filepath.Dir(self)),
+		}
+	}
+	return checkResult{status: checkOK, detail: found}
+}
+
+// checkConfig validates the global config and that of the current directory
+func checkConfig() checkResult {
+	projectDir, err := os.Getwd()
+	if err != nil {
+		projectDir = ""
+	}
+	if _, err := loadConfig(projectDir); err != nil {
+		return checkResult{
+			status: checkFailed,
+			detail: err.Error(),
+			fix:    "Correct or remove the setting above; `claude-review config list` shows where each one comes from",
+		}
+	}
+	return checkResult{status: checkOK, detail: "valid"}
+}
+
+// checkDataDir makes sure the server can write its database, log and state
+func checkDataDir() checkResult {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return checkResult{
+			status: checkFailed,
+			detail: err.Error(),
+			fix:    "Set CR_DATA_DIR to a directory you can write to",
+		}
+	}
+
+	probe, err := os.CreateTemp(dataDir, ".doctor-*")
+	if err != nil {
+		return checkResult{
+			status: checkFailed,
+			detail: fmt.Sprintf("%s is not writable: %v", dataDir, err),
+			fix:    fmt.Sprintf("Make it writable with `chmod u+rwx %s`, or set CR_DATA_DIR to another directory", dataDir),
+		}
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return checkResult{status: checkOK, detail: dataDir}
+}
+
+// checkTemplates parses the embedded templates the way the server does
+func checkTemplates() checkResult {
+	fix := "The binary is damaged or was built from a broken tree; reinstall claude-review"
+	if err := initTemplates(); err != nil {
+		return checkResult{status: checkFailed, detail: err.Error(), fix: fix}
+	}
+	for _, name := range []string{"index.html", "directory.html", "viewer.html"} {
+		if templates.Lookup(name) == nil {
+			return checkResult{status: checkFailed, detail: name + " is missing", fix: fix}
+		}
+	}
+	return checkResult{status: checkOK, detail: "all templates parse"}
+}
+
+// checkDatabase runs SQLite's integrity check on the comments database
+func checkDatabase() checkResult {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return checkResult{status: checkSkipped, detail: "no data directory"}
+	}
+	dbPath := filepath.Join(dataDir, "comments.db")
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return checkResult{status: checkOK, detail: "not created yet; the server creates it on first use"}
+	}
+
+	if err := checkDatabaseIntegrity(dbPath); err != nil {
+		return checkResult{
+			status: checkFailed,
+			detail: fmt.Sprintf("%s: %v", dbPath, err),
+			fix: fmt.Sprintf("Stop the server with `claude-review server --stop`, then move the database aside and recover what it holds: "+
+				"`mv %[1]s %[1]s.broken && sqlite3 %[1]s.broken .recover | sqlite3 %[1]s`", dbPath),
+		}
+	}
+	return checkResult{status: checkOK, detail: dbPath + " passed the integrity check"}
+}
+
+// checkPIDFile compares the PID file with the server that holds the instance
+// lock. The lock is what counts; a PID file that disagrees misleads scripts.
+func checkPIDFile() checkResult {
+	pidFile, err := getPIDFilePath()
+	if err != nil {
+		return checkResult{status: checkSkipped, detail: "no data directory"}
+	}
+	data, err := os.ReadFile(pidFile)
+	exists := err == nil
+
+	if !instanceLocked() {
+		if exists {
+			return checkResult{
+				status: checkWarn,
+				detail: fmt.Sprintf("%s was left behind by a server that is gone", pidFile),
+				fix:    "Run `claude-review server --status`, which removes it",
+			}
+		}
+		return checkResult{status: checkOK, detail: "none, as no server is running"}
+	}
+
+	if !exists {
+		return checkResult{status: checkOK, detail: "none; the server was not started with --daemon"}
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("%s does not hold a PID", pidFile),
+			fix:    "Restart the server with `claude-review server --restart`",
+		}
+	}
+	if state, err := readStateFile(); err == nil && state != nil && state.PID != pid {
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("%s names PID %d, but the server is PID %d", pidFile, pid, state.PID),
+			fix:    "Restart the server with `claude-review server --restart`",
+		}
+	}
+	return checkResult{status: checkOK, detail: fmt.Sprintf("PID %d", pid)}
+}
+
+// checkServer makes sure a running server answers and runs this build
+func checkServer() checkResult {
+	if !instanceLocked() {
+		return checkResult{status: checkOK, detail: "not running; /cr-review starts it when needed"}
+	}
+
+	state, err := waitForRunningServer(3 * time.Second)
+	if err != nil {
+		return checkResult{
+			status: checkFailed,
+			detail: err.Error(),
+			fix:    "Restart it with `claude-review server --restart`, and look for the cause in `claude-review logs --level warn`",
+		}
+	}
+	info, err := fetchDaemonVersion(state.URL)
+	if err != nil {
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("PID %d answers at %s, but not with its version: %v", state.PID, state.URL, err),
+			fix:    "Restart it with `claude-review server --restart`",
+		}
+	}
+	if info.Version != Version {
+		fix := "Restart it with `claude-review server --restart`"
+		if !info.Daemon {
+			fix = "Restart it in the terminal it runs in"
+		}
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("PID %d runs version %s, but this binary is %s", state.PID, info.Version, Version),
+			fix:    fix,
+		}
+	}
+	return checkResult{status: checkOK, detail: fmt.Sprintf("PID %d answers at %s, version %s", state.PID, state.URL, info.Version)}
+}
+
+// checkPort reports whether the server gets its preferred port
+func checkPort() checkResult {
+	port := preferredPort()
+	fix := fmt.Sprintf("Free port %s (`lsof -i :%s` shows who has it), or choose another with `claude-review config set port <port>`", port, port)
+
+	if state := runningDaemonState(); state != nil {
+		if strconv.Itoa(state.Port) == port {
+			return checkResult{status: checkOK, detail: port + " is used by the server"}
+		}
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("the server listens on %d because %s was taken", state.Port, port),
+			fix:    fix,
+		}
+	}
+	if address, ok := installedSocketAddress(); ok {
+		return checkResult{status: checkOK, detail: "systemd listens on " + address + " for the server"}
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		return checkResult{
+			status: checkWarn,
+			detail: fmt.Sprintf("%s is in use by another program; the server will pick a nearby port", port),
+			fix:    fix,
+		}
+	}
+	_ = listener.Close()
+	return checkResult{status: checkOK, detail: port + " is free"}
+}
+
+// inotifyLimitsDir holds the kernel's inotify limits; replaced in tests
+var inotifyLimitsDir = "/proc/sys/fs/inotify"
+
+// Limits below these run out once a few large projects are watched, at
+// which point the server falls back to polling
+const (
+	minInotifyWatches   = 16384
+	minInotifyInstances = 128
+)
+
+// checkInotifyLimits warns about inotify limits too low to watch projects,
+// and about a server that has already fallen back to polling
+func checkInotifyLimits() checkResult {
+	raise := fmt.Sprintf("Raise them with `printf 'fs.inotify.max_user_watches=524288\\nfs.inotify.max_user_instances=%d\\n' | "+
+		"sudo tee /etc/sysctl.d/90-claude-review.conf && sudo sysctl --system`", 4*minInotifyInstances)
+
+	if state := runningDaemonState(); state != nil {
+		if status, err := fetchDaemonStatus(state.URL); err == nil && status.Watcher != nil && status.Watcher.FallbackReason != "" {
+			return checkResult{
+				status: checkWarn,
+				detail: "the server fell back to polling: " + status.Watcher.FallbackReason,
+				fix:    raise + ", then restart the server with `claude-review server --restart`",
+			}
+		}
+	}
+
+	var details, low []string
+	for _, limit := range []struct {
+		name string
+		min  int
+	}{
+		{"max_user_watches", minInotifyWatches},
+		{"max_user_instances", minInotifyInstances},
+	} {
+		data, err := os.ReadFile(filepath.Join(inotifyLimitsDir, limit.name))
+		if errors.Is(err, os.ErrNotExist) {
+			return checkResult{status: checkSkipped, detail: "this system has no inotify"}
+		}
+		if err != nil {
+			return checkResult{status: checkWarn, detail: fmt.Sprintf("cannot read %s: %v", limit.name, err)}
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return checkResult{status: checkWarn, detail: fmt.Sprintf("cannot parse %s: %q", limit.name, bytes.TrimSpace(data))}
+		}
+
+		details = append(details, fmt.Sprintf("%s=%d", limit.name, value))
+		if value < limit.min {
+			low = append(low, fmt.Sprintf("%s is %d, below %d", limit.name, value, limit.min))
+		}
+	}
+	if len(low) > 0 {
+		return checkResult{status: checkWarn, detail: strings.Join(low, "; "), fix: raise}
+	}
+	return checkResult{status: checkOK, detail: strings.Join(details, ", ")}
+}
+
+// checkSlashCommands compares the installed slash commands with the ones
+// built into this binary: the global ones and, where Claude Code prefers
+// them, those of the current project
+func checkSlashCommands() checkResult {
+	globalDir, err := getCommandsDir()
+	if err != nil {
+		return checkResult{status: checkFailed, detail: err.Error()}
+	}
+	dirs := []string{globalDir}
+	if cwd, err := os.Getwd(); err == nil {
+		if projectDir := getProjectCommandsDir(cwd); projectDir != globalDir {
+			dirs = append(dirs, projectDir)
+		}
+	}
+
+	var names, missing, problems, fixes []string
+	installed := map[string]bool{}
+	for _, dir := range dirs {
+		commands, err := inspectSlashCommands(dir)
+		if err != nil {
+			return checkResult{status: checkFailed, detail: err.Error()}
+		}
+
+		install := "claude-review install"
+		if dir != globalDir {
+			install += " --project"
+		}
+		for _, command := range commands {
+			if dir == globalDir {
+				names = append(names, command.name())
+			}
+			switch command.state {
+			case commandMissing:
+				continue
+			case commandOutdated:
+				problems = append(problems, command.path+" is "+command.describe())
+				fixes = appendUnique(fixes, "Run `"+install+"`")
+			case commandModified:
+				problems = append(problems, command.path+" "+command.describe())
+				fixes = appendUnique(fixes, "Run `"+install+" --force` if the edits can go")
+			}
+			installed[command.name()] = true
+		}
+	}
+	for _, name := range names {
+		if !installed[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	switch {
+	case len(missing) > 0:
+		return checkResult{
+			status: checkFailed,
+			detail: fmt.Sprintf("%s not installed in %s", strings.Join(missing, ", "), globalDir),
+			fix:    "Run `claude-review install`",
+		}
+	case len(problems) > 0:
+		// Outdated commands call the CLI in ways it may no longer support
+		return checkResult{status: checkFailed, detail: strings.Join(problems, "; "), fix: strings.Join(fixes, "; ")}
+	}
+	return checkResult{status: checkOK, detail: fmt.Sprintf("%s match this binary", strings.Join(names, ", "))}
+}
+
+// appendUnique appends s to list unless it is already there
+func appendUnique(list []string, s string) []string {
+	if slices.Contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDoctorChecks(t *testing.T) {
+	checks := []doctorCheck{
+		{"Good", func() checkResult { return checkResult{status: checkOK, detail: "fine", fix: "not shown"} }},
+		{"Absent", func() checkResult { return checkResult{status: checkSkipped, detail: "not here"} }},
+		{"Iffy", func() checkResult { return checkResult{status: checkWarn, detail: "odd", fix: "Look closer"} }},
+		{"Bad", func() checkResult { return checkResult{status: checkFailed, detail: "broken", fix: "Repair it"} }},
+	}
+
+	var out bytes.Buffer
+	warnings, failures := runDoctorChecks(&out, checks)
+	assert.Equal(t, 1, warnings)
+	assert.Equal(t, 1, failures)
+	assert.Equal(t, "[ok]   Good: fine\n"+
+		"[skip] Absent: not here\n"+
+		"[warn] Iffy: odd\n"+
+		"       Fix: Look closer\n"+
+		"[FAIL] Bad: broken\n"+
+		"       Fix: Repair it\n", out.String())
+}
+
+func TestCheckDatabase(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("CR_DATA_DIR", dataDir)
+	dbPath := filepath.Join(dataDir, "comments.db")
+
+	result := checkDatabase()
+	assert.Equal(t, checkOK, result.status)
+	assert.NoFileExists(t, dbPath, "the check does not create the database")
+
+	store, err := openSQLiteStore(dbPath)
+	require.NoError(t, err)
+	_, err = store.CreateProject("/tmp/project")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	result = checkDatabase()
+	assert.Equal(t, checkOK, result.status, result.detail)
+
+	require.NoError(t, os.WriteFile(dbPath, bytes.Repeat([]byte("not a database "), 512), 0644))
+	result = checkDatabase()
+	assert.Equal(t, checkFailed, result.status)
+	assert.Contains(t, result.detail, dbPath)
+	assert.Contains(t, result.fix, "sqlite3 "+dbPath+".broken .recover")
+}
+
+func TestCheckPIDFile(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	assert.Equal(t, checkOK, checkPIDFile().status)
+
+	require.NoError(t, writePIDFile())
+	result := checkPIDFile()
+	assert.Equal(t, checkWarn, result.status)
+	assert.Contains(t, result.detail, "left behind by a server that is gone")
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	defer func() { _ = lock.Close() }()
+	result = checkPIDFile()
+	assert.Equal(t, checkOK, result.status)
+	assert.Equal(t, "PID "+strconv.Itoa(os.Getpid()), result.detail)
+
+	pidFile, err := getPIDFilePath()
+	require.NoError(t, err)
+	require.NoError(t, writeStateFile(4779))
+	require.NoError(t, os.WriteFile(pidFile, []byte("1"), 0644))
+	result = checkPIDFile()
+	assert.Equal(t, checkWarn, result.status)
+	assert.Contains(t, result.detail, "names PID 1, but the server is PID "+strconv.Itoa(os.Getpid()))
+}
+
+func TestCheckInotifyLimits(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	previous := inotifyLimitsDir
+	t.Cleanup(func() { inotifyLimitsDir = previous })
+	inotifyLimitsDir = t.TempDir()
+
+	writeLimits := func(watches, instances int) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(inotifyLimitsDir, "max_user_watches"), []byte(strconv.Itoa(watches)+"\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(inotifyLimitsDir, "max_user_instances"), []byte(strconv.Itoa(instances)+"\n"), 0644))
+	}
+
+	writeLimits(524288, 128)
+	result := checkInotifyLimits()
+	assert.Equal(t, checkOK, result.status)
+	assert.Equal(t, "max_user_watches=524288, max_user_instances=128", result.detail)
+
+	writeLimits(8192, 128)
+	result = checkInotifyLimits()
+	assert.Equal(t, checkWarn, result.status)
+	assert.Equal(t, "max_user_watches is 8192, below 16384", result.detail)
+	assert.Contains(t, result.fix, "fs.inotify.max_user_watches=524288")
+
+	inotifyLimitsDir = filepath.Join(t.TempDir(), "missing")
+	assert.Equal(t, checkSkipped, checkInotifyLimits().status)
+}
+
+func TestCheckSlashCommands(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	projectDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(projectDir, ".git"), 0755))
+	t.Chdir(projectDir)
+
+	result := checkSlashCommands()
+	assert.Equal(t, checkFailed, result.status)
+	assert.Contains(t, result.detail, "/cr-address, /cr-review not installed")
+	assert.Equal(t, "Run `claude-review install`", result.fix)
+
+	commandsDir, err := getCommandsDir()
+	require.NoError(t, err)
+	require.NoError(t, installSlashCommands(commandsDir, false))
+	result = checkSlashCommands()
+	assert.Equal(t, checkOK, result.status, result.detail)
+
+	reviewPath := filepath.Join(commandsDir, "cr-review.md")
+	require.NoError(t, os.WriteFile(reviewPath, []byte("my own version\n"), 0644))
+	result = checkSlashCommands()
+	assert.Equal(t, checkFailed, result.status)
+	assert.Equal(t, reviewPath+" has no version stamp, so it may hold your edits", result.detail)
+	assert.Equal(t, "Run `claude-review install --force` if the edits can go", result.fix)
+
+	// Commands in the project count, and are checked as well
+	require.NoError(t, os.Remove(reviewPath))
+	projectCommandsDir := getProjectCommandsDir(projectDir)
+	require.NoError(t, os.MkdirAll(projectCommandsDir, 0755))
+	projectReviewPath := filepath.Join(projectCommandsDir, "cr-review.md")
+	require.NoError(t, os.WriteFile(projectReviewPath, stampSlashCommand([]byte("---\nold: true\n---\n"), "v0.1.0"), 0644))
+	result = checkSlashCommands()
+	assert.Equal(t, checkFailed, result.status)
+	assert.Equal(t, projectReviewPath+" is outdated (installed by v0.1.0)", result.detail)
+	assert.Equal(t, "Run `claude-review install --project`", result.fix)
+}
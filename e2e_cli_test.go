@@ -1,7 +1,11 @@
 package main_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -60,6 +64,38 @@ func TestE2E_CLI_Register(t *testing.T) {
 	})
 }
 
+// TestE2E_CLI_DaemonRPC tests that CLI changes go through the daemon's Unix
+// socket while it runs, and straight to the database when it does not
+func TestE2E_CLI_DaemonRPC(t *testing.T) {
+	env := setupE2E(t)
+
+	socketPath := filepath.Join(env.DataDir, "server.sock")
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0600, info.Mode(), "only the owner may connect")
+
+	output, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err, output)
+	serverLog, err := os.ReadFile(filepath.Join(env.TempDir, "server.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(serverLog), "/rpc/register")
+
+	// Killed, so the socket is left behind with nobody listening
+	require.NoError(t, env.ServerCmd.Process.Kill())
+	_ = env.ServerCmd.Wait()
+	_, err = os.Stat(socketPath)
+	require.NoError(t, err)
+
+	otherProject := t.TempDir()
+	output, err = env.runCLI(t, "register", "--project", otherProject)
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Registered project")
+
+	output, err = env.runCLI(t, "resolve", "--project", otherProject, "--file", "test.md")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "No unresolved comments found")
+}
+
 // TestE2E_CLI_Address tests the address command edge cases
 // Note: Full workflow testing is covered in TestE2E_CommentWorkflow
 func TestE2E_CLI_Address(t *testing.T) {
@@ -94,6 +130,17 @@ func TestE2E_CLI_Address(t *testing.T) {
 		assert.Contains(t, output, "No unresolved comments")
 	})
 
+	t.Run("address logs its SQL at debug", func(t *testing.T) {
+		env := setupE2E(t)
+		_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+		require.NoError(t, err)
+
+		t.Setenv("CR_LOG_LEVEL", "debug")
+		output, err := env.runCLI(t, "address", "--file", "test.md", "--project", env.ProjectDir)
+		require.NoError(t, err)
+		assert.Regexp(t, `level=DEBUG msg="SQL query" query="SELECT`, output)
+	})
+
 	t.Run("address without project flag uses current directory", func(t *testing.T) {
 		env := setupE2E(t)
 		oldDir, _ := os.Getwd()
@@ -221,6 +268,77 @@ func TestE2E_CLI_Version(t *testing.T) {
 }
 
 // TestE2E_CLI_UnknownCommand tests error handling for unknown commands
+func TestE2E_CLI_Config(t *testing.T) {
+	env := setupE2E(t)
+
+	output, err := env.runCLI(t, "config", "set", "labels.title", "Team Review")
+	require.NoError(t, err, output)
+	globalPath := filepath.Join(env.ConfigDir, "claude-review", "config.toml")
+	assert.Contains(t, output, "Set labels.title in "+globalPath)
+
+	output, err = env.runCLI(t, "config", "set", "--project", env.ProjectDir, "extensions", ".md,.markdown")
+	require.NoError(t, err, output)
+	require.FileExists(t, filepath.Join(env.ProjectDir, ".claude-review.toml"))
+
+	output, err = env.runCLI(t, "config", "get", "--project", env.ProjectDir, "extensions")
+	require.NoError(t, err, output)
+	assert.Equal(t, ".md,.markdown\n", output)
+
+	output, err = env.runCLI(t, "config", "list", "--project", env.ProjectDir)
+	require.NoError(t, err, output)
+	assert.Regexp(t, `port += `+env.Port+`  \(\$CR_LISTEN_PORT\)`, output)
+	assert.Contains(t, output, "Team Review  ("+globalPath+")")
+
+	output, err = env.runCLI(t, "config", "set", "--project", env.ProjectDir, "port", "4800")
+	assert.Error(t, err)
+	assert.Contains(t, output, "port can only be set in the global config")
+
+	// The running server picks up changes without a restart
+	_, err = env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(env.ProjectDir, "plan.markdown"), []byte("# Plan\n"), 0644))
+	resp, err := http.Get(env.BaseURL + "/projects" + env.ProjectDir + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "plan.markdown")
+	assert.Contains(t, string(body), "- Team Review</title>")
+}
+
+func TestE2E_CLI_Doctor(t *testing.T) {
+	env := setupE2E(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PATH", filepath.Dir(env.BinaryPath)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	output, err := env.runCLI(t, "doctor")
+	assert.Error(t, err, "the slash commands are missing")
+	assert.Contains(t, output, "[ok]   PATH: "+env.BinaryPath+"\n")
+	assert.Contains(t, output, "[ok]   Server: PID ")
+	assert.Contains(t, output, "[ok]   Port: "+env.Port+" is used by the server\n")
+	assert.Contains(t, output, "[FAIL] Slash commands: /cr-address, /cr-review not installed")
+	assert.Contains(t, output, "Fix: Run `claude-review install`")
+	assert.Contains(t, output, "1 check(s) failed")
+
+	_, err = env.runCLI(t, "install")
+	require.NoError(t, err)
+	output, err = env.runCLI(t, "doctor")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "[ok]   Slash commands: /cr-address, /cr-review match this binary\n")
+	assert.NotContains(t, output, "[FAIL]")
+
+	// A command an older version installed fails the check as well
+	reviewPath := filepath.Join(os.Getenv("HOME"), ".claude", "commands", "cr-review.md")
+	old := "---\nold: true\n---\n"
+	checksum := sha256.Sum256([]byte(old))
+	stamp := "---\nclaude-review-version: v0.1.0\nclaude-review-checksum: sha256:" + hex.EncodeToString(checksum[:]) + "\n"
+	require.NoError(t, os.WriteFile(reviewPath, []byte(stamp+strings.TrimPrefix(old, "---\n")), 0644))
+	output, err = env.runCLI(t, "doctor")
+	assert.Error(t, err, "the slash commands are outdated")
+	assert.Contains(t, output, "[FAIL] Slash commands: "+reviewPath+" is outdated (installed by v0.1.0)\n")
+	assert.Contains(t, output, "1 check(s) failed")
+}
+
 func TestE2E_CLI_UnknownCommand(t *testing.T) {
 	env := setupE2E(t)
 
@@ -311,30 +429,50 @@ func TestE2E_CLI_Install(t *testing.T) {
 		assert.Contains(t, outputStr, "/cr-address")
 	})
 
-	t.Run("install is idempotent and overwrites existing files", func(t *testing.T) {
+	t.Run("install is idempotent and keeps edited files unless forced", func(t *testing.T) {
 		commandsDir := filepath.Join(homeDir, ".claude", "commands")
+		runInstall := func(args ...string) (string, error) {
+			cmd := exec.Command(binaryPath, append([]string{"install"}, args...)...)
+			cmd.Env = append(os.Environ(),
+				"HOME="+homeDir,
+				"GOCOVERDIR=tmp/coverage",
+			)
+			output, err := cmd.CombinedOutput()
+			return string(output), err
+		}
 
 		// Get initial file count
 		initialEntries, err := os.ReadDir(commandsDir)
 		require.NoError(t, err)
 		initialCount := len(initialEntries)
 
+		// Installing again over untouched files just works
+		output, err := runInstall()
+		require.NoError(t, err, output)
+		assert.Contains(t, output, "Successfully installed")
+
 		// Modify one of the files
 		testFile := filepath.Join(commandsDir, "cr-review.md")
 		require.NoError(t, os.WriteFile(testFile, []byte("MODIFIED CONTENT"), 0644))
 
-		// Run install again
-		cmd := exec.Command(binaryPath, "install")
-		cmd.Env = append(os.Environ(),
-			"HOME="+homeDir,
-			"GOCOVERDIR=tmp/coverage",
-		)
+		output, err = runInstall("--check")
+		assert.Error(t, err)
+		assert.Contains(t, output, "/cr-review   has no version stamp, so it may hold your edits")
+		assert.Contains(t, output, "Run `claude-review install --force` to update them")
 
-		output, err := cmd.CombinedOutput()
+		output, err = runInstall()
+		assert.Error(t, err)
+		assert.Contains(t, output, "use --force to replace them")
+		content, err := os.ReadFile(testFile)
 		require.NoError(t, err)
+		assert.Equal(t, "MODIFIED CONTENT", string(content))
+
+		output, err = runInstall("--force")
+		require.NoError(t, err, output)
+		assert.Contains(t, output, "/cr-review (replaced your changes)")
 
 		// Verify file was overwritten
-		content, err := os.ReadFile(testFile)
+		content, err = os.ReadFile(testFile)
 		require.NoError(t, err)
 		assert.NotContains(t, string(content), "MODIFIED CONTENT")
 
@@ -343,9 +481,42 @@ func TestE2E_CLI_Install(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, initialCount, len(finalEntries), "Should not create duplicate files")
 
-		// Verify output still shows success
-		outputStr := string(output)
-		assert.Contains(t, outputStr, "Successfully installed")
+		output, err = runInstall("--check")
+		require.NoError(t, err, output)
+		assert.Contains(t, output, "/cr-review   up to date")
+	})
+
+	t.Run("install --project writes to the repository", func(t *testing.T) {
+		repoDir := filepath.Join(tempDir, "repo")
+		subDir := filepath.Join(repoDir, "docs")
+		require.NoError(t, os.MkdirAll(filepath.Join(repoDir, ".git"), 0755))
+		require.NoError(t, os.MkdirAll(subDir, 0755))
+		// Run from inside the repository, so the coverage directory needs an absolute path
+		coverDir, err := filepath.Abs("tmp/coverage")
+		require.NoError(t, err)
+		runInstall := func(args ...string) (string, error) {
+			cmd := exec.Command(binaryPath, append([]string{"install", "--project"}, args...)...)
+			cmd.Dir = subDir
+			cmd.Env = append(os.Environ(),
+				"HOME="+homeDir,
+				"GOCOVERDIR="+coverDir,
+			)
+			output, err := cmd.CombinedOutput()
+			return string(output), err
+		}
+
+		commandsDir := filepath.Join(repoDir, ".claude", "commands")
+		output, err := runInstall("--check")
+		assert.Error(t, err)
+		assert.Contains(t, output, "Slash commands in "+commandsDir+":")
+		assert.Contains(t, output, "/cr-review   missing")
+		assert.Contains(t, output, "Run `claude-review install --project` to update them")
+
+		output, err = runInstall()
+		require.NoError(t, err, output)
+		assert.Contains(t, output, "Successfully installed 2 slash command(s) to "+commandsDir)
+		assert.FileExists(t, filepath.Join(commandsDir, "cr-review.md"))
+		assert.NoFileExists(t, filepath.Join(subDir, ".claude", "commands", "cr-review.md"))
 	})
 
 	t.Run("install creates missing parent directories", func(t *testing.T) {
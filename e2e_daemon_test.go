@@ -1,10 +1,20 @@
 package main_test
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -104,6 +114,7 @@ func TestE2E_Daemon_Status(t *testing.T) {
 	assert.Contains(t, output, "Port:")
 	assert.Contains(t, output, "PID file:")
 	assert.Contains(t, output, "Log file:")
+	assert.Contains(t, output, "Watcher: fsnotify")
 
 	// Stop daemon for cleanup
 	_, _ = env.runCLI(t, "server", "--stop")
@@ -236,6 +247,60 @@ func TestE2E_Daemon_LogFile(t *testing.T) {
 	_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
 }
 
+func TestE2E_Daemon_Logs(t *testing.T) {
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+
+	// Small enough that a few requests rotate the log
+	t.Setenv("CR_LOG_LEVEL", "debug")
+	t.Setenv("CR_LOG_MAX_SIZE", "4KB")
+	output, err := env.runCLI(t, "server", "--daemon")
+	require.NoError(t, err, output)
+	require.NoError(t, waitForServer(env.BaseURL, 10*time.Second))
+
+	logFile := filepath.Join(env.DataDir, "server.log")
+	var requestID string
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(logFile + ".1"); err == nil {
+			break
+		}
+		resp := env.do(t, http.MethodGet, "/api/v1/comments?project_directory="+url.QueryEscape(env.ProjectDir), nil)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		requestID = resp.Header.Get("X-Request-Id")
+	}
+	require.FileExists(t, logFile+".1", "the log should have rotated")
+	info, err := os.Stat(logFile)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(4<<10))
+
+	// The last request's ID is on both its HTTP and its SQL log lines
+	output, err = env.runCLI(t, "logs")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, `msg="Server listening"`, "rotated logs are included")
+	assert.Regexp(t, `level=DEBUG msg="SQL query" request_id=`+requestID+` query="SELECT`, output)
+	assert.Regexp(t, `level=INFO msg=Request request_id=`+requestID+` method=GET`, output)
+
+	output, err = env.runCLI(t, "logs", "--level", "warn")
+	require.NoError(t, err, output)
+	assert.NotContains(t, output, "level=INFO")
+	assert.NotContains(t, output, "level=DEBUG")
+
+	output, err = env.runCLI(t, "logs", "--level", "loud")
+	assert.Error(t, err)
+	assert.Contains(t, output, `Invalid level "loud"`)
+}
+
 func TestE2E_Daemon_GracefulShutdown(t *testing.T) {
 	env := setupE2E(t)
 
@@ -270,7 +335,7 @@ func TestE2E_Daemon_GracefulShutdown(t *testing.T) {
 		"selected_text":     "Test Document",
 		"comment_text":      "Test comment",
 	}
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	_ = resp.Body.Close()
 	require.Equal(t, 200, resp.StatusCode)
 
@@ -362,3 +427,418 @@ func TestE2E_Daemon_ProcessIsolation(t *testing.T) {
 	_, _ = env.runCLI(t, "server", "--stop")
 	_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
 }
+
+func TestE2E_Server_GracefulShutdown(t *testing.T) {
+	env := setupE2E(t)
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	scanner := subscribeToFile(t, env)
+
+	exited := make(chan error, 1)
+	go func() { exited <- env.ServerCmd.Wait() }()
+	require.NoError(t, env.ServerCmd.Process.Signal(syscall.SIGTERM))
+
+	// Open viewers are told before their stream ends
+	_, ok := readSSEEvent(t, scanner, "server_stopping", 5*time.Second)
+	assert.True(t, ok, "Should receive server_stopping")
+	for scanner.Scan() {
+		assert.Empty(t, scanner.Text(), "The stream should end after server_stopping")
+	}
+
+	select {
+	case err := <-exited:
+		assert.NoError(t, err, "Server should exit cleanly")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not exit")
+	}
+
+	logs, err := os.ReadFile(env.LogFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(logs), "Server stopped")
+}
+
+func TestE2E_Server_InvalidDrainTimeout(t *testing.T) {
+	env := setupE2E(t)
+	t.Setenv("CR_DRAIN_TIMEOUT", "soon")
+
+	// Fails before binding, so the running server's port does not matter
+	output, err := env.runCLI(t, "server")
+	assert.Error(t, err)
+	assert.Contains(t, output, "invalid CR_DRAIN_TIMEOUT")
+}
+
+func TestE2E_Daemon_PortFallback(t *testing.T) {
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+
+	// Something else holds the configured port
+	squatter, err := net.Listen("tcp", "127.0.0.1:"+env.Port)
+	require.NoError(t, err)
+	defer func() { _ = squatter.Close() }()
+
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+
+	output, err := env.runCLI(t, "server", "--daemon")
+	require.NoError(t, err, output)
+	assert.NotContains(t, output, "Port: "+env.Port)
+
+	// The daemon records where it listens
+	data, err := os.ReadFile(filepath.Join(env.DataDir, "server.json"))
+	require.NoError(t, err)
+	var state struct {
+		Port int    `json:"port"`
+		URL  string `json:"url"`
+	}
+	require.NoError(t, json.Unmarshal(data, &state))
+	require.NotEqual(t, env.Port, strconv.Itoa(state.Port))
+	assert.Contains(t, output, "URL: "+state.URL)
+	require.NoError(t, waitForServer(state.URL, 5*time.Second))
+
+	// Every command finds it there
+	output, err = env.runCLI(t, "review", "--project", env.ProjectDir, "--file", "test.md")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, state.URL+"/projects"+env.ProjectDir+"/test.md")
+
+	output, err = env.runCLI(t, "server", "--status")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Port: "+strconv.Itoa(state.Port))
+	assert.Contains(t, output, "Watcher: fsnotify")
+
+	// CLI notifications reach the daemon too
+	output, err = env.runCLI(t, "register", "--project", t.TempDir())
+	require.NoError(t, err, output)
+	assert.NotContains(t, output, "Could not notify server")
+
+	// Stopping removes the state file
+	_, err = env.runCLI(t, "server", "--stop")
+	require.NoError(t, err)
+	require.NoError(t, waitForPIDFileRemoved(env.PIDFile(), 5*time.Second))
+	_, err = os.Stat(filepath.Join(env.DataDir, "server.json"))
+	assert.True(t, os.IsNotExist(err), "state file should be removed on shutdown")
+}
+
+func TestE2E_Daemon_RecycledPID(t *testing.T) {
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+
+	// The server died and the OS handed its PID to an unrelated process
+	unrelated := exec.Command("sleep", "30")
+	require.NoError(t, unrelated.Start())
+	t.Cleanup(func() {
+		_ = unrelated.Process.Kill()
+		_ = unrelated.Wait()
+	})
+	require.NoError(t, os.WriteFile(env.PIDFile(), []byte(strconv.Itoa(unrelated.Process.Pid)), 0644))
+
+	output, err := env.runCLI(t, "server", "--status")
+	assert.Error(t, err)
+	assert.Contains(t, output, "Server is not running")
+
+	require.NoError(t, os.WriteFile(env.PIDFile(), []byte(strconv.Itoa(unrelated.Process.Pid)), 0644))
+	output, err = env.runCLI(t, "server", "--stop")
+	assert.Error(t, err)
+	assert.Contains(t, output, "server is not running")
+	assert.NoError(t, unrelated.Process.Signal(syscall.Signal(0)), "the unrelated process must not be signalled")
+
+	// Nothing stops a new server from starting
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+	output, err = env.runCLI(t, "server", "--daemon")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Server started as daemon")
+}
+
+func TestE2E_Daemon_ConcurrentStarts(t *testing.T) {
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+
+	// Several agents open a review at the same moment
+	const agents = 5
+	outputs := make([]string, agents)
+	errs := make([]error, agents)
+	var wg sync.WaitGroup
+	for i := 0; i < agents; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outputs[i], errs[i] = env.runCLI(t, "review", "--project", env.ProjectDir, "--file", "test.md")
+		}(i)
+	}
+	wg.Wait()
+
+	wantURL := env.BaseURL + "/projects" + env.ProjectDir + "/test.md"
+	for i := 0; i < agents; i++ {
+		require.NoError(t, errs[i], outputs[i])
+		assert.Contains(t, outputs[i], wantURL)
+	}
+
+	// Exactly one of them started a server
+	logs, err := os.ReadFile(filepath.Join(env.DataDir, "server.log"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(logs), `msg="Server listening"`), string(logs))
+
+	output, err := env.runCLI(t, "server", "--status")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Port: "+env.Port)
+}
+
+// buildVersionedBinary builds the CLI with the given version stamped in
+func buildVersionedBinary(t *testing.T, version string) string {
+	t.Helper()
+
+	binary := filepath.Join(t.TempDir(), "claude-review")
+	cmd := exec.Command("go", "build", "-ldflags", "-X main.Version="+version, "-o", binary, ".")
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	return binary
+}
+
+func TestE2E_Daemon_VersionMismatch(t *testing.T) {
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+
+	oldCLI, newCLI := *env, *env
+	oldCLI.BinaryPath = buildVersionedBinary(t, "v1.0.0")
+	newCLI.BinaryPath = buildVersionedBinary(t, "v2.0.0")
+
+	daemonVersion := func() (version string, pid int) {
+		resp, err := http.Get(env.BaseURL + "/api/version")
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		var info struct {
+			Version string `json:"version"`
+			PID     int    `json:"pid"`
+			Daemon  bool   `json:"daemon"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+		assert.True(t, info.Daemon)
+		return info.Version, info.PID
+	}
+
+	output, err := oldCLI.runCLI(t, "server", "--daemon")
+	require.NoError(t, err, output)
+	version, oldPID := daemonVersion()
+	require.Equal(t, "v1.0.0", version)
+
+	// The upgraded CLI replaces the outdated daemon before using it
+	output, err = newCLI.runCLI(t, "review", "--project", env.ProjectDir, "--file", "test.md")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "runs version v1.0.0, restarting it with v2.0.0")
+	assert.Contains(t, output, env.BaseURL+"/projects"+env.ProjectDir+"/test.md")
+	version, newPID := daemonVersion()
+	assert.Equal(t, "v2.0.0", version)
+	assert.NotEqual(t, oldPID, newPID)
+
+	// An older CLI leaves a newer daemon alone
+	output, err = oldCLI.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "it is newer than this command")
+	version, pid := daemonVersion()
+	assert.Equal(t, "v2.0.0", version)
+	assert.Equal(t, newPID, pid)
+
+	// Explicit restart
+	output, err = newCLI.runCLI(t, "server", "--restart")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Server started as daemon")
+	version, pid = daemonVersion()
+	assert.Equal(t, "v2.0.0", version)
+	assert.NotEqual(t, newPID, pid)
+}
+
+func TestE2E_Daemon_IdleShutdown(t *testing.T) {
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	t.Setenv("CR_IDLE_TIMEOUT", "1s")
+	_, err = env.runCLI(t, "server", "--daemon")
+	require.NoError(t, err)
+	require.NoError(t, waitForServer(env.BaseURL, 10*time.Second))
+
+	output, err := env.runCLI(t, "server", "--status")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Idle shutdown: in")
+
+	// A connected viewer keeps the daemon alive past the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
+		env.BaseURL, url.QueryEscape(env.ProjectDir)), nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	_, ok := readSSEEvent(t, bufio.NewScanner(resp.Body), "connected", 3*time.Second)
+	require.True(t, ok)
+
+	time.Sleep(2 * time.Second)
+	output, err = env.runCLI(t, "server", "--status")
+	require.NoError(t, err)
+	assert.Contains(t, output, "paused while 1 SSE client(s) are connected")
+
+	// Once it leaves, the daemon shuts down on its own
+	cancel()
+	require.NoError(t, waitForPIDFileRemoved(env.PIDFile(), 5*time.Second), "Daemon should shut down when idle")
+
+	logContent, err := os.ReadFile(filepath.Join(env.DataDir, "server.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(logContent), `msg="No SSE clients or API calls, shutting down gracefully" idle_timeout=1s`)
+}
+
+func TestE2E_Service_SocketActivation(t *testing.T) {
+	socketActivate, err := exec.LookPath("systemd-socket-activate")
+	if err != nil {
+		t.Skip("systemd-socket-activate not available")
+	}
+	env := setupE2E(t)
+
+	// Kill the foreground server started by setupE2E
+	if env.ServerCmd.Process != nil {
+		_ = env.ServerCmd.Process.Kill()
+		_ = env.ServerCmd.Wait()
+		_ = waitForProcessStop(env.ServerCmd.Process, 2*time.Second)
+	}
+
+	t.Cleanup(func() {
+		_, _ = env.runCLI(t, "server", "--stop")
+		_ = waitForPIDFileRemoved(env.PIDFile(), 2*time.Second)
+	})
+
+	// Install the units without touching the real user service manager
+	configDir := env.ConfigDir
+	t.Setenv("PATH", t.TempDir())
+	output, err := env.runCLI(t, "service", "install")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "systemctl --user enable --now claude-review.socket")
+	unit, err := os.ReadFile(filepath.Join(configDir, "systemd", "user", "claude-review.socket"))
+	require.NoError(t, err)
+	assert.Contains(t, string(unit), "ListenStream=127.0.0.1:"+env.Port)
+
+	// Stand in for systemd: listen on the socket, start the server on the
+	// first connection
+	activator := exec.Command(socketActivate, "-l", "127.0.0.1:"+env.Port,
+		"-E", "CR_DATA_DIR="+env.DataDir, "-E", "GOCOVERDIR=tmp/coverage",
+		env.BinaryPath, "server")
+	activatorLog, err := activator.StderrPipe()
+	require.NoError(t, err)
+	require.NoError(t, activator.Start())
+	t.Cleanup(func() { _ = activator.Process.Kill(); _ = activator.Wait() })
+
+	// Connecting would start the server, so wait for the socket to be announced
+	scanner := bufio.NewScanner(activatorLog)
+	require.True(t, scanner.Scan())
+	require.Contains(t, scanner.Text(), "Listening on")
+	go func() {
+		for scanner.Scan() {
+		}
+	}()
+
+	// review connects, and systemd starts the server instead of a fork
+	output, err = env.runCLI(t, "review", "--project", env.ProjectDir, "--file", "test.md")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Server started by systemd")
+	assert.Contains(t, output, env.BaseURL+"/projects")
+
+	output, err = env.runCLI(t, "server", "--status")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, fmt.Sprintf("Server is running (PID: %d)", activator.Process.Pid))
+	assert.Contains(t, output, "Port: "+env.Port)
+
+	output, err = env.runCLI(t, "service", "uninstall")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Removed claude-review.socket and claude-review.service")
+}
+
+func TestE2E_Server_Metrics(t *testing.T) {
+	env := setupE2E(t)
+
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+	resp := env.postJSON(t, "/api/v1/comments", map[string]interface{}{
+		"project_directory": env.ProjectDir,
+		"file_path":         "test.md",
+		"line_start":        1,
+		"line_end":          1,
+		"selected_text":     "Test Document",
+		"comment_text":      "Counted",
+	})
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(env.BaseURL + "/healthz")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(env.BaseURL + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "claude_review_comments_created_total 1\n")
+	assert.Contains(t, string(body), `claude_review_db_query_duration_seconds_count{operation="CreateComment"} 1`)
+
+	output, err := env.runCLI(t, "server", "--status")
+	require.NoError(t, err, output)
+	assert.NotContains(t, output, "Metrics:")
+
+	output, err = env.runCLI(t, "server", "--status", "--verbose")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Metrics:")
+	assert.Contains(t, output, "  Comments created: 1\n")
+	assert.Contains(t, output, "  DB query latency (CreateComment): 1, mean ")
+}
@@ -44,7 +44,7 @@ Plain text at the end.`,
 	}
 
 	// Test 1: API response includes rendered_html
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -128,7 +128,7 @@ func TestE2E_Markdown_PlainTextPreserved(t *testing.T) {
 		"comment_text":      "This is just plain text with no formatting.",
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -160,7 +160,7 @@ func TestE2E_Markdown_SpecialCharacters(t *testing.T) {
 		"comment_text":      `Special chars: "quotes" & <html> symbols`,
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -209,7 +209,7 @@ func TestE2E_Markdown_ThreadedComments(t *testing.T) {
 		"comment_text":      "Root: **Important** issue here",
 	}
 
-	rootResp := env.postJSON(t, "/api/comments", rootComment)
+	rootResp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = rootResp.Body.Close() }()
 	require.Equal(t, http.StatusOK, rootResp.StatusCode)
 
@@ -230,7 +230,7 @@ func TestE2E_Markdown_ThreadedComments(t *testing.T) {
 		"root_id":           rootID,
 	}
 
-	replyResp := env.postJSON(t, "/api/comments", reply)
+	replyResp := env.postJSON(t, "/api/v1/comments", reply)
 	defer func() { _ = replyResp.Body.Close() }()
 	require.Equal(t, http.StatusOK, replyResp.StatusCode)
 
@@ -274,7 +274,7 @@ func TestE2E_Markdown_GFM_Tables(t *testing.T) {
 | Links   | ✓      |`,
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -307,7 +307,7 @@ func TestE2E_Markdown_GFM_Strikethrough(t *testing.T) {
 		"comment_text":      "This is ~~incorrect~~ wrong text.",
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -334,7 +334,7 @@ func TestE2E_Markdown_GFM_Linkify(t *testing.T) {
 		"comment_text":      "Check this out: https://example.com and http://test.org",
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -366,7 +366,7 @@ func TestE2E_Markdown_GFM_TaskList(t *testing.T) {
 - [x] Another completed task`,
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -419,7 +419,7 @@ Visit https://github.com for more info.
 - [ ] Test more features`,
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
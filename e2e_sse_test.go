@@ -22,7 +22,7 @@ func TestE2E_SSE_Connection(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect to SSE endpoint
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	resp, err := http.Get(sseURL)
@@ -51,7 +51,7 @@ func TestE2E_SSE_FileUpdate(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect to SSE endpoint
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -75,21 +75,14 @@ func TestE2E_SSE_FileUpdate(t *testing.T) {
 	}()
 	close(ready)
 
-	// Wait for file_updated event
-	eventReceived := false
-	deadline := time.Now().Add(5 * time.Second)
-
-	for time.Now().Before(deadline) && scanner.Scan() {
-		line := scanner.Text()
-		t.Logf("SSE line: %s", line)
+	// Wait for document_rendered event carrying the new HTML
+	data, ok := readSSEEvent(t, scanner, "document_rendered", 5*time.Second)
+	require.True(t, ok, "Should receive document_rendered event")
 
-		if strings.Contains(line, "event: file_updated") {
-			eventReceived = true
-			break
-		}
-	}
-
-	assert.True(t, eventReceived, "Should receive file_updated event")
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &payload))
+	assert.Equal(t, "test.md", payload["file_path"])
+	assert.Contains(t, payload["html"], "New Section")
 }
 
 func TestE2E_SSE_CommentsResolved(t *testing.T) {
@@ -106,11 +99,11 @@ func TestE2E_SSE_CommentsResolved(t *testing.T) {
 		"selected_text":     "Test Document",
 		"comment_text":      "To be resolved",
 	}
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	_ = resp.Body.Close()
 
 	// Connect to SSE endpoint
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -129,21 +122,99 @@ func TestE2E_SSE_CommentsResolved(t *testing.T) {
 		_, _ = env.runCLI(t, "resolve", "--file", "test.md", "--project", env.ProjectDir)
 	}()
 
-	// Wait for comments_resolved event
-	eventReceived := false
-	deadline := time.Now().Add(5 * time.Second)
+	// Wait for thread_resolved event
+	data, ok := readSSEEvent(t, scanner, "thread_resolved", 5*time.Second)
+	require.True(t, ok, "Should receive thread_resolved event")
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &payload))
+	assert.NotZero(t, payload["root_id"])
+	assert.Equal(t, float64(1), payload["count"])
+}
+
+func TestE2E_SSE_CommentCreated(t *testing.T) {
+	env := setupE2E(t)
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
+		env.BaseURL, url.QueryEscape(env.ProjectDir))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	sseResp, err := client.Get(sseURL)
+	require.NoError(t, err)
+	defer func() { _ = sseResp.Body.Close() }()
+
+	scanner := bufio.NewScanner(sseResp.Body)
+	_, ok := readSSEEvent(t, scanner, "connected", 3*time.Second)
+	require.True(t, ok)
+
+	// A comment created in one tab is broadcast to the others
+	resp := env.postJSON(t, "/api/v1/comments", map[string]interface{}{
+		"project_directory": env.ProjectDir,
+		"file_path":         "test.md",
+		"line_start":        1,
+		"line_end":          1,
+		"selected_text":     "Test Document",
+		"comment_text":      "**Live** comment",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	_ = resp.Body.Close()
+
+	data, ok := readSSEEvent(t, scanner, "comment_created", 5*time.Second)
+	require.True(t, ok, "Should receive comment_created event")
+
+	var payload struct {
+		Comment map[string]interface{} `json:"comment"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(data), &payload))
+	assert.Equal(t, created["id"], payload.Comment["id"])
+	assert.Equal(t, "**Live** comment", payload.Comment["comment_text"])
+	assert.Contains(t, payload.Comment["rendered_html"], "<strong>Live</strong>")
+
+	// Replies from the CLI reach viewers through the daemon
+	_, err = env.runCLI(t, "reply", "--comment-id", fmt.Sprintf("%.0f", created["id"]), "--message", "Agent reply")
+	require.NoError(t, err)
+
+	data, ok = readSSEEvent(t, scanner, "reply_added", 5*time.Second)
+	require.True(t, ok, "Should receive reply_added event")
+	require.NoError(t, json.Unmarshal([]byte(data), &payload))
+	assert.Equal(t, created["id"], payload.Comment["root_id"])
+	assert.Equal(t, "Agent reply", payload.Comment["comment_text"])
+
+	// Deleting the reply is broadcast too
+	resp = env.delete(t, fmt.Sprintf("/api/v1/comments/%.0f", payload.Comment["id"]))
+	_ = resp.Body.Close()
+
+	data, ok = readSSEEvent(t, scanner, "comment_deleted", 5*time.Second)
+	require.True(t, ok, "Should receive comment_deleted event")
+	var deleted map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &deleted))
+	assert.Equal(t, payload.Comment["id"], deleted["id"])
+	assert.Equal(t, created["id"], deleted["root_id"])
+}
 
+// readSSEEvent reads until the named event arrives and returns its data
+func readSSEEvent(t *testing.T, scanner *bufio.Scanner, event string, timeout time.Duration) (string, bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) && scanner.Scan() {
 		line := scanner.Text()
 		t.Logf("SSE line: %s", line)
 
-		if strings.Contains(line, "event: comments_resolved") {
-			eventReceived = true
-			break
+		if line == "event: "+event {
+			for scanner.Scan() {
+				if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+					return data, true
+				}
+			}
+			return "", false
 		}
 	}
-
-	assert.True(t, eventReceived, "Should receive comments_resolved event")
+	return "", false
 }
 
 func TestE2E_SSE_Broadcast(t *testing.T) {
@@ -152,7 +223,7 @@ func TestE2E_SSE_Broadcast(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect to SSE endpoint
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -173,7 +244,7 @@ func TestE2E_SSE_Broadcast(t *testing.T) {
 			"file_path":         "test.md",
 			"event":             "comments_resolved",
 		}
-		resp := env.postJSON(t, "/api/events", broadcastData)
+		resp := env.postJSON(t, "/api/v1/events", broadcastData)
 		_ = resp.Body.Close()
 	}()
 
@@ -204,17 +275,17 @@ func TestE2E_SSE_MissingParams(t *testing.T) {
 	}{
 		{
 			name:  "missing project_directory",
-			url:   env.BaseURL + "/api/events?file_path=test.md",
+			url:   env.BaseURL + "/api/v1/events?file_path=test.md",
 			wants: http.StatusBadRequest,
 		},
 		{
 			name:  "missing file_path",
-			url:   env.BaseURL + "/api/events?project_directory=" + url.QueryEscape(env.ProjectDir),
+			url:   env.BaseURL + "/api/v1/events?project_directory=" + url.QueryEscape(env.ProjectDir),
 			wants: http.StatusBadRequest,
 		},
 		{
 			name:  "missing both",
-			url:   env.BaseURL + "/api/events",
+			url:   env.BaseURL + "/api/v1/events",
 			wants: http.StatusBadRequest,
 		},
 	}
@@ -230,13 +301,37 @@ func TestE2E_SSE_MissingParams(t *testing.T) {
 	}
 }
 
+func TestE2E_SSE_FileScopeStaysInRegisteredProjects(t *testing.T) {
+	env := setupE2E(t)
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.md"), []byte("# Secret\n"), 0644))
+
+	get := func(query string) int {
+		t.Helper()
+		resp, err := http.Get(env.BaseURL + "/api/v1/events?" + query)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, get("project_directory="+url.QueryEscape(outside)+"&file_path=secret.md"),
+		"a directory that is not a registered project")
+
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+	escape, err := filepath.Rel(env.ProjectDir, filepath.Join(outside, "secret.md"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, get("project_directory="+url.QueryEscape(env.ProjectDir)+"&file_path="+url.QueryEscape(escape)))
+	assert.Equal(t, http.StatusBadRequest, get("project_directory="+url.QueryEscape(env.ProjectDir)+"&file_path="+url.QueryEscape(filepath.Join(outside, "secret.md"))))
+}
+
 func TestE2E_SSE_MultipleClients(t *testing.T) {
 	env := setupE2E(t)
 	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
 	require.NoError(t, err)
 
 	// Connect multiple clients to the same file
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -268,7 +363,7 @@ func TestE2E_SSE_MultipleClients(t *testing.T) {
 			"file_path":         "test.md",
 			"event":             "comments_resolved",
 		}
-		resp := env.postJSON(t, "/api/events", broadcastData)
+		resp := env.postJSON(t, "/api/v1/events", broadcastData)
 		_ = resp.Body.Close()
 	}()
 
@@ -318,11 +413,11 @@ func TestE2E_SSE_ClientFiltering(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect to test.md
-	sseURL1 := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL1 := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	// Connect to simple.md
-	sseURL2 := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=simple.md",
+	sseURL2 := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=simple.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -351,7 +446,7 @@ func TestE2E_SSE_ClientFiltering(t *testing.T) {
 			"file_path":         "test.md", // Only test.md
 			"event":             "comments_resolved",
 		}
-		resp := env.postJSON(t, "/api/events", broadcastData)
+		resp := env.postJSON(t, "/api/v1/events", broadcastData)
 		_ = resp.Body.Close()
 	}()
 
@@ -403,7 +498,7 @@ func TestE2E_Broadcast_API(t *testing.T) {
 		"event":             "test_event",
 	}
 
-	resp := env.postJSON(t, "/api/events", broadcastData)
+	resp := env.postJSON(t, "/api/v1/events", broadcastData)
 	defer func() { _ = resp.Body.Close() }()
 
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
@@ -414,3 +509,72 @@ func TestE2E_Broadcast_API(t *testing.T) {
 
 	assert.Equal(t, "broadcast", result["status"])
 }
+
+func TestE2E_SSE_ProjectAndGlobalScopes(t *testing.T) {
+	env := setupE2E(t)
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	subscribe := func(query string) *bufio.Scanner {
+		resp, err := client.Get(env.BaseURL + "/api/v1/events?" + query)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		scanner := bufio.NewScanner(resp.Body)
+		_, ok := readSSEEvent(t, scanner, "connected", 3*time.Second)
+		require.True(t, ok)
+		return scanner
+	}
+
+	project := subscribe("scope=project&project_directory=" + url.QueryEscape(env.ProjectDir))
+	home := subscribe("scope=global")
+
+	// A new Markdown file in a new subdirectory shows up in the directory listing
+	require.NoError(t, os.MkdirAll(filepath.Join(env.ProjectDir, "docs"), 0755))
+	data, ok := readSSEEvent(t, project, "files_changed", 5*time.Second)
+	require.True(t, ok, "Should receive files_changed for the new directory")
+	assert.Contains(t, data, `"path":"docs"`)
+
+	require.NoError(t, os.WriteFile(filepath.Join(env.ProjectDir, "docs", "plan.md"), []byte("# Plan\n"), 0644))
+	data, ok = readSSEEvent(t, project, "files_changed", 5*time.Second)
+	require.True(t, ok, "Should receive files_changed for the new file")
+	assert.Contains(t, data, `"path":"docs/plan.md"`)
+
+	resp, err := client.Get(env.BaseURL + "/projects" + env.ProjectDir + "/docs/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, os.Remove(filepath.Join(env.ProjectDir, "docs", "plan.md")))
+	data, ok = readSSEEvent(t, project, "files_changed", 5*time.Second)
+	require.True(t, ok, "Should receive files_changed for the deleted file")
+	assert.Contains(t, data, `"path":"docs/plan.md"`)
+
+	// Comment counts change on both pages
+	resp = env.postJSON(t, "/api/v1/comments", map[string]interface{}{
+		"project_directory": env.ProjectDir,
+		"file_path":         "test.md",
+		"line_start":        1,
+		"line_end":          1,
+		"selected_text":     "Test Document",
+		"comment_text":      "Counted",
+	})
+	_ = resp.Body.Close()
+
+	for _, scanner := range []*bufio.Scanner{project, home} {
+		data, ok = readSSEEvent(t, scanner, "comments_changed", 5*time.Second)
+		require.True(t, ok, "Should receive comments_changed")
+		assert.Contains(t, data, `"file_path":"test.md"`)
+	}
+
+	// Newly registered projects appear on the home page
+	other := t.TempDir()
+	_, err = env.runCLI(t, "register", "--project", other)
+	require.NoError(t, err)
+
+	data, ok = readSSEEvent(t, home, "project_registered", 5*time.Second)
+	require.True(t, ok, "Should receive project_registered")
+	assert.Contains(t, data, other)
+}
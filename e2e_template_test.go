@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -18,22 +20,26 @@ func TestE2E_Template_JSONEncoding(t *testing.T) {
 	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
 	require.NoError(t, err)
 
+	// The server checks that selected_text occurs in the file, so quote it from a real line
+	require.NoError(t, os.WriteFile(filepath.Join(env.ProjectDir, "quotes.md"),
+		[]byte("# Test \"with\" quotes & symbols\n"), 0644))
+
 	// Create a comment with special characters to ensure proper escaping
 	comment := map[string]interface{}{
 		"project_directory": env.ProjectDir,
-		"file_path":         "test.md",
+		"file_path":         "quotes.md",
 		"line_start":        1,
 		"line_end":          1,
 		"selected_text":     `Test "with" quotes & symbols`,
 		"comment_text":      `This has "quotes" and 'apostrophes' and <html> & symbols`,
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	// Fetch the viewer page
-	url := fmt.Sprintf("%s/projects%s/test.md", env.BaseURL, env.ProjectDir)
+	url := fmt.Sprintf("%s/projects%s/quotes.md", env.BaseURL, env.ProjectDir)
 	viewerResp, err := http.Get(url)
 	require.NoError(t, err)
 	defer func() { _ = viewerResp.Body.Close() }()
@@ -46,7 +52,7 @@ func TestE2E_Template_JSONEncoding(t *testing.T) {
 	// More flexible regex that allows for whitespace variations
 	// Match until we hit a semicolon followed by whitespace and </script>
 	scriptRegex := regexp.MustCompile(
-		`(?s)<script>.*?const projectDir = (.+?);.*?const filePath = (.+?);.*?let comments = (.+?);\s*</script>`,
+		`(?s)<script nonce="[^"]+">.*?const projectDir = (.+?);.*?const filePath = (.+?);.*?let comments = (.+?);\s*</script>`,
 	)
 	matches := scriptRegex.FindStringSubmatch(bodyStr)
 	if matches == nil {
@@ -69,7 +75,7 @@ func TestE2E_Template_JSONEncoding(t *testing.T) {
 	var filePathDecoded string
 	err = json.Unmarshal([]byte(filePathJS), &filePathDecoded)
 	require.NoError(t, err, "filePath should be valid JSON: %s", filePathJS)
-	assert.Equal(t, "test.md", filePathDecoded, "filePath should match")
+	assert.Equal(t, "quotes.md", filePathDecoded, "filePath should match")
 
 	// Test 3: comments should be valid JSON array
 	var commentsDecoded []map[string]interface{}
@@ -49,6 +49,7 @@ type TestEnv struct {
 	ServerCmd  *exec.Cmd
 	TempDir    string
 	DataDir    string
+	ConfigDir  string
 	ProjectDir string
 	Port       string
 	BaseURL    string
@@ -66,8 +67,12 @@ func setupE2E(t *testing.T) *TestEnv {
 	// Create temp directories
 	tempDir := t.TempDir()
 	dataDir := filepath.Join(tempDir, "data")
+	configDir := filepath.Join(tempDir, "config")
 	projectDir := filepath.Join(tempDir, "project")
 
+	// Keep the user's global config out of the server and CLI commands
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+
 	require.NoError(t, os.MkdirAll(dataDir, 0755))
 	require.NoError(t, os.MkdirAll(projectDir, 0755))
 
@@ -105,6 +110,7 @@ func setupE2E(t *testing.T) *TestEnv {
 		ServerCmd:  serverCmd,
 		TempDir:    tempDir,
 		DataDir:    dataDir,
+		ConfigDir:  configDir,
 		ProjectDir: projectDir,
 		Port:       port,
 		BaseURL:    "http://localhost:" + port,
@@ -243,48 +249,54 @@ func (env *TestEnv) runCLI(t *testing.T, args ...string) (string, error) {
 	return string(output), err
 }
 
-func (env *TestEnv) postJSON(t *testing.T, path string, data interface{}) *http.Response {
+// apiToken returns the per-install token the daemon wrote to the data directory
+func (env *TestEnv) apiToken(t *testing.T) string {
 	t.Helper()
 
-	jsonData, err := json.Marshal(data)
+	data, err := os.ReadFile(filepath.Join(env.DataDir, "api.token"))
 	require.NoError(t, err)
+	return strings.TrimSpace(string(data))
+}
 
-	resp, err := http.Post(
-		env.BaseURL+path,
-		"application/json",
-		bytes.NewReader(jsonData),
-	)
+// do sends an authenticated API request, as the viewer and CLI do
+func (env *TestEnv) do(t *testing.T, method, path string, body io.Reader) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, env.BaseURL+path, body)
+	require.NoError(t, err)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Claude-Review-Token", env.apiToken(t))
+
+	resp, err := http.DefaultClient.Do(req)
 	require.NoError(t, err)
 
 	return resp
 }
 
-func (env *TestEnv) patchJSON(t *testing.T, path string, data interface{}) *http.Response {
+func (env *TestEnv) postJSON(t *testing.T, path string, data interface{}) *http.Response {
 	t.Helper()
 
 	jsonData, err := json.Marshal(data)
 	require.NoError(t, err)
 
-	req, err := http.NewRequest(http.MethodPatch, env.BaseURL+path, bytes.NewReader(jsonData))
-	require.NoError(t, err)
-	req.Header.Set("Content-Type", "application/json")
+	return env.do(t, http.MethodPost, path, bytes.NewReader(jsonData))
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func (env *TestEnv) patchJSON(t *testing.T, path string, data interface{}) *http.Response {
+	t.Helper()
+
+	jsonData, err := json.Marshal(data)
 	require.NoError(t, err)
 
-	return resp
+	return env.do(t, http.MethodPatch, path, bytes.NewReader(jsonData))
 }
 
 func (env *TestEnv) delete(t *testing.T, path string) *http.Response {
 	t.Helper()
 
-	req, err := http.NewRequest(http.MethodDelete, env.BaseURL+path, nil)
-	require.NoError(t, err)
-
-	resp, err := http.DefaultClient.Do(req)
-	require.NoError(t, err)
-
-	return resp
+	return env.do(t, http.MethodDelete, path, nil)
 }
 
 // Tests
@@ -315,7 +327,7 @@ func TestE2E_CommentWorkflow(t *testing.T) {
 		"comment_text":      "This needs work",
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -333,7 +345,7 @@ func TestE2E_CommentWorkflow(t *testing.T) {
 	assert.Contains(t, output, "Found 1 unresolved comment")
 
 	// Update the comment
-	updateResp := env.patchJSON(t, fmt.Sprintf("/api/comments/%d", commentID), map[string]string{
+	updateResp := env.patchJSON(t, fmt.Sprintf("/api/v1/comments/%d", commentID), map[string]string{
 		"comment_text": "Updated feedback",
 	})
 	defer func() { _ = updateResp.Body.Close() }()
@@ -390,7 +402,7 @@ func TestE2E_MultipleComments(t *testing.T) {
 	}
 
 	for _, c := range comments {
-		resp := env.postJSON(t, "/api/comments", c)
+		resp := env.postJSON(t, "/api/v1/comments", c)
 		_ = resp.Body.Close()
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 	}
@@ -426,14 +438,14 @@ func TestE2E_DeleteComment(t *testing.T) {
 		"comment_text":      "To be deleted",
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	var created map[string]interface{}
 	_ = json.NewDecoder(resp.Body).Decode(&created)
 	_ = resp.Body.Close()
 	commentID := int(created["id"].(float64))
 
 	// Delete comment
-	deleteResp := env.delete(t, fmt.Sprintf("/api/comments/%d", commentID))
+	deleteResp := env.delete(t, fmt.Sprintf("/api/v1/comments/%d", commentID))
 	defer func() { _ = deleteResp.Body.Close() }()
 	assert.Equal(t, http.StatusOK, deleteResp.StatusCode)
 
@@ -552,12 +564,7 @@ func TestE2E_InvalidInput(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("malformed JSON", func(t *testing.T) {
-		resp, err := http.Post(
-			env.BaseURL+"/api/comments",
-			"application/json",
-			strings.NewReader("{invalid json}"),
-		)
-		require.NoError(t, err)
+		resp := env.do(t, http.MethodPost, "/api/v1/comments", strings.NewReader("{invalid json}"))
 		defer func() { _ = resp.Body.Close() }()
 
 		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
@@ -569,15 +576,72 @@ func TestE2E_InvalidInput(t *testing.T) {
 			// Missing other required fields
 		}
 
-		resp := env.postJSON(t, "/api/comments", comment)
+		resp := env.postJSON(t, "/api/v1/comments", comment)
 		defer func() { _ = resp.Body.Close() }()
 
 		// Should fail (either 400 or 500, depending on validation)
 		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
 	})
 
+	t.Run("comment targets", func(t *testing.T) {
+		outside := filepath.Join(filepath.Dir(env.ProjectDir), "outside.md")
+		require.NoError(t, os.WriteFile(outside, []byte("# Test Document\n"), 0644))
+
+		testCases := []struct {
+			name       string
+			projectDir string
+			filePath   string
+			selected   string
+			wants      int
+		}{
+			{"unregistered project", t.TempDir(), "test.md", "Test Document", http.StatusNotFound},
+			{"missing file", env.ProjectDir, "missing.md", "Test Document", http.StatusNotFound},
+			{"path traversal", env.ProjectDir, "../outside.md", "Test Document", http.StatusBadRequest},
+			{"absolute path", env.ProjectDir, outside, "Test Document", http.StatusBadRequest},
+			{"selection not in file", env.ProjectDir, "test.md", "Nowhere to be found", http.StatusBadRequest},
+			{"selection far from lines", env.ProjectDir, "test.md", "Final paragraph", http.StatusBadRequest},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				resp := env.postJSON(t, "/api/v1/comments", map[string]interface{}{
+					"project_directory": tc.projectDir,
+					"file_path":         tc.filePath,
+					"line_start":        1,
+					"line_end":          1,
+					"selected_text":     tc.selected,
+					"comment_text":      "Comment",
+				})
+				defer func() { _ = resp.Body.Close() }()
+
+				assert.Equal(t, tc.wants, resp.StatusCode)
+			})
+		}
+	})
+
+	t.Run("size limits", func(t *testing.T) {
+		resp := env.postJSON(t, "/api/v1/comments", map[string]interface{}{
+			"project_directory": env.ProjectDir,
+			"file_path":         "test.md",
+			"line_start":        1,
+			"line_end":          1,
+			"selected_text":     "Test Document",
+			"comment_text":      strings.Repeat("x", 65<<10),
+		})
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "oversized comment_text")
+
+		resp = env.postJSON(t, "/api/v1/comments", map[string]interface{}{
+			"project_directory": env.ProjectDir,
+			"file_path":         "test.md",
+			"comment_text":      strings.Repeat("x", 2<<20),
+		})
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, "oversized body")
+	})
+
 	t.Run("update non-existent comment", func(t *testing.T) {
-		resp := env.patchJSON(t, "/api/comments/99999", map[string]string{
+		resp := env.patchJSON(t, "/api/v1/comments/99999", map[string]string{
 			"comment_text": "Updated",
 		})
 		defer func() { _ = resp.Body.Close() }()
@@ -587,11 +651,11 @@ func TestE2E_InvalidInput(t *testing.T) {
 	})
 
 	t.Run("delete non-existent comment", func(t *testing.T) {
-		resp := env.delete(t, "/api/comments/99999")
+		resp := env.delete(t, "/api/v1/comments/99999")
 		defer func() { _ = resp.Body.Close() }()
 
-		// Should succeed (DELETE affects 0 rows but doesn't error)
-		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		// Should return 404 when comment doesn't exist, like PATCH
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 	})
 }
 
@@ -619,9 +683,9 @@ func TestE2E_MultipleFiles_Isolation(t *testing.T) {
 		"comment_text":      "Comment on simple.md",
 	}
 
-	resp1 := env.postJSON(t, "/api/comments", comment1)
+	resp1 := env.postJSON(t, "/api/v1/comments", comment1)
 	_ = resp1.Body.Close()
-	resp2 := env.postJSON(t, "/api/comments", comment2)
+	resp2 := env.postJSON(t, "/api/v1/comments", comment2)
 	_ = resp2.Body.Close()
 
 	// Verify test.md only shows its comment
@@ -681,7 +745,7 @@ func TestE2E_UpdateComment_RenderedHTML(t *testing.T) {
 		"comment_text":      "This is **bold** text",
 	}
 
-	resp := env.postJSON(t, "/api/comments", comment)
+	resp := env.postJSON(t, "/api/v1/comments", comment)
 	defer func() { _ = resp.Body.Close() }()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -693,7 +757,7 @@ func TestE2E_UpdateComment_RenderedHTML(t *testing.T) {
 	assert.Contains(t, created["rendered_html"], "<strong>bold</strong>")
 
 	// Update the comment with different markdown
-	updateResp := env.patchJSON(t, fmt.Sprintf("/api/comments/%d", commentID), map[string]string{
+	updateResp := env.patchJSON(t, fmt.Sprintf("/api/v1/comments/%d", commentID), map[string]string{
 		"comment_text": "This is *italic* text",
 	})
 	defer func() { _ = updateResp.Body.Close() }()
@@ -27,7 +27,7 @@ func TestE2E_ThreadedComments_BasicReply(t *testing.T) {
 		"author":            "user",
 	}
 
-	resp := env.postJSON(t, "/api/comments", rootComment)
+	resp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = resp.Body.Close() }()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
@@ -72,7 +72,7 @@ func TestE2E_ThreadedComments_MultipleReplies(t *testing.T) {
 		"author":            "user",
 	}
 
-	resp := env.postJSON(t, "/api/comments", rootComment)
+	resp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = resp.Body.Close() }()
 	var created map[string]interface{}
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
@@ -97,7 +97,7 @@ func TestE2E_ThreadedComments_MultipleReplies(t *testing.T) {
 		"author":            "user",
 		"root_id":           rootID,
 	}
-	resp2 := env.postJSON(t, "/api/comments", userReply)
+	resp2 := env.postJSON(t, "/api/v1/comments", userReply)
 	_ = resp2.Body.Close()
 
 	// Verify thread shows all replies in order
@@ -128,7 +128,7 @@ func TestE2E_ThreadedComments_ResolveByCommentID(t *testing.T) {
 		"comment_text":      "First thread",
 		"author":            "user",
 	}
-	resp := env.postJSON(t, "/api/comments", rootComment)
+	resp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = resp.Body.Close() }()
 	var created1 map[string]interface{}
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created1))
@@ -148,7 +148,7 @@ func TestE2E_ThreadedComments_ResolveByCommentID(t *testing.T) {
 		"comment_text":      "Second thread",
 		"author":            "user",
 	}
-	resp2 := env.postJSON(t, "/api/comments", rootComment2)
+	resp2 := env.postJSON(t, "/api/v1/comments", rootComment2)
 	defer func() { _ = resp2.Body.Close() }()
 	var created2 map[string]interface{}
 	require.NoError(t, json.NewDecoder(resp2.Body).Decode(&created2))
@@ -187,7 +187,7 @@ func TestE2E_ThreadedComments_ResolveEntireThread(t *testing.T) {
 		"comment_text":      "Root comment",
 		"author":            "user",
 	}
-	resp := env.postJSON(t, "/api/comments", rootComment)
+	resp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = resp.Body.Close() }()
 	var created map[string]interface{}
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
@@ -232,7 +232,7 @@ func TestE2E_ThreadedComments_ReplyValidation(t *testing.T) {
 			"comment_text":      "Root",
 			"author":            "user",
 		}
-		resp := env.postJSON(t, "/api/comments", rootComment)
+		resp := env.postJSON(t, "/api/v1/comments", rootComment)
 		defer func() { _ = resp.Body.Close() }()
 		var created map[string]interface{}
 		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
@@ -251,7 +251,7 @@ func TestE2E_ThreadedComments_ReplyValidation(t *testing.T) {
 			"author":            "agent",
 			"root_id":           rootID,
 		}
-		resp2 := env.postJSON(t, "/api/comments", replyComment)
+		resp2 := env.postJSON(t, "/api/v1/comments", replyComment)
 		defer func() { _ = resp2.Body.Close() }()
 		var createdReply map[string]interface{}
 		require.NoError(t, json.NewDecoder(resp2.Body).Decode(&createdReply))
@@ -279,7 +279,7 @@ func TestE2E_ThreadedComments_ResolveViaAPI(t *testing.T) {
 		"comment_text":      "Root comment",
 		"author":            "user",
 	}
-	resp := env.postJSON(t, "/api/comments", rootComment)
+	resp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = resp.Body.Close() }()
 	var created map[string]interface{}
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
@@ -295,7 +295,7 @@ func TestE2E_ThreadedComments_ResolveViaAPI(t *testing.T) {
 	assert.Contains(t, output, "Root comment")
 
 	// Resolve thread via API
-	resolveResp := env.patchJSON(t, fmt.Sprintf("/api/comments/%d/resolve", rootID), map[string]string{})
+	resolveResp := env.patchJSON(t, fmt.Sprintf("/api/v1/comments/%d/resolve", rootID), map[string]string{})
 	defer func() { _ = resolveResp.Body.Close() }()
 	assert.Equal(t, http.StatusOK, resolveResp.StatusCode)
 
@@ -320,7 +320,7 @@ func TestE2E_ThreadedComments_CannotEditCommentWithReplies(t *testing.T) {
 		"comment_text":      "Original comment",
 		"author":            "user",
 	}
-	resp := env.postJSON(t, "/api/comments", rootComment)
+	resp := env.postJSON(t, "/api/v1/comments", rootComment)
 	defer func() { _ = resp.Body.Close() }()
 	var created map[string]interface{}
 	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
@@ -330,7 +330,7 @@ func TestE2E_ThreadedComments_CannotEditCommentWithReplies(t *testing.T) {
 	updatePayload := map[string]string{
 		"comment_text": "Updated comment",
 	}
-	updateResp := env.patchJSON(t, fmt.Sprintf("/api/comments/%d", rootID), updatePayload)
+	updateResp := env.patchJSON(t, fmt.Sprintf("/api/v1/comments/%d", rootID), updatePayload)
 	_ = updateResp.Body.Close()
 	assert.Equal(t, http.StatusOK, updateResp.StatusCode)
 
@@ -342,7 +342,7 @@ func TestE2E_ThreadedComments_CannotEditCommentWithReplies(t *testing.T) {
 	updatePayload2 := map[string]string{
 		"comment_text": "Should not update",
 	}
-	updateResp2 := env.patchJSON(t, fmt.Sprintf("/api/comments/%d", rootID), updatePayload2)
+	updateResp2 := env.patchJSON(t, fmt.Sprintf("/api/v1/comments/%d", rootID), updatePayload2)
 	defer func() { _ = updateResp2.Body.Close() }()
 	assert.Equal(t, http.StatusBadRequest, updateResp2.StatusCode)
 
@@ -2,6 +2,7 @@ package main_test
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -22,7 +23,7 @@ func TestE2E_FileWatcher_NonExistentFile(t *testing.T) {
 
 	// Try to connect SSE to a non-existent file
 	// SSE connection should fail or handle gracefully
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=nonexistent.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=nonexistent.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 3 * time.Second}
@@ -67,7 +68,7 @@ func TestE2E_FileWatcher_NoReadPermission(t *testing.T) {
 	})
 
 	// Try to connect SSE to restricted file
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=restricted.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=restricted.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 3 * time.Second}
@@ -106,7 +107,7 @@ func TestE2E_FileWatcher_MultipleFiles(t *testing.T) {
 	files := []string{"watch1.md", "watch2.md", "watch3.md"}
 
 	for i, file := range files {
-		sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=%s",
+		sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=%s",
 			env.BaseURL, url.QueryEscape(env.ProjectDir), file)
 		resp, err := client.Get(sseURL)
 		require.NoError(t, err)
@@ -128,19 +129,19 @@ func TestE2E_FileWatcher_MultipleFiles(t *testing.T) {
 		scanner.Scan()
 	}
 
-	// Wait for file_updated event
+	// Wait for document_rendered event
 	eventReceived := false
 	deadline := time.Now().Add(3 * time.Second)
 
 	for time.Now().Before(deadline) && scanner.Scan() {
 		line := scanner.Text()
-		if strings.Contains(line, "event: file_updated") {
+		if strings.Contains(line, "event: document_rendered") {
 			eventReceived = true
 			break
 		}
 	}
 
-	assert.True(t, eventReceived, "Should receive file_updated for watch2.md")
+	assert.True(t, eventReceived, "Should receive document_rendered for watch2.md")
 
 	// Verify server is still responsive after watching multiple files
 	healthResp, err := http.Get(env.BaseURL + "/")
@@ -160,7 +161,7 @@ func TestE2E_FileWatcher_FileDeletion(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect SSE to the file
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=delete-me.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=delete-me.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -193,7 +194,7 @@ func TestE2E_FileWatcher_RapidChanges(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect SSE
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=rapid.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=rapid.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -217,13 +218,13 @@ func TestE2E_FileWatcher_RapidChanges(t *testing.T) {
 		}
 	}()
 
-	// Count file_updated events received
+	// Count document_rendered events received
 	eventCount := 0
 	deadline := time.Now().Add(5 * time.Second)
 
 	for time.Now().Before(deadline) && scanner.Scan() {
 		line := scanner.Text()
-		if strings.Contains(line, "event: file_updated") {
+		if strings.Contains(line, "event: document_rendered") {
 			eventCount++
 		}
 		// Stop after receiving some events
@@ -233,8 +234,8 @@ func TestE2E_FileWatcher_RapidChanges(t *testing.T) {
 	}
 
 	// Should receive at least some events (file watcher may coalesce rapid changes)
-	assert.Greater(t, eventCount, 0, "Should receive at least one file_updated event")
-	t.Logf("Received %d file_updated events from 10 rapid changes", eventCount)
+	assert.Greater(t, eventCount, 0, "Should receive at least one document_rendered event")
+	t.Logf("Received %d document_rendered events from 10 rapid changes", eventCount)
 
 	// Verify server is still responsive after rapid changes
 	healthResp, err := http.Get(env.BaseURL + "/")
@@ -267,7 +268,7 @@ func TestE2E_FileWatcher_Cleanup(t *testing.T) {
 	err = os.WriteFile(testFile, []byte("# Cleanup Test"), 0644)
 	require.NoError(t, err)
 
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=cleanup-test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=cleanup-test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 3 * time.Second}
@@ -305,7 +306,7 @@ func TestE2E_FileWatcher_SameFileMultipleClients(t *testing.T) {
 	require.NoError(t, err)
 
 	// Multiple clients watch the same file
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=test.md",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
 		env.BaseURL, url.QueryEscape(env.ProjectDir))
 
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -344,7 +345,7 @@ func TestE2E_FileWatcher_SameFileMultipleClients(t *testing.T) {
 		go func(idx int) {
 			for time.Now().Before(deadline) && scanners[idx].Scan() {
 				line := scanners[idx].Text()
-				if strings.Contains(line, "event: file_updated") {
+				if strings.Contains(line, "event: document_rendered") {
 					received[idx] = true
 					done <- idx
 					return
@@ -361,7 +362,7 @@ func TestE2E_FileWatcher_SameFileMultipleClients(t *testing.T) {
 
 	// All clients should have received the event
 	for i := 0; i < 3; i++ {
-		assert.True(t, received[i], "Client %d should receive file_updated event", i)
+		assert.True(t, received[i], "Client %d should receive document_rendered event", i)
 	}
 }
 
@@ -379,7 +380,7 @@ func TestE2E_FileWatcher_DirectoryDeletion(t *testing.T) {
 	require.NoError(t, err)
 
 	// Connect SSE to the nested file
-	sseURL := fmt.Sprintf("%s/api/events?project_directory=%s&file_path=%s",
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=%s",
 		env.BaseURL, url.QueryEscape(env.ProjectDir), url.QueryEscape("subdir/nested.md"))
 
 	client := &http.Client{Timeout: 5 * time.Second}
@@ -400,3 +401,116 @@ func TestE2E_FileWatcher_DirectoryDeletion(t *testing.T) {
 	_ = healthResp.Body.Close()
 	assert.Equal(t, http.StatusOK, healthResp.StatusCode)
 }
+
+// subscribeToFile opens an SSE stream for test.md and waits until it is connected
+func subscribeToFile(t *testing.T, env *TestEnv) *bufio.Scanner {
+	t.Helper()
+
+	sseURL := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=test.md",
+		env.BaseURL, url.QueryEscape(env.ProjectDir))
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(sseURL)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	scanner := bufio.NewScanner(resp.Body)
+	// Long enough for a whole re-rendered document on one data line
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	_, ok := readSSEEvent(t, scanner, "connected", 3*time.Second)
+	require.True(t, ok)
+	return scanner
+}
+
+// expectRendered waits for a document_rendered event whose HTML contains want,
+// skipping renders of intermediate states (e.g. a recreated file still empty)
+func expectRendered(t *testing.T, scanner *bufio.Scanner, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, ok := readSSEEvent(t, scanner, "document_rendered", time.Until(deadline))
+		require.True(t, ok, "Should receive document_rendered containing %q", want)
+		if strings.Contains(data, want) {
+			return
+		}
+	}
+	t.Fatalf("No document_rendered containing %q", want)
+}
+
+func TestE2E_FileWatcher_AtomicRenameSave(t *testing.T) {
+	env := setupE2E(t)
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	scanner := subscribeToFile(t, env)
+	mdPath := filepath.Join(env.ProjectDir, "test.md")
+
+	// Save the way editors and agents do: write a temp file, rename it over the
+	// original. Several saves in a row prove the watch survives the first one.
+	for i := 1; i <= 3; i++ {
+		content := fmt.Sprintf("# Test Document\n\nAtomic save %d\n", i)
+		tmpPath := filepath.Join(env.ProjectDir, fmt.Sprintf(".test.md.tmp%d", i))
+		require.NoError(t, os.WriteFile(tmpPath, []byte(content), 0644))
+		require.NoError(t, os.Rename(tmpPath, mdPath))
+
+		expectRendered(t, scanner, fmt.Sprintf("Atomic save %d", i))
+	}
+}
+
+func TestE2E_FileWatcher_DeleteAndRecreate(t *testing.T) {
+	env := setupE2E(t)
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	scanner := subscribeToFile(t, env)
+	mdPath := filepath.Join(env.ProjectDir, "test.md")
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, os.Remove(mdPath))
+		// Give the watcher a chance to see the file gone before it returns
+		time.Sleep(50 * time.Millisecond)
+		content := fmt.Sprintf("# Test Document\n\nRecreated %d\n", i)
+		require.NoError(t, os.WriteFile(mdPath, []byte(content), 0644))
+
+		expectRendered(t, scanner, fmt.Sprintf("Recreated %d", i))
+	}
+
+	// An in-place write still works afterwards
+	require.NoError(t, os.WriteFile(mdPath, []byte("# Test Document\n\nIn place\n"), 0644))
+	expectRendered(t, scanner, "In place")
+}
+
+func TestE2E_FileWatcher_Polling(t *testing.T) {
+	// Filesystems without notifications (network mounts, some containers)
+	t.Setenv("CR_WATCHER", "poll")
+	t.Setenv("CR_POLL_INTERVAL", "100ms")
+
+	env := setupE2E(t)
+	_, err := env.runCLI(t, "register", "--project", env.ProjectDir)
+	require.NoError(t, err)
+
+	resp, err := http.Get(env.BaseURL + "/api/v1/status")
+	require.NoError(t, err)
+	var status struct {
+		Watcher struct {
+			Backend      string `json:"backend"`
+			PollInterval string `json:"poll_interval"`
+		} `json:"watcher"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	_ = resp.Body.Close()
+	assert.Equal(t, "poll", status.Watcher.Backend)
+	assert.Equal(t, "100ms", status.Watcher.PollInterval)
+
+	scanner := subscribeToFile(t, env)
+	mdPath := filepath.Join(env.ProjectDir, "test.md")
+
+	require.NoError(t, os.WriteFile(mdPath, []byte("# Test Document\n\nPolled write\n"), 0644))
+	expectRendered(t, scanner, "Polled write")
+
+	tmpPath := filepath.Join(env.ProjectDir, ".test.md.tmp")
+	require.NoError(t, os.WriteFile(tmpPath, []byte("# Test Document\n\nPolled rename\n"), 0644))
+	require.NoError(t, os.Rename(tmpPath, mdPath))
+	expectRendered(t, scanner, "Polled rename")
+}
@@ -12,3 +12,6 @@ var staticFS embed.FS
 
 //go:embed slash-commands/*.md
 var slashCommandsFS embed.FS
+
+//go:embed api/openapi.json
+var openAPISpec []byte
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// SSE event types. Every event carries a JSON payload with everything a viewer
+// needs to patch itself in place, so no event requires a page reload.
+const (
+	eventCommentCreated   = "comment_created"
+	eventReplyAdded       = "reply_added"
+	eventCommentUpdated   = "comment_updated"
+	eventThreadResolved   = "thread_resolved"
+	eventCommentDeleted   = "comment_deleted"
+	eventDocumentRendered = "document_rendered"
+
+	// Project events, delivered to project and global subscribers
+	eventFilesChanged      = "files_changed"
+	eventCommentsChanged   = "comments_changed"
+	eventProjectRegistered = "project_registered"
+
+	// eventServerStopping is sent to every subscriber when the daemon shuts down
+	eventServerStopping = "server_stopping"
+)
+
+// commentEvents change a file's comment counts. The SSE hub follows each of
+// them with a comments_changed event for the project.
+var commentEvents = map[string]bool{
+	eventCommentCreated: true,
+	eventReplyAdded:     true,
+	eventCommentUpdated: true,
+	eventThreadResolved: true,
+	eventCommentDeleted: true,
+}
+
+// CommentEvent is the payload of comment_created, reply_added and comment_updated.
+// The comment includes its rendered_html.
+type CommentEvent struct {
+	Comment Comment `json:"comment"`
+}
+
+// ThreadResolvedEvent is the payload of thread_resolved
+type ThreadResolvedEvent struct {
+	RootID     int    `json:"root_id"`
+	ResolvedBy string `json:"resolved_by"`
+	Count      int    `json:"count"`
+}
+
+// CommentDeletedEvent is the payload of comment_deleted
+type CommentDeletedEvent struct {
+	ID     int  `json:"id"`
+	RootID *int `json:"root_id,omitempty"`
+}
+
+// DocumentRenderedEvent is the payload of document_rendered, sent when the
+// Markdown file changes on disk. HTML is the freshly rendered document.
+type DocumentRenderedEvent struct {
+	FilePath string `json:"file_path"`
+	HTML     string `json:"html"`
+}
+
+// FilesChangedEvent is the payload of files_changed, sent when a Markdown file
+// or a directory is added to or removed from a project. Path is relative to the
+// project directory.
+type FilesChangedEvent struct {
+	ProjectDirectory string `json:"project_directory"`
+	Path             string `json:"path"`
+}
+
+// CommentsChangedEvent is the payload of comments_changed
+type CommentsChangedEvent struct {
+	ProjectDirectory string `json:"project_directory"`
+	FilePath         string `json:"file_path"`
+}
+
+// ProjectRegisteredEvent is the payload of project_registered
+type ProjectRegisteredEvent struct {
+	ProjectDirectory string `json:"project_directory"`
+}
+
+// eventPublisher delivers an event to the subscribers of a file, or of a
+// project if filePath is empty. The daemon broadcasts through its SSE hub; CLI
+// commands go through the daemon's /events endpoint (see notify.go).
+type eventPublisher interface {
+	broadcast(projectDir, filePath, event string, data interface{})
+}
+
+// renderCommentHTML fills in RenderedHTML from the comment's markdown
+func renderCommentHTML(comment *Comment) error {
+	rendered, err := RenderMarkdown([]byte(comment.CommentText), configFor(comment.ProjectDirectory).Theme)
+	if err != nil {
+		return fmt.Errorf("failed to render comment markdown: %w", err)
+	}
+	// Trim whitespace to avoid issues in inline JavaScript
+	comment.RenderedHTML = strings.TrimSpace(string(rendered))
+	return nil
+}
+
+// publishCommentCreated announces a new root comment or reply
+func publishCommentCreated(p eventPublisher, comment Comment) {
+	event := eventCommentCreated
+	if comment.RootID != nil {
+		event = eventReplyAdded
+	}
+	publishComment(p, event, comment)
+}
+
+// publishComment sends a comment event, rendering the comment if needed
+func publishComment(p eventPublisher, event string, comment Comment) {
+	if comment.RenderedHTML == "" {
+		if err := renderCommentHTML(&comment); err != nil {
+			slog.Error("Failed to render comment", "event", event, "error", err)
+			return
+		}
+	}
+	p.broadcast(comment.ProjectDirectory, comment.FilePath, event, CommentEvent{Comment: comment})
+}
+
+// publishThreadsResolved announces resolved threads. comments are the comments
+// that were unresolved before resolution; one event is sent per thread.
+func publishThreadsResolved(p eventPublisher, comments []Comment, resolvedBy string) {
+	counts := make(map[int]int)
+	var firsts []Comment
+	for _, c := range comments {
+		rootID := threadRootID(c)
+		if counts[rootID] == 0 {
+			firsts = append(firsts, c)
+		}
+		counts[rootID]++
+	}
+
+	for _, c := range firsts {
+		rootID := threadRootID(c)
+		p.broadcast(c.ProjectDirectory, c.FilePath, eventThreadResolved, ThreadResolvedEvent{
+			RootID:     rootID,
+			ResolvedBy: resolvedBy,
+			Count:      counts[rootID],
+		})
+	}
+}
+
+// publishDocumentRendered renders a project file and sends it to its viewers
+func publishDocumentRendered(p eventPublisher, projectDir, filePath string) {
+	absPath, err := resolveProjectFile(projectDir, filePath)
+	if err != nil {
+		slog.Warn("Not rendering file", "file", filePath, "error", err)
+		return
+	}
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		slog.Error("Failed to read file", "file", absPath, "error", err)
+		return
+	}
+
+	cfg := configFor(projectDir)
+	html, err := RenderMarkdownWithLineNumbers(content, cfg.Theme, cfg.documentSource(projectDir))
+	if err != nil {
+		slog.Error("Failed to render file", "file", absPath, "error", err)
+		return
+	}
+
+	p.broadcast(projectDir, filePath, eventDocumentRendered, DocumentRenderedEvent{
+		FilePath: filePath,
+		HTML:     string(html),
+	})
+}
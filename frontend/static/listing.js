@@ -0,0 +1,71 @@
+// Claude Review - Live updates for the home page and directory listings
+
+(function () {
+    'use strict';
+
+    // Delay before refreshing, so a burst of events (e.g. a git checkout) causes one fetch
+    const REFRESH_DELAY_MS = 200;
+
+    const { liveScope, projectDirectory } = document.body.dataset;
+    let refreshTimer = null;
+
+    /**
+     * Fetch a fresh copy of this page and swap in its listing. The server renders
+     * the entries and unresolved counts, so the page never has to reload.
+     */
+    async function refreshListing() {
+        try {
+            const response = await fetch(window.location.href, { headers: { Accept: 'text/html' } });
+            if (!response.ok) {
+                // The directory itself may be gone; keep what is shown
+                return;
+            }
+
+            const html = await response.text();
+            const fresh = new DOMParser().parseFromString(html, 'text/html').getElementById('listing');
+            const current = document.getElementById('listing');
+            if (fresh && current) {
+                current.replaceWith(fresh);
+            }
+        } catch (error) {
+            console.error('Failed to refresh listing:', error);
+        }
+    }
+
+    function scheduleRefresh() {
+        clearTimeout(refreshTimer);
+        refreshTimer = setTimeout(refreshListing, REFRESH_DELAY_MS);
+    }
+
+    /**
+     * Setup Server-Sent Events for the project (directory pages) or for all
+     * projects (home page)
+     */
+    function setupSSE() {
+        const params = new URLSearchParams({ scope: liveScope });
+        if (projectDirectory) {
+            params.set('project_directory', projectDirectory);
+        }
+
+        const eventSource = new EventSource(`/api/v1/events?${params}`);
+
+        // A reload event means events were missed; refreshing the listing catches up
+        ['files_changed', 'comments_changed', 'project_registered', 'reload'].forEach((name) => {
+            eventSource.addEventListener(name, scheduleRefresh);
+        });
+
+        eventSource.onerror = (error) => {
+            console.error('SSE error:', error);
+
+            // The browser reconnects by itself and replays missed events. Only
+            // start over once it has given up.
+            if (eventSource.readyState === EventSource.CLOSED) {
+                setTimeout(setupSSE, 5000);
+            }
+        };
+    }
+
+    if (liveScope) {
+        setupSSE();
+    }
+})();
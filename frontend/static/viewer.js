@@ -8,6 +8,15 @@
     let commentPopup = null;
     let commentPanel = null;
 
+    // Per-install API token, required by the server on every mutating request
+    const apiToken = document.querySelector('meta[name="claude-review-token"]')?.content || '';
+
+    // Names shown for comment authors, as configured by the user
+    const authorNames = {
+        user: document.querySelector('meta[name="claude-review-author-user"]')?.content,
+        agent: document.querySelector('meta[name="claude-review-author-agent"]')?.content,
+    };
+
     // Initialize when DOM is ready
     if (document.readyState === 'loading') {
         document.addEventListener('DOMContentLoaded', init);
@@ -24,6 +33,12 @@
         setupSSE();
     }
 
+    // fetch() wrapper that attaches the API token header
+    function apiFetch(url, options = {}) {
+        const headers = { ...(options.headers || {}), 'X-Claude-Review-Token': apiToken };
+        return fetch(url, { ...options, headers });
+    }
+
     function initTextSelection() {
         const container = document.getElementById('markdown-content');
         if (!container) {
@@ -240,7 +255,7 @@
         authorInfoDiv.className = 'comment-author-info';
 
         const authorSpan = document.createElement('span');
-        authorSpan.textContent = capitalizeFirst(comment.author);
+        authorSpan.textContent = authorNames[comment.author] || capitalizeFirst(comment.author);
         authorInfoDiv.appendChild(authorSpan);
 
         if (comment.created_at) {
@@ -469,7 +484,7 @@
         textarea.focus({ preventScroll: true });
     }
 
-    function showEditCommentPopup(comment, highlightElement, x, y) {
+    function showEditCommentPopup(comment, x, y) {
         // Setup for editing existing comment
         const saveBtn = document.getElementById('comment-save');
         const deleteBtn = document.getElementById('comment-delete');
@@ -484,12 +499,8 @@
         cancelBtn.replaceWith(cancelBtn.cloneNode(true));
 
         // Add new listeners
-        document
-            .getElementById('comment-save')
-            .addEventListener('click', () => handleUpdateComment(comment, highlightElement));
-        document
-            .getElementById('comment-delete')
-            .addEventListener('click', () => handleDeleteComment(comment, highlightElement));
+        document.getElementById('comment-save').addEventListener('click', () => handleUpdateComment(comment));
+        document.getElementById('comment-delete').addEventListener('click', () => handleDeleteComment(comment));
         document.getElementById('comment-cancel').addEventListener('click', hideCommentPopup);
 
         commentPopup.style.display = 'block';
@@ -556,7 +567,7 @@
         };
 
         try {
-            const response = await fetch('/api/comments', {
+            const response = await apiFetch('/api/v1/comments', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -569,15 +580,7 @@
             }
 
             const savedReply = await response.json();
-
-            // Add reply to comments array
-            if (typeof comments === 'undefined' || comments === null) {
-                comments = [];
-            }
-            comments.push(savedReply);
-
-            // Update comment panel to show new reply
-            updateCommentPanel();
+            applyCommentCreated(savedReply);
 
             // Hide popup
             hideCommentPopup();
@@ -593,7 +596,7 @@
         }
 
         try {
-            const response = await fetch(`/api/comments/${rootComment.id}/resolve`, {
+            const response = await apiFetch(`/api/v1/comments/${rootComment.id}/resolve`, {
                 method: 'PATCH',
                 headers: {
                     'Content-Type': 'application/json',
@@ -605,29 +608,7 @@
                 throw new Error(`HTTP error! status: ${response.status}`);
             }
 
-            // Remove the thread from the comments array (root + all replies)
-            if (typeof comments !== 'undefined' && comments !== null) {
-                // Remove root comment and all its replies by filtering in reverse
-                for (let i = comments.length - 1; i >= 0; i--) {
-                    const c = comments[i];
-                    if (c.id === rootComment.id || c.root_id === rootComment.id) {
-                        comments.splice(i, 1);
-                    }
-                }
-            }
-
-            // Remove highlight from document
-            const highlight = document.querySelector(`.comment-highlight[data-comment-id="${rootComment.id}"]`);
-            if (highlight) {
-                const parent = highlight.parentNode;
-                while (highlight.firstChild) {
-                    parent.insertBefore(highlight.firstChild, highlight);
-                }
-                parent.removeChild(highlight);
-            }
-
-            // Update comment panel
-            updateCommentPanel();
+            applyThreadResolved(rootComment.id);
         } catch (error) {
             console.error('Failed to resolve thread:', error);
             alert('Failed to resolve thread. Please try again.');
@@ -689,7 +670,7 @@
         };
 
         try {
-            const response = await fetch('/api/comments', {
+            const response = await apiFetch('/api/v1/comments', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -702,18 +683,7 @@
             }
 
             const savedComment = await response.json();
-
-            // Add comment to comments array
-            if (typeof comments === 'undefined' || comments === null) {
-                comments = [];
-            }
-            comments.push(savedComment);
-
-            // Find and highlight the text in the document
-            highlightCommentByText(savedComment);
-
-            // Update comment panel
-            updateCommentPanel();
+            applyCommentCreated(savedComment);
 
             // Hide popup and clear selection
             hideCommentPopup(true);
@@ -726,7 +696,7 @@
     /**
      * Handle updating an existing comment
      */
-    async function handleUpdateComment(comment, highlightElement) {
+    async function handleUpdateComment(comment) {
         const commentText = document.getElementById('comment-text').value.trim();
         if (!commentText) {
             alert('Please enter a comment');
@@ -734,7 +704,7 @@
         }
 
         try {
-            const response = await fetch(`/api/comments/${comment.id}`, {
+            const response = await apiFetch(`/api/v1/comments/${comment.id}`, {
                 method: 'PATCH',
                 headers: {
                     'Content-Type': 'application/json',
@@ -749,21 +719,7 @@
             }
 
             const updatedComment = await response.json();
-
-            // Update the comment in the comments array with all returned data
-            if (typeof comments !== 'undefined' && comments !== null) {
-                const index = comments.findIndex((c) => c.id === comment.id);
-                if (index !== -1) {
-                    comments[index] = updatedComment;
-                }
-            }
-
-            // Update the highlight element
-            highlightElement.dataset.commentText = updatedComment.comment_text;
-            highlightElement.title = updatedComment.comment_text;
-
-            // Update comment panel
-            updateCommentPanel();
+            applyCommentUpdated(updatedComment);
 
             // Hide popup
             hideCommentPopup();
@@ -776,13 +732,13 @@
     /**
      * Handle deleting a comment
      */
-    async function handleDeleteComment(comment, highlightElement) {
+    async function handleDeleteComment(comment) {
         if (!confirm('Are you sure you want to delete this comment?')) {
             return;
         }
 
         try {
-            const response = await fetch(`/api/comments/${comment.id}`, {
+            const response = await apiFetch(`/api/v1/comments/${comment.id}`, {
                 method: 'DELETE',
             });
 
@@ -790,23 +746,7 @@
                 throw new Error(`HTTP error! status: ${response.status}`);
             }
 
-            // Remove the highlight from the DOM
-            const parent = highlightElement.parentNode;
-            while (highlightElement.firstChild) {
-                parent.insertBefore(highlightElement.firstChild, highlightElement);
-            }
-            parent.removeChild(highlightElement);
-
-            // Remove comment from comments array
-            if (typeof comments !== 'undefined' && comments !== null) {
-                const index = comments.findIndex((c) => c.id === comment.id);
-                if (index !== -1) {
-                    comments.splice(index, 1);
-                }
-            }
-
-            // Update comment panel
-            updateCommentPanel();
+            applyCommentDeleted(comment.id);
 
             // Hide popup
             hideCommentPopup();
@@ -841,20 +781,20 @@
         highlight.title = comment.comment_text;
 
         // Check if this comment has replies and add class accordingly
-        const hasReply = commentHasReplies(comment.id);
-        if (hasReply) {
+        if (commentHasReplies(comment.id)) {
             highlight.classList.add('has-replies');
         }
 
         // Click handler to edit comment (only if it has no replies)
         highlight.addEventListener('click', (e) => {
             e.stopPropagation();
-            // Only allow editing root comments without replies
-            if (!comment.root_id && !hasReply) {
+            // Only allow editing root comments without replies. Replies may arrive
+            // live, so check at click time.
+            if (!comment.root_id && !commentHasReplies(comment.id)) {
                 // Convert page coordinates to viewport coordinates for position: fixed popup
                 const x = e.clientX;
                 const y = e.clientY;
-                showEditCommentPopup(comment, highlight, x, y);
+                showEditCommentPopup(comment, x, y);
             }
         });
 
@@ -1000,12 +940,150 @@
     }
 
     /**
-     * Trigger a page reload
+     * Find a comment in the local comments array by ID
+     */
+    function findComment(id) {
+        if (typeof comments === 'undefined' || comments === null) {
+            return null;
+        }
+        return comments.find((c) => c.id === id) || null;
+    }
+
+    function findHighlight(commentId) {
+        return document.querySelector(`.comment-highlight[data-comment-id="${commentId}"]`);
+    }
+
+    /**
+     * Remove a comment highlight from the document, keeping its text
+     */
+    function unwrapHighlight(highlight) {
+        const parent = highlight.parentNode;
+        while (highlight.firstChild) {
+            parent.insertBefore(highlight.firstChild, highlight);
+        }
+        parent.removeChild(highlight);
+    }
+
+    /**
+     * Add a new comment or reply to the page.
+     *
+     * The apply* functions below are shared by local actions and SSE events. They are
+     * idempotent because the tab that made a change sees it twice: in the API response
+     * and as an event.
      */
-    function triggerReload() {
-        window.location.reload();
+    function applyCommentCreated(comment) {
+        if (typeof comments === 'undefined' || comments === null) {
+            comments = [];
+        }
+        if (findComment(comment.id)) {
+            return;
+        }
+        comments.push(comment);
+
+        if (comment.root_id) {
+            const rootHighlight = findHighlight(comment.root_id);
+            if (rootHighlight) {
+                rootHighlight.classList.add('has-replies');
+            }
+        } else {
+            highlightCommentByText(comment);
+        }
+
+        updateCommentPanel();
     }
 
+    /**
+     * Update an edited comment in place. The existing object is updated so that
+     * handlers holding a reference to it see the new text.
+     */
+    function applyCommentUpdated(comment) {
+        const existing = findComment(comment.id);
+        if (!existing) {
+            return;
+        }
+        Object.assign(existing, comment);
+
+        const highlight = findHighlight(comment.id);
+        if (highlight) {
+            highlight.dataset.commentText = comment.comment_text;
+            highlight.title = comment.comment_text;
+        }
+
+        updateCommentPanel();
+    }
+
+    /**
+     * Remove a deleted comment and its highlight
+     */
+    function applyCommentDeleted(commentId) {
+        const existing = findComment(commentId);
+        if (!existing) {
+            return;
+        }
+        comments.splice(comments.indexOf(existing), 1);
+        // Deleting a root comment deletes its replies with it
+        if (!existing.root_id) {
+            comments.filter(c => c.root_id === commentId)
+                .forEach(reply => comments.splice(comments.indexOf(reply), 1));
+        }
+
+        const highlight = findHighlight(commentId);
+        if (highlight) {
+            unwrapHighlight(highlight);
+        }
+        if (existing.root_id && !commentHasReplies(existing.root_id)) {
+            const rootHighlight = findHighlight(existing.root_id);
+            if (rootHighlight) {
+                rootHighlight.classList.remove('has-replies');
+            }
+        }
+
+        updateCommentPanel();
+    }
+
+    /**
+     * Remove a resolved thread (root comment and all replies) and its highlight
+     */
+    function applyThreadResolved(rootId) {
+        if (typeof comments !== 'undefined' && comments !== null) {
+            // Remove root comment and all its replies by filtering in reverse
+            for (let i = comments.length - 1; i >= 0; i--) {
+                const c = comments[i];
+                if (c.id === rootId || c.root_id === rootId) {
+                    comments.splice(i, 1);
+                }
+            }
+        }
+
+        const highlight = findHighlight(rootId);
+        if (highlight) {
+            unwrapHighlight(highlight);
+        }
+
+        updateCommentPanel();
+    }
+
+    /**
+     * Swap in a freshly rendered document, keeping scroll position, open popups
+     * and drafts, then re-apply the comment highlights
+     */
+    function applyDocumentRendered(html) {
+        const content = document.getElementById('markdown-content');
+        const { scrollX, scrollY } = window;
+
+        content.innerHTML = html;
+        hideCommentButton();
+
+        if (typeof comments !== 'undefined' && comments !== null) {
+            comments.filter((c) => !c.root_id).forEach(highlightCommentByText);
+        }
+
+        window.scrollTo(scrollX, scrollY);
+    }
+
+    // ID of the last SSE event applied, used to resume after a reconnect
+    let lastEventId = '';
+
     /**
      * Setup Server-Sent Events for live updates
      */
@@ -1014,31 +1092,54 @@
             project_directory: projectDir,
             file_path: filePath,
         });
+        if (lastEventId) {
+            params.set('last_event_id', lastEventId);
+        }
 
-        const eventSource = new EventSource(`/api/events?${params}`);
+        const eventSource = new EventSource(`/api/v1/events?${params}`);
 
-        eventSource.addEventListener('file_updated', (event) => {
-            console.log('File updated event received:', event.data);
-            triggerReload();
-        });
+        const handlers = {
+            comment_created: (data) => applyCommentCreated(data.comment),
+            reply_added: (data) => applyCommentCreated(data.comment),
+            comment_updated: (data) => applyCommentUpdated(data.comment),
+            comment_deleted: (data) => applyCommentDeleted(data.id),
+            thread_resolved: (data) => applyThreadResolved(data.root_id),
+            document_rendered: (data) => applyDocumentRendered(data.html),
+        };
 
-        eventSource.addEventListener('comments_resolved', (event) => {
-            console.log('Comments resolved event received:', event.data);
-            const data = JSON.parse(event.data);
-            triggerReload();
+        Object.entries(handlers).forEach(([name, handler]) => {
+            eventSource.addEventListener(name, (event) => {
+                if (event.lastEventId) {
+                    lastEventId = event.lastEventId;
+                }
+                try {
+                    handler(JSON.parse(event.data));
+                } catch (error) {
+                    console.error(`Failed to apply ${name} event:`, error);
+                }
+            });
         });
 
+        // Explicit request for a full reload
         eventSource.addEventListener('reload', (event) => {
             console.log('Reload event received:', event.data);
-            triggerReload();
+            window.location.reload();
+        });
+
+        // The stream ends next. The browser keeps reconnecting, and the next
+        // daemon answers with a reload since it cannot replay our events.
+        eventSource.addEventListener('server_stopping', () => {
+            console.log('Server is stopping');
         });
 
         eventSource.onerror = (error) => {
             console.error('SSE error:', error);
-            eventSource.close();
 
-            // Attempt to reconnect after 5 seconds
-            setTimeout(setupSSE, 5000);
+            // The browser reconnects by itself, sending Last-Event-ID so missed
+            // events are replayed. Only start over once it has given up.
+            if (eventSource.readyState === EventSource.CLOSED) {
+                setTimeout(setupSSE, 5000);
+            }
         };
     }
 })();
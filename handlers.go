@@ -2,20 +2,92 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 var templates *template.Template
 
+// Server holds the dependencies shared by the HTTP handlers
+type Server struct {
+	store    Store
+	apiToken string
+	rawFiles rawFilePolicy
+}
+
+func newServer(store Store, apiToken string) *Server {
+	return &Server{
+		store:    store,
+		apiToken: apiToken,
+		rawFiles: newRawFilePolicy(os.Getenv("CR_RAW_FILE_TYPES")),
+	}
+}
+
+// storeFor returns the store logging its queries with the request's ID
+func (s *Server) storeFor(r *http.Request) Store {
+	return storeWithLogger(s.store, requestLogger(r))
+}
+
+// routes builds the HTTP router for the daemon
+func (s *Server) routes() (http.Handler, error) {
+	r := chi.NewRouter()
+	r.Use(logRequests)
+	r.Use(middleware.Recoverer)
+	r.Use(requireLocalRequest)
+	r.Use(securityHeaders)
+	r.Use(s.requireAPIToken)
+	r.Use(trackActivity)
+
+	// HTML Routes
+	r.Get("/", s.handleHome)
+	r.Get("/projects/*", s.handleProjectFiles)
+
+	// Unversioned, so every build can identify every other
+	r.Get("/api/version", handleVersion)
+
+	// Monitoring
+	r.Get("/healthz", handleHealthz)
+	r.Get("/metrics", handleMetrics)
+
+	// JSON API Routes (documented in api/openapi.json)
+	r.Route(apiPrefix, func(r chi.Router) {
+		r.NotFound(handleAPINotFound)
+		r.MethodNotAllowed(handleAPIMethodNotAllowed)
+
+		r.Get("/openapi.json", handleOpenAPISpec)
+		r.Get("/comments", s.handleListComments)
+		r.Post("/comments", s.handleCreateComment)
+		r.Patch("/comments/{id}", s.handleUpdateComment)
+		r.Delete("/comments/{id}", s.handleDeleteComment)
+		r.Patch("/comments/{id}/resolve", s.handleResolveThread)
+		r.Get("/threads/{id}", s.handleGetThread)
+		r.Get("/projects/*", s.handleProjectSummary)
+		r.Get("/events", s.handleSSE)
+		r.Post("/events", handleBroadcast)
+		r.Get("/status", handleStatus)
+	})
+
+	// Static files from embedded FS
+	staticSubFS, err := fs.Sub(staticFS, "frontend/static")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create static sub-filesystem: %w", err)
+	}
+	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.FS(staticSubFS))))
+
+	return r, nil
+}
+
 // escapePathComponents escapes each component of a path individually,
 // preserving the forward slashes between components.
 func escapePathComponents(path string) string {
@@ -52,15 +124,31 @@ func initTemplates() error {
 
 // HTML Route Handlers
 
-func handleHome(w http.ResponseWriter, r *http.Request) {
-	projects, err := getAllProjects()
+func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.storeFor(r).GetAllProjects()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	type ProjectEntry struct {
+		Project
+		UnresolvedThreads int
+	}
+
+	entries := make([]ProjectEntry, 0, len(projects))
+	for _, p := range projects {
+		files, err := s.storeFor(r).GetFileSummaries(p.Directory)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, ProjectEntry{Project: p, UnresolvedThreads: unresolvedThreadsUnder(files, "", true)})
+	}
+
 	data := map[string]interface{}{
-		"Projects": projects,
+		"Title":    configFor("").Title,
+		"Projects": entries,
 	}
 
 	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
@@ -68,7 +156,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleProjectFiles(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleProjectFiles(w http.ResponseWriter, r *http.Request) {
 	// Get everything after /projects/
 	fullPath := strings.TrimPrefix(r.URL.Path, "/projects/")
 
@@ -85,7 +173,7 @@ func handleProjectFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find the first registered project that matches the beginning of the path
-	projects, err := getAllProjects()
+	projects, err := s.storeFor(r).GetAllProjects()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -108,8 +196,19 @@ func handleProjectFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build absolute path
-	absPath := filepath.Join(project, childPath)
+	// Resolve symlinks and refuse anything that leaves the project root
+	absPath, err := resolveProjectPath(project, childPath)
+	switch {
+	case errors.Is(err, errPathOutsideProject):
+		http.Error(w, "Path outside project", http.StatusForbidden)
+		return
+	case err != nil:
+		http.NotFound(w, r)
+		return
+	}
+	if childPath = path.Clean(childPath); childPath == "." {
+		childPath = ""
+	}
 
 	// Check if path exists
 	info, err := os.Stat(absPath)
@@ -125,24 +224,23 @@ func handleProjectFiles(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
 			return
 		}
-		renderDirectoryListing(w, r, project, childPath)
+		s.renderDirectoryListing(w, r, project, childPath)
 		return
 	}
 
 	// If markdown file, render viewer
-	if strings.HasSuffix(strings.ToLower(info.Name()), ".md") {
-		renderViewer(w, r, project, childPath)
+	if configFor(project).isDocument(info.Name()) {
+		s.renderViewer(w, r, project, childPath)
 		return
 	}
 
-	// Otherwise serve raw file
-	prefix := "/projects/" + url.PathEscape(project)
-	fs := http.FileServer(http.Dir(project))
-	http.StripPrefix(prefix, fs).ServeHTTP(w, r)
+	// Otherwise serve raw file, subject to the raw file policy
+	s.serveRawFile(w, r, childPath, absPath)
 }
 
-func renderViewer(w http.ResponseWriter, r *http.Request, projectDir, filePath string) {
+func (s *Server) renderViewer(w http.ResponseWriter, r *http.Request, projectDir, filePath string) {
 	absPath := filepath.Join(projectDir, filePath)
+	cfg := configFor(projectDir)
 
 	// Read markdown file
 	content, err := os.ReadFile(absPath)
@@ -152,14 +250,14 @@ func renderViewer(w http.ResponseWriter, r *http.Request, projectDir, filePath s
 	}
 
 	// Render markdown to HTML
-	html, err := RenderMarkdownWithLineNumbers(content)
+	html, err := RenderMarkdownWithLineNumbers(content, cfg.Theme, cfg.documentSource(projectDir))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Get comments for this file
-	comments, err := getComments(projectDir, filePath, false)
+	comments, err := s.storeFor(r).GetComments(projectDir, filePath, false)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -172,10 +270,15 @@ func renderViewer(w http.ResponseWriter, r *http.Request, projectDir, filePath s
 	}
 
 	data := map[string]interface{}{
+		"Title":       cfg.Title,
+		"UserName":    cfg.UserName,
+		"AgentName":   cfg.AgentName,
 		"ProjectDir":  projectDir,
 		"FilePath":    filePath,
 		"HTMLContent": template.HTML(html),
 		"Comments":    comments,
+		"APIToken":    s.apiToken,
+		"CSPNonce":    cspNonce(r.Context()),
 	}
 
 	if err := templates.ExecuteTemplate(w, "viewer.html", data); err != nil {
@@ -183,33 +286,7 @@ func renderViewer(w http.ResponseWriter, r *http.Request, projectDir, filePath s
 	}
 }
 
-var skipDirs = map[string]bool{
-	".git":          true,
-	"node_modules":  true,
-	"vendor":        true,
-	".next":         true,
-	".nuxt":         true,
-	"dist":          true,
-	"build":         true,
-	"target":        true,
-	".venv":         true,
-	"venv":          true,
-	"__pycache__":   true,
-	".pytest_cache": true,
-	".idea":         true,
-	".vscode":       true,
-	".DS_Store":     true,
-	".direnv":       true,
-	".mypy_cache":   true,
-	".vim":          true,
-	".ruff_cache":   true,
-}
-
-func shouldSkipDir(name string) bool {
-	return skipDirs[name]
-}
-
-func hasMarkdownFiles(dirPath string) bool {
+func hasMarkdownFiles(cfg *Config, dirPath string) bool {
 	// Use filepath.WalkDir for efficient traversal
 	found := false
 	_ = filepath.WalkDir(dirPath, func(path string, d os.DirEntry, err error) error {
@@ -217,10 +294,10 @@ func hasMarkdownFiles(dirPath string) bool {
 			return filepath.SkipDir
 		}
 		// Skip common directories
-		if d.IsDir() && shouldSkipDir(d.Name()) {
+		if d.IsDir() && cfg.skipDir(d.Name()) {
 			return filepath.SkipDir
 		}
-		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".md") {
+		if !d.IsDir() && cfg.isDocument(d.Name()) {
 			found = true
 			return filepath.SkipAll // Stop walking once we find one
 		}
@@ -229,8 +306,9 @@ func hasMarkdownFiles(dirPath string) bool {
 	return found
 }
 
-func renderDirectoryListing(w http.ResponseWriter, r *http.Request, projectDir, childPath string) {
+func (s *Server) renderDirectoryListing(w http.ResponseWriter, r *http.Request, projectDir, childPath string) {
 	absPath := filepath.Join(projectDir, childPath)
+	cfg := configFor(projectDir)
 
 	// Read directory contents
 	entries, err := os.ReadDir(absPath)
@@ -239,40 +317,52 @@ func renderDirectoryListing(w http.ResponseWriter, r *http.Request, projectDir,
 		return
 	}
 
+	files, err := s.storeFor(r).GetFileSummaries(projectDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Filter for directories and markdown files
 	type Entry struct {
-		Name  string
-		IsDir bool
-		Path  string
+		Name              string
+		IsDir             bool
+		Path              string
+		UnresolvedThreads int
 	}
 
 	var filteredEntries []Entry
 	for _, entry := range entries {
 		if entry.IsDir() {
 			// Skip common directories
-			if shouldSkipDir(entry.Name()) {
+			if cfg.skipDir(entry.Name()) {
 				continue
 			}
 			// Only include directories that contain markdown files
 			dirFullPath := filepath.Join(absPath, entry.Name())
-			if hasMarkdownFiles(dirFullPath) {
+			if hasMarkdownFiles(cfg, dirFullPath) {
+				entryPath := filepath.Join(childPath, entry.Name())
 				filteredEntries = append(filteredEntries, Entry{
-					Name:  entry.Name(),
-					IsDir: true,
-					Path:  filepath.Join(childPath, entry.Name()),
+					Name:              entry.Name(),
+					IsDir:             true,
+					Path:              entryPath,
+					UnresolvedThreads: unresolvedThreadsUnder(files, entryPath, true),
 				})
 			}
-		} else if strings.HasSuffix(strings.ToLower(entry.Name()), ".md") {
+		} else if cfg.isDocument(entry.Name()) {
 			// Include only markdown files
+			entryPath := filepath.Join(childPath, entry.Name())
 			filteredEntries = append(filteredEntries, Entry{
-				Name:  entry.Name(),
-				IsDir: false,
-				Path:  filepath.Join(childPath, entry.Name()),
+				Name:              entry.Name(),
+				IsDir:             false,
+				Path:              entryPath,
+				UnresolvedThreads: unresolvedThreadsUnder(files, entryPath, false),
 			})
 		}
 	}
 
 	data := map[string]interface{}{
+		"Title":      cfg.Title,
 		"ProjectDir": projectDir,
 		"ChildPath":  childPath,
 		"Entries":    filteredEntries,
@@ -283,23 +373,126 @@ func renderDirectoryListing(w http.ResponseWriter, r *http.Request, projectDir,
 	}
 }
 
+// unresolvedThreadsUnder sums the unresolved threads of the file at path, or of
+// every file below it if path is a directory ("" being the project root)
+func unresolvedThreadsUnder(files []FileSummary, path string, isDir bool) int {
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+
+	total := 0
+	for _, f := range files {
+		if f.FilePath == path || isDir && strings.HasPrefix(f.FilePath, prefix) {
+			total += f.UnresolvedThreads
+		}
+	}
+	return total
+}
+
 // API Handlers
 
-func handleCreateComment(w http.ResponseWriter, r *http.Request) {
+// commentIDParam parses the {id} URL parameter, writing an error response if it is invalid
+func commentIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	commentIDStr := chi.URLParam(r, "id")
+
+	var commentID int
+	if _, err := fmt.Sscanf(commentIDStr, "%d", &commentID); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "Invalid comment ID")
+		return 0, false
+	}
+
+	return commentID, true
+}
+
+// validateCommentText checks that comment_text is present and within the size limit
+func validateCommentText(w http.ResponseWriter, text string) bool {
+	if text == "" {
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed, "comment_text is required")
+		return false
+	}
+	if len(text) > maxCommentTextBytes {
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed,
+			fmt.Sprintf("comment_text must be at most %d bytes", maxCommentTextBytes))
+		return false
+	}
+	return true
+}
+
+// validateCommentTarget checks that a new comment points at a real file of a
+// registered project. Root comments must quote text found near their line range;
+// replies must belong to an existing thread on the same file. The file path is
+// normalized in place.
+func (s *Server) validateCommentTarget(w http.ResponseWriter, r *http.Request, comment *Comment) bool {
+	project, err := s.storeFor(r).GetProject(comment.ProjectDirectory)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return false
+	}
+	if project == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Project not registered: "+comment.ProjectDirectory)
+		return false
+	}
+
+	comment.FilePath = path.Clean(filepath.ToSlash(comment.FilePath))
+	absPath, err := resolveProjectFile(project.Directory, comment.FilePath)
+	switch {
+	case errors.Is(err, errFileNotFound):
+		writeError(w, http.StatusNotFound, errCodeNotFound, "File not found: "+comment.FilePath)
+		return false
+	case errors.Is(err, errPathOutsideProject):
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed, "file_path must stay inside the project directory")
+		return false
+	case err != nil:
+		writeInternalError(w, r, err)
+		return false
+	}
+
+	if comment.RootID != nil {
+		root, err := s.storeFor(r).GetCommentByID(*comment.RootID)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return false
+		}
+		if root == nil {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Root comment not found")
+			return false
+		}
+		if root.ProjectDirectory != comment.ProjectDirectory || root.FilePath != comment.FilePath {
+			writeError(w, http.StatusBadRequest, errCodeValidationFailed, "Reply must target the same file as its root comment")
+			return false
+		}
+		return true
+	}
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return false
+	}
+	if !selectedTextNearLines(string(content), comment.SelectedText, *comment.LineStart, *comment.LineEnd) {
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed,
+			fmt.Sprintf("selected_text does not occur near lines %d-%d of %s", *comment.LineStart, *comment.LineEnd, comment.FilePath))
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) handleCreateComment(w http.ResponseWriter, r *http.Request) {
 	var comment Comment
 
-	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &comment) {
 		return
 	}
 
 	// Validate required fields
 	if comment.ProjectDirectory == "" {
-		http.Error(w, "project_directory is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed, "project_directory is required")
 		return
 	}
 	if comment.FilePath == "" {
-		http.Error(w, "file_path is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed, "file_path is required")
 		return
 	}
 
@@ -307,25 +500,33 @@ func handleCreateComment(w http.ResponseWriter, r *http.Request) {
 	// For replies (root_id is set), they are optional
 	if comment.RootID == nil {
 		if comment.LineStart == nil || *comment.LineStart <= 0 {
-			http.Error(w, "line_start must be positive", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeValidationFailed, "line_start must be positive")
 			return
 		}
 		if comment.LineEnd == nil || *comment.LineEnd <= 0 {
-			http.Error(w, "line_end must be positive", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeValidationFailed, "line_end must be positive")
 			return
 		}
 		if *comment.LineEnd < *comment.LineStart {
-			http.Error(w, "line_end must be >= line_start", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeValidationFailed, "line_end must be >= line_start")
 			return
 		}
 		if comment.SelectedText == "" {
-			http.Error(w, "selected_text is required for root comments", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeValidationFailed, "selected_text is required for root comments")
 			return
 		}
 	}
 
-	if comment.CommentText == "" {
-		http.Error(w, "comment_text is required", http.StatusBadRequest)
+	if !validateCommentText(w, comment.CommentText) {
+		return
+	}
+	if len(comment.SelectedText) > maxSelectedTextBytes {
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed,
+			fmt.Sprintf("selected_text must be at most %d bytes", maxSelectedTextBytes))
+		return
+	}
+
+	if !s.validateCommentTarget(w, r, &comment) {
 		return
 	}
 
@@ -334,47 +535,38 @@ func handleCreateComment(w http.ResponseWriter, r *http.Request) {
 		comment.Author = "user"
 	}
 
-	if err := createComment(&comment); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.storeFor(r).CreateComment(&comment); err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
 
 	// Render comment markdown to HTML for web UI response
-	rendered, err := RenderMarkdown([]byte(comment.CommentText))
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to render markdown: %v", err), http.StatusInternalServerError)
+	if err := renderCommentHTML(&comment); err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
-	comment.RenderedHTML = strings.TrimSpace(string(rendered))
 
-	// Don't broadcast reload for comment creation - the frontend handles it locally
-	// Only broadcast for external changes (CLI resolve, file updates)
+	// Other viewers of the file add the comment in place; the originating tab
+	// ignores the event as it already has the comment
+	publishCommentCreated(sseHub, comment)
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(comment); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	writeJSON(w, http.StatusOK, comment)
 }
 
-func handleUpdateComment(w http.ResponseWriter, r *http.Request) {
-	// Extract comment ID from URL path
-	commentIDStr := chi.URLParam(r, "id")
-
-	// Parse comment ID
-	var commentID int
-	if _, err := fmt.Sscanf(commentIDStr, "%d", &commentID); err != nil {
-		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+func (s *Server) handleUpdateComment(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
 		return
 	}
 
 	// Check if comment has replies
-	hasReply, err := hasReplies(commentID)
+	hasReply, err := s.storeFor(r).HasReplies(commentID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 	if hasReply {
-		http.Error(w, "Cannot edit comment with replies", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeCommentHasReplies, "Cannot edit comment with replies")
 		return
 	}
 
@@ -382,93 +574,277 @@ func handleUpdateComment(w http.ResponseWriter, r *http.Request) {
 		CommentText string `json:"comment_text"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !validateCommentText(w, req.CommentText) {
 		return
 	}
 
-	if err := updateComment(commentIDStr, req.CommentText); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.storeFor(r).UpdateComment(commentID, req.CommentText); err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
 
 	// Get the updated comment
-	comment, err := getCommentByID(commentID)
+	comment, err := s.storeFor(r).GetCommentByID(commentID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 	if comment == nil {
-		http.Error(w, "Comment not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Comment not found")
 		return
 	}
 
 	// Render comment markdown to HTML for web UI response
-	rendered, err := RenderMarkdown([]byte(comment.CommentText))
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to render markdown: %v", err), http.StatusInternalServerError)
+	if err := renderCommentHTML(comment); err != nil {
+		writeInternalError(w, r, err)
 		return
 	}
-	comment.RenderedHTML = strings.TrimSpace(string(rendered))
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(comment); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	publishComment(sseHub, eventCommentUpdated, *comment)
+
+	writeJSON(w, http.StatusOK, comment)
 }
 
-func handleDeleteComment(w http.ResponseWriter, r *http.Request) {
-	// Extract comment ID from URL path
-	commentID := chi.URLParam(r, "id")
+func (s *Server) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
+		return
+	}
 
-	if err := deleteComment(commentID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Look the comment up first so viewers of its file can be notified
+	comment, err := s.storeFor(r).GetCommentByID(commentID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if comment == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Comment not found")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]string{"status": "deleted"}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Deleting a root deletes its whole thread, so no reply is left pointing
+	// at a missing root
+	if err := s.storeFor(r).DeleteComment(commentID); err != nil {
+		writeInternalError(w, r, err)
+		return
 	}
-}
 
-func handleResolveThread(w http.ResponseWriter, r *http.Request) {
-	// Extract comment ID from URL path
-	commentIDStr := chi.URLParam(r, "id")
+	sseHub.broadcast(comment.ProjectDirectory, comment.FilePath, eventCommentDeleted, CommentDeletedEvent{
+		ID:     comment.ID,
+		RootID: comment.RootID,
+	})
 
-	// Parse comment ID
-	var commentID int
-	if _, err := fmt.Sscanf(commentIDStr, "%d", &commentID); err != nil {
-		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (s *Server) handleResolveThread(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
 		return
 	}
 
 	// Get comment to retrieve project_directory and file_path for SSE broadcast
-	comment, err := getCommentByID(commentID)
+	comment, err := s.storeFor(r).GetCommentByID(commentID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 	if comment == nil {
-		http.Error(w, "Comment not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Comment not found")
 		return
 	}
 
 	// Resolve the thread (marked as resolved by 'user' since it's from web UI)
-	count, err := resolveThread(commentID, "user")
+	count, err := s.storeFor(r).ResolveThread(commentID, "user")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 
-	// Don't broadcast reload for web UI resolution - the frontend handles it locally
-	// Only broadcast for CLI resolution (via notify endpoint)
+	if count > 0 {
+		sseHub.broadcast(comment.ProjectDirectory, comment.FilePath, eventThreadResolved, ThreadResolvedEvent{
+			RootID:     threadRootID(*comment),
+			ResolvedBy: "user",
+			Count:      count,
+		})
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"status": "resolved",
 		"count":  count,
-	}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	})
+}
+
+func (s *Server) handleListComments(w http.ResponseWriter, r *http.Request) {
+	filter := CommentFilter{
+		ProjectDirectory: r.URL.Query().Get("project_directory"),
+		FilePath:         r.URL.Query().Get("file_path"),
+		Status:           r.URL.Query().Get("status"),
+	}
+
+	if filter.ProjectDirectory == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "project_directory is required")
+		return
+	}
+
+	switch filter.Status {
+	case "":
+		filter.Status = commentStatusUnresolved
+	case commentStatusUnresolved, commentStatusResolved, commentStatusAll:
+	default:
+		writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "status must be one of: unresolved, resolved, all")
+		return
+	}
+
+	comments, err := s.storeFor(r).ListComments(filter)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if comments == nil {
+		comments = []Comment{}
+	}
+
+	if err := renderCommentsAsHTML(comments); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (s *Server) handleGetThread(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	comment, err := s.storeFor(r).GetCommentByID(commentID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if comment == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Comment not found")
+		return
+	}
+
+	// Accept any comment in the thread, not only the root
+	rootID := threadRootID(*comment)
+
+	comments, err := s.storeFor(r).GetThread(rootID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if len(comments) == 0 || comments[0].ID != rootID {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Thread not found")
+		return
+	}
+
+	if err := renderCommentsAsHTML(comments); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	root := comments[0]
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":                root.ID,
+		"project_directory": root.ProjectDirectory,
+		"file_path":         root.FilePath,
+		"resolved":          root.ResolvedAt != nil,
+		"root":              root,
+		"replies":           append([]Comment{}, comments[1:]...),
+	})
+}
+
+// handleProjectSummary serves GET /api/v1/projects/{dir}/summary, where {dir} is the
+// absolute project directory (its slashes are part of the path)
+func (s *Server) handleProjectSummary(w http.ResponseWriter, r *http.Request) {
+	rest, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "Invalid path")
+		return
+	}
+	rest = "/" + strings.TrimPrefix(rest, "/")
+	if !strings.HasSuffix(rest, "/summary") {
+		handleAPINotFound(w, r)
+		return
+	}
+	projectDir := strings.TrimSuffix(rest, "/summary")
+
+	project, err := s.storeFor(r).GetProject(projectDir)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if project == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Project not found")
+		return
+	}
+
+	files, err := s.storeFor(r).GetFileSummaries(project.Directory)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if files == nil {
+		files = []FileSummary{}
+	}
+
+	totals := FileSummary{}
+	for _, f := range files {
+		totals.UnresolvedThreads += f.UnresolvedThreads
+		totals.UnresolvedComments += f.UnresolvedComments
+		totals.ResolvedComments += f.ResolvedComments
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"project_directory":   project.Directory,
+		"files":               files,
+		"unresolved_threads":  totals.UnresolvedThreads,
+		"unresolved_comments": totals.UnresolvedComments,
+		"resolved_comments":   totals.ResolvedComments,
+	})
+}
+
+// DaemonStatus reports runtime state that is not visible from the CLI. Service
+// and PID let the CLI confirm it is talking to the server it expects.
+type DaemonStatus struct {
+	Service string         `json:"service"`
+	PID     int            `json:"pid"`
+	Watcher *WatcherStatus `json:"watcher,omitempty"`
+	// Idle is absent when the server never shuts down by itself
+	Idle    *IdleStatus      `json:"idle,omitempty"`
+	Metrics *MetricsSnapshot `json:"metrics,omitempty"`
+}
+
+// handleStatus reports the daemon's runtime state for `server --status`
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := DaemonStatus{Service: serviceName, PID: os.Getpid()}
+	if fileWatcher != nil {
+		watcher := fileWatcher.status()
+		status.Watcher = &watcher
+	}
+	if idleShutdown != nil {
+		idle := idleShutdown.status()
+		status.Idle = &idle
+	}
+	metrics := collectMetrics()
+	status.Metrics = &metrics
+	writeJSON(w, http.StatusOK, status)
+}
+
+// renderCommentsAsHTML renders the comment_text field of each comment as HTML
+// and stores it in the RenderedHTML field for web UI display
+func renderCommentsAsHTML(comments []Comment) error {
+	for i := range comments {
+		if err := renderCommentHTML(&comments[i]); err != nil {
+			return err
+		}
 	}
+	return nil
 }
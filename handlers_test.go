@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAPIToken = "test-token"
+
+type testServer struct {
+	store      *MemoryStore
+	handler    http.Handler
+	projectDir string
+}
+
+// newTestServer builds the router on top of an in-memory store with a registered project
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	require.NoError(t, initTemplates())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	projectDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "doc.md"), []byte("# Title\n\nBody text.\n"), 0644))
+
+	store := newMemoryStore()
+	_, err := store.CreateProject(projectDir)
+	require.NoError(t, err)
+
+	handler, err := newServer(store, testAPIToken).routes()
+	require.NoError(t, err)
+
+	return &testServer{store: store, handler: handler, projectDir: projectDir}
+}
+
+func (ts *testServer) do(t *testing.T, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Host = "localhost:4779"
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(apiTokenHeader, testAPIToken)
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers_CreateComment(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodPost, "/api/v1/comments", map[string]interface{}{
+		"project_directory": ts.projectDir,
+		"file_path":         "doc.md",
+		"line_start":        1,
+		"line_end":          1,
+		"selected_text":     "Title",
+		"comment_text":      "Needs **work**",
+	})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var created Comment
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	assert.Equal(t, "user", created.Author)
+	assert.Contains(t, created.RenderedHTML, "<strong>work</strong>")
+
+	stored, err := ts.store.GetCommentByID(created.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, "Needs **work**", stored.CommentText)
+}
+
+func TestHandlers_CreateComment_Validation(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodPost, "/api/v1/comments", map[string]interface{}{
+		"project_directory": ts.projectDir,
+		"file_path":         "doc.md",
+		"comment_text":      "Missing line range",
+	})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandlers_UpdateAndResolve(t *testing.T) {
+	ts := newTestServer(t)
+
+	root := &Comment{
+		ProjectDirectory: ts.projectDir,
+		FilePath:         "doc.md",
+		LineStart:        intPtr(1),
+		LineEnd:          intPtr(1),
+		SelectedText:     "Title",
+		CommentText:      "Original",
+		Author:           "user",
+	}
+	require.NoError(t, ts.store.CreateComment(root))
+
+	rec := ts.do(t, http.MethodPatch, fmt.Sprintf("/api/v1/comments/%d", root.ID), map[string]string{
+		"comment_text": "Edited",
+	})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Once a reply exists the root can no longer be edited
+	require.NoError(t, ts.store.CreateComment(&Comment{
+		ProjectDirectory: ts.projectDir,
+		FilePath:         "doc.md",
+		CommentText:      "Reply",
+		Author:           "agent",
+		RootID:           &root.ID,
+	}))
+	rec = ts.do(t, http.MethodPatch, fmt.Sprintf("/api/v1/comments/%d", root.ID), map[string]string{
+		"comment_text": "Edited again",
+	})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = ts.do(t, http.MethodPatch, fmt.Sprintf("/api/v1/comments/%d/resolve", root.ID), map[string]string{})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	unresolved, err := ts.store.GetComments(ts.projectDir, "doc.md", false)
+	require.NoError(t, err)
+	assert.Empty(t, unresolved)
+}
+
+func TestHandlers_DeleteComment_InvalidID(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodDelete, "/api/v1/comments/abc", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = ts.do(t, http.MethodDelete, "/api/v1/comments/12345", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), errCodeNotFound)
+}
+
+func TestHandlers_DeleteComment_Thread(t *testing.T) {
+	ts := newTestServer(t)
+
+	root := &Comment{ProjectDirectory: ts.projectDir, FilePath: "doc.md", CommentText: "Root", Author: "user"}
+	require.NoError(t, ts.store.CreateComment(root))
+	reply := &Comment{ProjectDirectory: ts.projectDir, FilePath: "doc.md", CommentText: "Reply", Author: "agent", RootID: &root.ID}
+	require.NoError(t, ts.store.CreateComment(reply))
+
+	rec := ts.do(t, http.MethodDelete, fmt.Sprintf("/api/v1/comments/%d", root.ID), nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	got, err := ts.store.GetCommentByID(reply.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got, "the replies go with their root")
+}
+
+func TestHandlers_Viewer(t *testing.T) {
+	ts := newTestServer(t)
+
+	require.NoError(t, ts.store.CreateComment(&Comment{
+		ProjectDirectory: ts.projectDir,
+		FilePath:         "doc.md",
+		LineStart:        intPtr(3),
+		LineEnd:          intPtr(3),
+		SelectedText:     "Body",
+		CommentText:      "Inline note",
+		Author:           "user",
+	}))
+
+	rec := ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/doc.md", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "data-line-start")
+	assert.Contains(t, rec.Body.String(), "Inline note")
+}
+
+func TestHandlers_ReadAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	root := &Comment{
+		ProjectDirectory: ts.projectDir,
+		FilePath:         "doc.md",
+		LineStart:        intPtr(1),
+		LineEnd:          intPtr(1),
+		SelectedText:     "Title",
+		CommentText:      "Root *note*",
+		Author:           "user",
+	}
+	require.NoError(t, ts.store.CreateComment(root))
+	reply := &Comment{
+		ProjectDirectory: ts.projectDir,
+		FilePath:         "doc.md",
+		CommentText:      "Reply",
+		Author:           "agent",
+		RootID:           &root.ID,
+	}
+	require.NoError(t, ts.store.CreateComment(reply))
+
+	t.Run("list comments", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/comments?project_directory="+url.QueryEscape(ts.projectDir)+"&file_path=doc.md", nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var comments []Comment
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&comments))
+		require.Len(t, comments, 2)
+		assert.Contains(t, comments[0].RenderedHTML, "<em>note</em>")
+	})
+
+	t.Run("list resolved comments is empty array", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/comments?status=resolved&project_directory="+url.QueryEscape(ts.projectDir), nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "[]", strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("list requires project and valid status", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/comments", nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = ts.do(t, http.MethodGet, "/api/v1/comments?status=bogus&project_directory="+url.QueryEscape(ts.projectDir), nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("get thread by reply id", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, fmt.Sprintf("/api/v1/threads/%d", reply.ID), nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var thread struct {
+			ID      int       `json:"id"`
+			Root    Comment   `json:"root"`
+			Replies []Comment `json:"replies"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&thread))
+		assert.Equal(t, root.ID, thread.ID)
+		assert.Equal(t, root.ID, thread.Root.ID)
+		require.Len(t, thread.Replies, 1)
+		assert.Equal(t, reply.ID, thread.Replies[0].ID)
+	})
+
+	t.Run("get missing thread", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/threads/9999", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("project summary", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/projects"+ts.projectDir+"/summary", nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var summary struct {
+			ProjectDirectory  string        `json:"project_directory"`
+			Files             []FileSummary `json:"files"`
+			UnresolvedThreads int           `json:"unresolved_threads"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+		assert.Equal(t, ts.projectDir, summary.ProjectDirectory)
+		assert.Equal(t, 1, summary.UnresolvedThreads)
+		assert.Equal(t, []FileSummary{{FilePath: "doc.md", UnresolvedThreads: 1, UnresolvedComments: 2}}, summary.Files)
+	})
+
+	t.Run("summary for unknown project", func(t *testing.T) {
+		rec := ts.do(t, http.MethodGet, "/api/v1/projects/no/such/project/summary", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestHandlers_ListingsShowUnresolvedThreads(t *testing.T) {
+	ts := newTestServer(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(ts.projectDir, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(ts.projectDir, "docs", "plan.md"), []byte("# Plan\n"), 0644))
+
+	for _, filePath := range []string{"docs/plan.md", "docs/plan.md", "doc.md"} {
+		require.NoError(t, ts.store.CreateComment(&Comment{
+			ProjectDirectory: ts.projectDir,
+			FilePath:         filePath,
+			LineStart:        intPtr(1),
+			LineEnd:          intPtr(1),
+			CommentText:      "Comment",
+		}))
+	}
+
+	rec := ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `data-live-scope="project"`)
+	assert.Regexp(t, `docs/\s*</a>\s*<span class="unresolved-count" title="Unresolved threads">2</span>`, body)
+	assert.Regexp(t, `doc\.md\s*</a>\s*<span class="unresolved-count" title="Unresolved threads">1</span>`, body)
+
+	rec = ts.do(t, http.MethodGet, "/", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `data-live-scope="global"`)
+	assert.Contains(t, rec.Body.String(), `<span class="unresolved-count" title="Unresolved threads">3</span>`)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultIdleTimeout is how long the daemon waits without SSE clients or API
+// calls before shutting down, when CR_IDLE_TIMEOUT is unset. `review` starts
+// it again on demand.
+const defaultIdleTimeout = 24 * time.Hour
+
+// idleShutdown tracks activity in the background daemon; nil in the
+// foreground or when CR_IDLE_TIMEOUT is 0
+var idleShutdown *idleTimer
+
+// getIdleTimeout reads CR_IDLE_TIMEOUT (e.g. "8h", or "0" to never shut down)
+func getIdleTimeout() (time.Duration, error) {
+	value := os.Getenv("CR_IDLE_TIMEOUT")
+	if value == "" {
+		return defaultIdleTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid CR_IDLE_TIMEOUT %q", value)
+	}
+	return timeout, nil
+}
+
+// IdleStatus reports when the daemon will shut down for lack of use
+type IdleStatus struct {
+	Timeout    string `json:"timeout"`
+	Remaining  string `json:"remaining"`
+	SSEClients int    `json:"sse_clients"`
+}
+
+// idleTimer measures the time since the last API call, counting connected SSE
+// clients as continuous activity
+type idleTimer struct {
+	timeout time.Duration
+	clients func() int
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newIdleTimer(timeout time.Duration, clients func() int) *idleTimer {
+	return &idleTimer{timeout: timeout, clients: clients, last: time.Now()}
+}
+
+// touch records activity
+func (t *idleTimer) touch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = time.Now()
+}
+
+// remaining returns the time left before the daemon is idle for the full
+// timeout, or 0 once it is
+func (t *idleTimer) remaining() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The clock starts over once the last viewer leaves
+	if t.clients() > 0 {
+		t.last = time.Now()
+		return t.timeout
+	}
+	return max(t.timeout-time.Since(t.last), 0)
+}
+
+func (t *idleTimer) status() IdleStatus {
+	return IdleStatus{
+		Timeout:    t.timeout.String(),
+		Remaining:  t.remaining().Round(time.Second).String(),
+		SSEClients: t.clients(),
+	}
+}
+
+// watch returns a channel that is closed once the daemon has been idle for
+// the whole timeout
+func (t *idleTimer) watch() <-chan struct{} {
+	expired := make(chan struct{})
+	interval := max(min(t.timeout/10, time.Minute), 10*time.Millisecond)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if t.remaining() == 0 {
+				close(expired)
+				return
+			}
+		}
+	}()
+	return expired
+}
+
+// passiveRoutes are polled by CLI commands and monitoring, and must not keep
+// an unused daemon alive
+var passiveRoutes = map[string]bool{
+	apiPrefix + "/status": true,
+	"/api/version":        true,
+	"/healthz":            true,
+	"/metrics":            true,
+}
+
+// trackActivity counts every request as activity, except those to passiveRoutes
+func trackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if idle := idleShutdown; idle != nil && !passiveRoutes[r.URL.Path] {
+			idle.touch()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
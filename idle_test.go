@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleTimer(t *testing.T) {
+	var clients atomic.Int32
+	timer := newIdleTimer(time.Hour, func() int { return int(clients.Load()) })
+
+	timer.last = time.Now().Add(-40 * time.Minute)
+	assert.InDelta(t, 20*time.Minute, timer.remaining(), float64(time.Second))
+
+	// An API call restarts the clock
+	timer.touch()
+	assert.InDelta(t, time.Hour, timer.remaining(), float64(time.Second))
+
+	// So does every check while a viewer is connected
+	timer.last = time.Now().Add(-2 * time.Hour)
+	clients.Store(1)
+	assert.Equal(t, time.Hour, timer.remaining())
+	clients.Store(0)
+	assert.InDelta(t, time.Hour, timer.remaining(), float64(time.Second))
+
+	timer.last = time.Now().Add(-2 * time.Hour)
+	assert.Zero(t, timer.remaining())
+	assert.Equal(t, IdleStatus{Timeout: "1h0m0s", Remaining: "0s"}, timer.status())
+}
+
+func TestIdleTimer_Watch(t *testing.T) {
+	var clients atomic.Int32
+	clients.Store(1)
+	timer := newIdleTimer(100*time.Millisecond, func() int { return int(clients.Load()) })
+	expired := timer.watch()
+
+	select {
+	case <-expired:
+		t.Fatal("expired while a client was connected")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	clients.Store(0)
+	select {
+	case <-expired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not expire")
+	}
+}
+
+func TestTrackActivity(t *testing.T) {
+	ts := newTestServer(t)
+
+	previous := idleShutdown
+	idleShutdown = newIdleTimer(time.Hour, func() int { return 0 })
+	t.Cleanup(func() { idleShutdown = previous })
+
+	tests := []struct {
+		path   string
+		counts bool
+	}{
+		{"/api/v1/comments?project_directory=/nope&file_path=doc.md", true},
+		{"/", true},
+		{"/api/v1/status", false},
+		{"/api/version", false},
+		{"/healthz", false},
+		{"/metrics", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			idleShutdown.last = time.Now().Add(-time.Minute)
+			ts.do(t, http.MethodGet, tt.path, nil)
+			if tt.counts {
+				assert.Greater(t, idleShutdown.remaining(), 59*time.Minute+55*time.Second)
+			} else {
+				assert.Less(t, idleShutdown.remaining(), 59*time.Minute+5*time.Second)
+			}
+		})
+	}
+
+	// Requests the daemon refuses do not keep it alive
+	idleShutdown.last = time.Now().Add(-time.Minute)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "evil.example"
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Less(t, idleShutdown.remaining(), 59*time.Minute+5*time.Second)
+}
+
+func TestGetIdleTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultIdleTimeout, false},
+		{"8h", 8 * time.Hour, false},
+		{"0", 0, false},
+		{"-1h", 0, true},
+		{"forever", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("CR_IDLE_TIMEOUT", tt.value)
+			got, err := getIdleTimeout()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,29 +1,54 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
-func installSlashCommands() error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
-	}
+// Installed slash commands carry these keys at the top of their frontmatter,
+// so that a later install can tell which version wrote a command and whether
+// it was edited since. The checksum covers the command without the two keys.
+const (
+	stampVersionKey  = "claude-review-version: "
+	stampChecksumKey = "claude-review-checksum: "
+)
 
-	commandsDir := filepath.Join(homeDir, ".claude", "commands")
+// shippedCommandChecksums are the checksums of the slash commands releases
+// installed before commands were stamped. An unstamped file matching one was
+// not edited, so it is outdated rather than modified. When a command changes,
+// its old checksum stays here.
+var shippedCommandChecksums = map[string][]string{
+	"cr-address.md": {"sha256:11d7f18037f44c6672ea146aad04bb84524e2c2ff86b4b0b50d98c87c8c17b84"},
+	"cr-review.md":  {"sha256:b3c57277cd9e5df4f968a1b267346dbb3f288dd19be9fee95126819635cf84be"},
+}
 
-	// Create commands directory if it doesn't exist
-	if err := os.MkdirAll(commandsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create commands directory: %w", err)
-	}
+// frontmatterDelimiter opens and closes the frontmatter every embedded slash
+// command starts with
+const frontmatterDelimiter = "---\n"
 
-	// Install all slash commands from embedded FS
-	var installed []string
-	err = fs.WalkDir(slashCommandsFS, "slash-commands", func(path string, d fs.DirEntry, err error) error {
+// slashCommand is a slash command built into the binary
+type slashCommand struct {
+	filename string
+	content  []byte
+}
+
+// name returns the command as it is typed, such as /cr-review
+func (c slashCommand) name() string {
+	return "/" + strings.TrimSuffix(c.filename, ".md")
+}
+
+// embeddedSlashCommands returns the slash commands from the embedded FS
+func embeddedSlashCommands() ([]slashCommand, error) {
+	var commands []slashCommand
+	err := fs.WalkDir(slashCommandsFS, "slash-commands", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -32,76 +57,245 @@ func installSlashCommands() error {
 			return nil
 		}
 
-		// Read embedded slash command
-		commandContent, err := slashCommandsFS.ReadFile(path)
+		content, err := slashCommandsFS.ReadFile(path)
 		if err != nil {
 			return fmt.Errorf("failed to read %s: %w", path, err)
 		}
+		commands = append(commands, slashCommand{filename: filepath.Base(path), content: content})
+		return nil
+	})
+	return commands, err
+}
+
+// getCommandsDir returns the directory Claude Code reads user slash commands from
+func getCommandsDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".claude", "commands"), nil
+}
 
-		// Write to ~/.claude/commands/
-		filename := filepath.Base(path)
-		commandPath := filepath.Join(commandsDir, filename)
-		if err := os.WriteFile(commandPath, commandContent, 0644); err != nil {
-			return fmt.Errorf("failed to write %s: %w", filename, err)
+// getProjectCommandsDir returns the slash command directory of the project
+// dir belongs to: that of the enclosing Git repository, or of dir itself
+func getProjectCommandsDir(dir string) string {
+	return filepath.Join(findProjectRoot(dir), ".claude", "commands")
+}
+
+// findProjectRoot returns the closest directory from dir upwards that holds a
+// .git directory or file, or dir if there is none
+func findProjectRoot(dir string) string {
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return dir
 		}
+		current = parent
+	}
+}
 
-		installed = append(installed, filename)
-		return nil
-	})
+// commandChecksum identifies the content of a slash command
+func commandChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
 
+// stampSlashCommand adds the version and checksum to a command's frontmatter
+func stampSlashCommand(content []byte, version string) []byte {
+	stamp := frontmatterDelimiter + stampVersionKey + version + "\n" + stampChecksumKey + commandChecksum(content) + "\n"
+	return append([]byte(stamp), bytes.TrimPrefix(content, []byte(frontmatterDelimiter))...)
+}
+
+// readStamp splits an installed command into the version and checksum it was
+// stamped with and the content they describe
+func readStamp(installed []byte) (version, checksum string, content []byte, ok bool) {
+	rest, found := bytes.CutPrefix(installed, []byte(frontmatterDelimiter))
+	if !found {
+		return "", "", nil, false
+	}
+	versionLine, rest, _ := bytes.Cut(rest, []byte("\n"))
+	checksumLine, rest, _ := bytes.Cut(rest, []byte("\n"))
+	versionValue, found := bytes.CutPrefix(versionLine, []byte(stampVersionKey))
+	if !found {
+		return "", "", nil, false
+	}
+	checksumValue, found := bytes.CutPrefix(checksumLine, []byte(stampChecksumKey))
+	if !found {
+		return "", "", nil, false
+	}
+	return string(versionValue), string(checksumValue), append([]byte(frontmatterDelimiter), rest...), true
+}
+
+// commandState is how an installed slash command compares with the embedded one
+type commandState int
+
+const (
+	commandMissing commandState = iota
+	commandCurrent
+	commandOutdated
+	// commandModified was edited since it was installed, or is unstamped and
+	// matches no command a release shipped
+	commandModified
+)
+
+// installedCommand describes a slash command in a commands directory
+type installedCommand struct {
+	slashCommand
+	path  string
+	state commandState
+	// version is the one the command was stamped with, if any
+	version string
+}
+
+// describe returns the command's state for humans
+func (c installedCommand) describe() string {
+	switch c.state {
+	case commandMissing:
+		return "missing"
+	case commandCurrent:
+		return "up to date"
+	case commandOutdated:
+		if c.version == "" {
+			return "outdated (installed before versions were stamped)"
+		}
+		return fmt.Sprintf("outdated (installed by %s)", c.version)
+	}
+	if c.version == "" {
+		return "has no version stamp, so it may hold your edits"
+	}
+	return fmt.Sprintf("edited since %s installed it", c.version)
+}
+
+// inspectSlashCommands compares the commands in commandsDir with the
+// embedded ones
+func inspectSlashCommands(commandsDir string) ([]installedCommand, error) {
+	commands, err := embeddedSlashCommands()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Printf("Successfully installed %d slash command(s) to %s:\n", len(installed), commandsDir)
-	for _, name := range installed {
-		cmdName := strings.TrimSuffix(name, ".md")
-		fmt.Printf("  /%s\n", cmdName)
+	var result []installedCommand
+	for _, command := range commands {
+		installed := installedCommand{slashCommand: command, path: filepath.Join(commandsDir, command.filename)}
+
+		data, err := os.ReadFile(installed.path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", installed.path, err)
+		}
+		version, checksum, content, stamped := readStamp(data)
+		installed.version = version
+		switch {
+		case os.IsNotExist(err):
+			installed.state = commandMissing
+		case stamped && bytes.Equal(content, command.content), !stamped && bytes.Equal(data, command.content):
+			installed.state = commandCurrent
+		case !stamped && slices.Contains(shippedCommandChecksums[command.filename], commandChecksum(data)):
+			installed.state = commandOutdated
+		case !stamped, commandChecksum(content) != checksum:
+			installed.state = commandModified
+		default:
+			installed.state = commandOutdated
+		}
+		result = append(result, installed)
 	}
+	return result, nil
+}
 
-	return nil
+// printSlashCommandStatus reports the state of each command in commandsDir
+// and whether they are all up to date
+func printSlashCommandStatus(w io.Writer, commandsDir, installCommand string) (bool, error) {
+	commands, err := inspectSlashCommands(commandsDir)
+	if err != nil {
+		return false, err
+	}
+
+	upToDate, modified := true, false
+	_, _ = fmt.Fprintf(w, "Slash commands in %s:\n", commandsDir)
+	for _, command := range commands {
+		_, _ = fmt.Fprintf(w, "  %-12s %s\n", command.name(), command.describe())
+		upToDate = upToDate && command.state == commandCurrent
+		modified = modified || command.state == commandModified
+	}
+	switch {
+	case modified:
+		_, _ = fmt.Fprintf(w, "Run `%s --force` to update them, discarding your edits\n", installCommand)
+	case !upToDate:
+		_, _ = fmt.Fprintf(w, "Run `%s` to update them\n", installCommand)
+	}
+	return upToDate, nil
 }
 
-func uninstallSlashCommands() error {
-	homeDir, err := os.UserHomeDir()
+// installSlashCommands writes the embedded slash commands to commandsDir,
+// stamped with this version. Commands edited since they were installed are
+// only replaced if force is set; otherwise nothing is written.
+func installSlashCommands(commandsDir string, force bool) error {
+	commands, err := inspectSlashCommands(commandsDir)
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
+	}
+
+	if !force {
+		var modified []string
+		for _, command := range commands {
+			if command.state == commandModified {
+				modified = append(modified, fmt.Sprintf("%s %s", command.name(), command.describe()))
+			}
+		}
+		if len(modified) > 0 {
+			return fmt.Errorf("not overwriting slash commands in %s: %s; use --force to replace them",
+				commandsDir, strings.Join(modified, ", "))
+		}
 	}
 
-	commandsDir := filepath.Join(homeDir, ".claude", "commands")
+	// Create commands directory if it doesn't exist
+	if err := os.MkdirAll(commandsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create commands directory: %w", err)
+	}
 
-	// Collect all slash command filenames from embedded FS
-	var toUninstall []string
-	err = fs.WalkDir(slashCommandsFS, "slash-commands", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	var installed []string
+	for _, command := range commands {
+		if err := os.WriteFile(command.path, stampSlashCommand(command.content, Version), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", command.filename, err)
 		}
 
-		if d.IsDir() || !strings.HasSuffix(path, ".md") {
-			return nil
+		line := command.name()
+		if command.state == commandModified {
+			line += " (replaced your changes)"
 		}
+		installed = append(installed, line)
+	}
 
-		toUninstall = append(toUninstall, filepath.Base(path))
-		return nil
-	})
+	fmt.Printf("Successfully installed %d slash command(s) to %s:\n", len(installed), commandsDir)
+	for _, line := range installed {
+		fmt.Printf("  %s\n", line)
+	}
+
+	return nil
+}
 
+// uninstallSlashCommands removes the embedded slash commands from commandsDir
+func uninstallSlashCommands(commandsDir string) error {
+	commands, err := embeddedSlashCommands()
 	if err != nil {
 		return err
 	}
 
 	// Remove each command file
 	var removed []string
-	for _, filename := range toUninstall {
-		commandPath := filepath.Join(commandsDir, filename)
+	for _, command := range commands {
+		commandPath := filepath.Join(commandsDir, command.filename)
 		err := os.Remove(commandPath)
 		if err != nil {
 			if os.IsNotExist(err) {
 				// File doesn't exist, skip silently
 				continue
 			}
-			return fmt.Errorf("failed to remove %s: %w", filename, err)
+			return fmt.Errorf("failed to remove %s: %w", command.filename, err)
 		}
-		removed = append(removed, filename)
+		removed = append(removed, command.filename)
 	}
 
 	if len(removed) == 0 {
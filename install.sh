@@ -94,7 +94,9 @@ chmod +x "$BIN_DIR/claude-review"
 
 # Install slash commands using the binary
 echo "Installing slash commands..."
-"$BIN_DIR/claude-review" install
+if ! "$BIN_DIR/claude-review" install; then
+    echo "Warning: Kept your edited slash commands; run 'claude-review install --force' to replace them"
+fi
 
 # Restart server if it was running before upgrade
 if [ "$SERVER_WAS_RUNNING" = true ]; then
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStampSlashCommand(t *testing.T) {
+	commands, err := embeddedSlashCommands()
+	require.NoError(t, err)
+	require.NotEmpty(t, commands)
+
+	for _, command := range commands {
+		require.True(t, bytes.HasPrefix(command.content, []byte(frontmatterDelimiter)), "%s has frontmatter", command.filename)
+
+		stamped := stampSlashCommand(command.content, "v1.2.3")
+		assert.True(t, bytes.HasPrefix(stamped, []byte("---\nclaude-review-version: v1.2.3\nclaude-review-checksum: sha256:")), string(stamped))
+
+		version, checksum, content, ok := readStamp(stamped)
+		require.True(t, ok)
+		assert.Equal(t, "v1.2.3", version)
+		assert.Equal(t, commandChecksum(command.content), checksum)
+		assert.Equal(t, command.content, content)
+
+		_, _, _, ok = readStamp(command.content)
+		assert.False(t, ok, "the embedded command has no stamp")
+	}
+}
+
+func TestInstallSlashCommands(t *testing.T) {
+	commandsDir := filepath.Join(t.TempDir(), ".claude", "commands")
+	addressPath := filepath.Join(commandsDir, "cr-address.md")
+	reviewPath := filepath.Join(commandsDir, "cr-review.md")
+
+	states := func() map[string]commandState {
+		t.Helper()
+		commands, err := inspectSlashCommands(commandsDir)
+		require.NoError(t, err)
+		result := map[string]commandState{}
+		for _, command := range commands {
+			result[command.name()] = command.state
+		}
+		return result
+	}
+	assert.Equal(t, map[string]commandState{"/cr-address": commandMissing, "/cr-review": commandMissing}, states())
+
+	require.NoError(t, installSlashCommands(commandsDir, false))
+	assert.Equal(t, map[string]commandState{"/cr-address": commandCurrent, "/cr-review": commandCurrent}, states())
+	installed, err := os.ReadFile(reviewPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(installed, []byte("---\nclaude-review-version: "+Version+"\n")))
+
+	// Installed by an older version, and edited by hand
+	require.NoError(t, os.WriteFile(addressPath, stampSlashCommand([]byte("---\nold: true\n---\n"), "v0.1.0"), 0644))
+	edited := append(installed, []byte("\nAlso summarise the document.\n")...)
+	require.NoError(t, os.WriteFile(reviewPath, edited, 0644))
+	assert.Equal(t, map[string]commandState{"/cr-address": commandOutdated, "/cr-review": commandModified}, states())
+
+	err = installSlashCommands(commandsDir, false)
+	assert.ErrorContains(t, err, "/cr-review edited since "+Version+" installed it; use --force to replace them")
+	assert.Equal(t, commandOutdated, states()["/cr-address"], "nothing is written while an edited command is in the way")
+
+	require.NoError(t, installSlashCommands(commandsDir, true))
+	assert.Equal(t, map[string]commandState{"/cr-address": commandCurrent, "/cr-review": commandCurrent}, states())
+
+	// Commands installed before they were stamped may be edited, unless they
+	// match this version
+	commands, err := embeddedSlashCommands()
+	require.NoError(t, err)
+	for _, command := range commands {
+		require.NoError(t, os.WriteFile(filepath.Join(commandsDir, command.filename), command.content, 0644))
+	}
+	require.NoError(t, os.WriteFile(addressPath, []byte("my own version\n"), 0644))
+	assert.Equal(t, map[string]commandState{"/cr-address": commandModified, "/cr-review": commandCurrent}, states())
+
+	// or match a command an earlier release shipped
+	previous := shippedCommandChecksums
+	t.Cleanup(func() { shippedCommandChecksums = previous })
+	shippedCommandChecksums = map[string][]string{"cr-address.md": {commandChecksum([]byte("---\nold: true\n---\n"))}}
+	require.NoError(t, os.WriteFile(addressPath, []byte("---\nold: true\n---\n"), 0644))
+	inspected, err := inspectSlashCommands(commandsDir)
+	require.NoError(t, err)
+	require.Equal(t, "/cr-address", inspected[0].name())
+	assert.Equal(t, commandOutdated, inspected[0].state)
+	assert.Equal(t, "outdated (installed before versions were stamped)", inspected[0].describe())
+	require.NoError(t, installSlashCommands(commandsDir, false))
+	assert.Equal(t, map[string]commandState{"/cr-address": commandCurrent, "/cr-review": commandCurrent}, states())
+}
+
+func TestShippedCommandChecksums(t *testing.T) {
+	commands, err := embeddedSlashCommands()
+	require.NoError(t, err)
+	for _, command := range commands {
+		assert.NotEmpty(t, shippedCommandChecksums[command.filename], "%s was shipped unstamped", command.filename)
+	}
+}
+
+func TestUninstallSlashCommands(t *testing.T) {
+	commandsDir := getProjectCommandsDir(t.TempDir())
+	require.NoError(t, installSlashCommands(commandsDir, false))
+	other := filepath.Join(commandsDir, "mine.md")
+	require.NoError(t, os.WriteFile(other, []byte("# Mine\n"), 0644))
+
+	require.NoError(t, uninstallSlashCommands(commandsDir))
+	entries, err := os.ReadDir(commandsDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "other commands are left alone")
+	assert.Equal(t, "mine.md", entries[0].Name())
+}
+
+func TestPrintSlashCommandStatus(t *testing.T) {
+	commandsDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(commandsDir, "cr-address.md"), stampSlashCommand([]byte("---\nold: true\n---\n"), "v0.1.0"), 0644))
+
+	var out bytes.Buffer
+	upToDate, err := printSlashCommandStatus(&out, commandsDir, "claude-review install --project")
+	require.NoError(t, err)
+	assert.False(t, upToDate)
+	assert.Equal(t, "Slash commands in "+commandsDir+":\n"+
+		"  /cr-address  outdated (installed by v0.1.0)\n"+
+		"  /cr-review   missing\n"+
+		"Run `claude-review install --project` to update them\n", out.String())
+
+	require.NoError(t, installSlashCommands(commandsDir, false))
+	out.Reset()
+	upToDate, err = printSlashCommandStatus(&out, commandsDir, "claude-review install")
+	require.NoError(t, err)
+	assert.True(t, upToDate)
+	assert.NotContains(t, out.String(), "Run")
+}
+
+func TestFindProjectRoot(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "docs", "plans")
+	require.NoError(t, os.MkdirAll(nested, 0755))
+
+	assert.Equal(t, nested, findProjectRoot(nested), "without a repository, the directory itself")
+
+	// A worktree or submodule has a .git file rather than a directory
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".git"), []byte("gitdir: elsewhere\n"), 0644))
+	assert.Equal(t, root, findProjectRoot(nested))
+	assert.Equal(t, filepath.Join(root, ".claude", "commands"), getProjectCommandsDir(nested))
+}
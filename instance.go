@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// serviceName identifies claude-review in the status handshake
+const serviceName = "claude-review"
+
+// errServerNotRunning means no process holds the instance lock
+var errServerNotRunning = errors.New("server is not running")
+
+// getLockFilePath returns the path to the instance lock file
+func getLockFilePath() (string, error) {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "server.lock"), nil
+}
+
+// acquireInstanceLock takes the advisory lock that makes a server the only one
+// using the data directory. The kernel releases it when the process exits,
+// however it exits, so it never goes stale the way a PID file does. The file
+// must stay open for as long as the server runs.
+func acquireInstanceLock() (*os.File, error) {
+	lockFile, err := getLockFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("server is already running (lock held: %s)", lockFile)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", lockFile, err)
+	}
+	return f, nil
+}
+
+// instanceLocked reports whether a server holds the instance lock
+func instanceLocked() bool {
+	lockFile, err := getLockFilePath()
+	if err != nil {
+		return false
+	}
+
+	f, err := os.Open(lockFile)
+	if err != nil {
+		// Never created: no server has run with this data directory
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+// handshake confirms that the server recorded in state answers at its URL and
+// really is the claude-review process that wrote the state file, not whatever
+// else now uses its port
+func handshake(state *DaemonState) error {
+	status, err := fetchDaemonStatus(state.URL)
+	if err != nil {
+		return err
+	}
+	if status.Service != serviceName {
+		return fmt.Errorf("%s is not a claude-review server", state.URL)
+	}
+	if status.PID != state.PID {
+		return fmt.Errorf("%s is served by PID %d, not %d", state.URL, status.PID, state.PID)
+	}
+	return nil
+}
+
+// waitForRunningServer returns the state of the running server once it passes
+// the handshake. A server that holds the lock may still be starting, so it is
+// given until timeout to answer.
+func waitForRunningServer(timeout time.Duration) (*DaemonState, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !instanceLocked() {
+			return nil, errServerNotRunning
+		}
+
+		state, err := readStateFile()
+		if err == nil && state == nil {
+			err = errors.New("server has not recorded its address yet")
+		}
+		if err == nil {
+			if err = handshake(state); err == nil {
+				return state, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("server holds the lock but does not answer: %w", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceLock(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+
+	assert.False(t, instanceLocked(), "no server has run yet")
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	assert.True(t, instanceLocked())
+	assert.True(t, isServerRunning())
+
+	_, err = acquireInstanceLock()
+	assert.ErrorContains(t, err, "server is already running")
+
+	// Released the way a crashed server releases it
+	require.NoError(t, lock.Close())
+	assert.False(t, instanceLocked())
+
+	lock, err = acquireInstanceLock()
+	require.NoError(t, err)
+	require.NoError(t, lock.Close())
+}
+
+func TestIsServerRunning_StaleAndRecycledPIDFiles(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	pidFile, err := getPIDFilePath()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		pid  int
+	}{
+		{"stale", 999999999},
+		// The server is gone and its PID now belongs to another process (here,
+		// the test itself)
+		{"recycled", os.Getpid()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(tt.pid)), 0644))
+
+			assert.False(t, isServerRunning())
+			_, err := os.Stat(pidFile)
+			assert.True(t, os.IsNotExist(err), "the PID file is cleaned up")
+
+			err = stopDaemon(false)
+			assert.ErrorIs(t, err, errServerNotRunning)
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	ts := newTestServer(t)
+	server := httptest.NewServer(ts.handler)
+	defer server.Close()
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"service": "something-else", "pid": os.Getpid()})
+	}))
+	defer other.Close()
+
+	tests := []struct {
+		name    string
+		state   DaemonState
+		wantErr string
+	}{
+		{"claude-review", DaemonState{PID: os.Getpid(), URL: server.URL}, ""},
+		{"another server on the port", DaemonState{PID: os.Getpid(), URL: other.URL}, "not a claude-review server"},
+		{"another claude-review process", DaemonState{PID: os.Getpid() + 1, URL: server.URL}, "is served by PID"},
+		{"nobody listening", DaemonState{PID: os.Getpid(), URL: "http://127.0.0.1:1"}, "connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshake(&tt.state)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitForRunningServer(t *testing.T) {
+	ts := newTestServer(t)
+	server := httptest.NewServer(ts.handler)
+	defer server.Close()
+
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+
+	_, err := waitForRunningServer(time.Second)
+	assert.ErrorIs(t, err, errServerNotRunning)
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	defer func() { _ = lock.Close() }()
+
+	// Locked but not listening yet
+	_, err = waitForRunningServer(200 * time.Millisecond)
+	assert.ErrorContains(t, err, "does not answer")
+
+	// Ready: the state file points at a server that passes the handshake
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		port, _ := strconv.Atoi(server.URL[len("http://127.0.0.1:"):])
+		_ = writeStateFile(port)
+	}()
+	state, err := waitForRunningServer(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), state.PID)
+}
+
+func TestStopDaemon_HungServer(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	t.Setenv("CR_DRAIN_TIMEOUT", "0s")
+	previousHandshake, previousSlack := stopHandshakeTimeout, shutdownSlack
+	t.Cleanup(func() { stopHandshakeTimeout, shutdownSlack = previousHandshake, previousSlack })
+	stopHandshakeTimeout, shutdownSlack = 100*time.Millisecond, 500*time.Millisecond
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	defer func() { _ = lock.Close() }()
+	pidFile, err := getPIDFilePath()
+	require.NoError(t, err)
+
+	// No state file and no PID file: nothing proves which process to signal
+	err = stopDaemon(false)
+	assert.ErrorContains(t, err, "no PID file to fall back on")
+	require.NoError(t, os.WriteFile(pidFile, []byte("999999999"), 0644))
+	err = stopDaemon(false)
+	assert.ErrorContains(t, err, "names no live process of this user")
+
+	// A server that neither answers nor exits on SIGTERM. The kernel would
+	// release its lock as it exits.
+	hung := exec.Command("sh", "-c", `trap "" TERM; while :; do sleep 0.1; done`)
+	require.NoError(t, hung.Start())
+	exited := make(chan struct{})
+	go func() {
+		_ = hung.Wait()
+		_ = lock.Close()
+		close(exited)
+	}()
+	t.Cleanup(func() { _ = hung.Process.Kill() })
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(hung.Process.Pid)), 0644))
+	time.Sleep(100 * time.Millisecond) // for sh to set the trap
+
+	require.NoError(t, stopDaemon(false))
+	select {
+	case <-exited:
+		t.Fatal("the server ignores SIGTERM")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, stopDaemon(true))
+	<-exited
+	assert.False(t, instanceLocked())
+}
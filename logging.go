@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/sys/unix"
+)
+
+// Defaults for the rotation of server.log
+const (
+	defaultLogMaxSize    = 10 << 20
+	defaultLogMaxBackups = 3
+)
+
+// logConfig selects what the daemon logs and where the log is rotated
+type logConfig struct {
+	level      slog.Level
+	json       bool
+	maxSize    int64
+	maxBackups int
+}
+
+// getLogConfig reads CR_LOG_LEVEL (debug, info, warn or error), CR_LOG_FORMAT
+// (text or json), CR_LOG_MAX_SIZE (e.g. "10MB") and CR_LOG_MAX_BACKUPS
+func getLogConfig() (logConfig, error) {
+	cfg := logConfig{level: slog.LevelInfo, maxSize: defaultLogMaxSize, maxBackups: defaultLogMaxBackups}
+
+	if value := os.Getenv("CR_LOG_LEVEL"); value != "" {
+		if err := cfg.level.UnmarshalText([]byte(value)); err != nil {
+			return cfg, fmt.Errorf("invalid CR_LOG_LEVEL %q", value)
+		}
+	}
+
+	switch value := os.Getenv("CR_LOG_FORMAT"); value {
+	case "", "text":
+	case "json":
+		cfg.json = true
+	default:
+		return cfg, fmt.Errorf("invalid CR_LOG_FORMAT %q", value)
+	}
+
+	if value := os.Getenv("CR_LOG_MAX_SIZE"); value != "" {
+		size, err := parseByteSize(value)
+		if err != nil || size <= 0 {
+			return cfg, fmt.Errorf("invalid CR_LOG_MAX_SIZE %q", value)
+		}
+		cfg.maxSize = size
+	}
+
+	if value := os.Getenv("CR_LOG_MAX_BACKUPS"); value != "" {
+		backups, err := strconv.Atoi(value)
+		if err != nil || backups < 0 {
+			return cfg, fmt.Errorf("invalid CR_LOG_MAX_BACKUPS %q", value)
+		}
+		cfg.maxBackups = backups
+	}
+	return cfg, nil
+}
+
+// parseByteSize parses a size such as "512", "64KB" or "10MB"
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range units {
+		if number, ok := strings.CutSuffix(value, u.suffix); ok {
+			value, multiplier = strings.TrimSpace(number), u.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// newLogger returns a logger writing cfg's format at cfg's level
+func newLogger(w io.Writer, cfg logConfig) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.level}
+	if cfg.json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// getLogFilePath returns the path to the daemon log
+func getLogFilePath() (string, error) {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "server.log"), nil
+}
+
+// setupLogging sends the server's logs through slog: to the rotated
+// server.log for a daemon, to stderr in the foreground. Remaining log.Printf
+// calls end up there too, at INFO.
+func setupLogging(cfg logConfig) (io.Closer, error) {
+	var w io.WriteCloser = nopWriteCloser{os.Stderr}
+	if daemonMode {
+		logFile, err := getLogFilePath()
+		if err != nil {
+			return nil, err
+		}
+		// Crash output written straight to stdout and stderr follows the log
+		w, err = openRotatingFile(logFile, cfg.maxSize, cfg.maxBackups, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slog.SetDefault(newLogger(w, cfg))
+	return w, nil
+}
+
+// setupCommandLogging sends the logs of a CLI command to stderr, at
+// CR_LOG_LEVEL and in CR_LOG_FORMAT, so that e.g. its SQL shows at debug. The
+// server replaces this with setupLogging; an invalid setting is reported there.
+func setupCommandLogging() {
+	cfg, err := getLogConfig()
+	if err != nil {
+		return
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg))
+	// Messages printed with log stay plain, unlike in the server
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// rotatingFile is a log file that is renamed to path.1 once it would grow past
+// maxSize, shifting older ones up to path.<maxBackups>
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	// redirectStd points stdout and stderr at the current file
+	redirectStd bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int, redirectStd bool) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, redirectStd: redirectStd}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at path for appending; f.mu must be held or f unshared
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if f.redirectStd {
+		for _, fd := range []int{1, 2} {
+			if err := unix.Dup2(int(file.Fd()), fd); err != nil {
+				_ = file.Close()
+				return fmt.Errorf("failed to redirect output to %s: %w", f.path, err)
+			}
+		}
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing records
+			fmt.Fprintf(f.file, "Failed to rotate %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups and starts a new file; f.mu must be held
+func (f *rotatingFile) rotate() error {
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+type loggerKey struct{}
+
+// requestLogger returns the logger of a request, which carries its ID
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// newRequestID returns a short random request ID
+func newRequestID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequests gives every request an ID, returned in X-Request-Id, and a
+// logger carrying it for the handlers and their database queries. Each
+// request is logged once served; polling of passiveRoutes only at DEBUG.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-Id", id)
+		logger := slog.Default().With("request_id", id)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case passiveRoutes[r.URL.Path]:
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "Request",
+			"method", r.Method,
+			"path", r.URL.RequestURI(),
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// queryLogger is implemented by stores that log their queries
+type queryLogger interface {
+	withLogger(logger *slog.Logger) Store
+}
+
+// storeWithLogger returns store logging its queries to logger, if it logs them
+func storeWithLogger(store Store, logger *slog.Logger) Store {
+	if s, ok := store.(queryLogger); ok {
+		return s.withLogger(logger)
+	}
+	return store
+}
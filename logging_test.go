@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs sends the default logger's records to the returned buffer for
+// the rest of the test
+func captureLogs(t *testing.T, cfg logConfig) *syncBuffer {
+	t.Helper()
+
+	oldLogger, oldWriter, oldFlags := slog.Default(), log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(oldLogger)
+		log.SetOutput(oldWriter)
+		log.SetFlags(oldFlags)
+	})
+
+	buf := &syncBuffer{}
+	slog.SetDefault(newLogger(buf, cfg))
+	return buf
+}
+
+func TestGetLogConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := getLogConfig()
+		require.NoError(t, err)
+		assert.Equal(t, logConfig{level: slog.LevelInfo, maxSize: defaultLogMaxSize, maxBackups: defaultLogMaxBackups}, cfg)
+	})
+
+	t.Run("set", func(t *testing.T) {
+		t.Setenv("CR_LOG_LEVEL", "debug")
+		t.Setenv("CR_LOG_FORMAT", "json")
+		t.Setenv("CR_LOG_MAX_SIZE", "64KB")
+		t.Setenv("CR_LOG_MAX_BACKUPS", "0")
+
+		cfg, err := getLogConfig()
+		require.NoError(t, err)
+		assert.Equal(t, logConfig{level: slog.LevelDebug, json: true, maxSize: 64 << 10, maxBackups: 0}, cfg)
+	})
+
+	for name, value := range map[string]string{
+		"CR_LOG_LEVEL":       "verbose",
+		"CR_LOG_FORMAT":      "xml",
+		"CR_LOG_MAX_SIZE":    "0",
+		"CR_LOG_MAX_BACKUPS": "-1",
+	} {
+		t.Run("invalid "+name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err := getLogConfig()
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for input, want := range map[string]int64{
+		"512":    512,
+		"512B":   512,
+		"64kb":   64 << 10,
+		"10MB":   10 << 20,
+		"10M":    10 << 20,
+		" 1 GB ": 1 << 30,
+	} {
+		got, err := parseByteSize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, err := parseByteSize("ten MB")
+	assert.Error(t, err)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	f, err := openRotatingFile(path, 20, 2, false)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	for i := 1; i <= 4; i++ {
+		_, err := fmt.Fprintf(f, "line %d, 15 bytes\n", i)
+		require.NoError(t, err)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "line 4, 15 bytes\n", read(path))
+	assert.Equal(t, "line 3, 15 bytes\n", read(path+".1"))
+	assert.Equal(t, "line 2, 15 bytes\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3", "only maxBackups are kept")
+
+	t.Run("without backups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "server.log")
+		require.NoError(t, os.WriteFile(path, []byte("an old line, 19 bytes\n"), 0644))
+
+		f, err := openRotatingFile(path, 20, 0, false)
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+
+		_, err = f.Write([]byte("new\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "new\n", read(path), "the size of an existing file counts")
+		assert.NoFileExists(t, path+".1")
+	})
+}
+
+func TestLogRequests(t *testing.T) {
+	buf := captureLogs(t, logConfig{level: slog.LevelDebug})
+
+	ts := newTestServer(t)
+	db, err := openSQLiteStore(filepath.Join(t.TempDir(), "comments.db"))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	store := newInstrumentedStore(db, &daemonMetrics{})
+	ts.handler, err = newServer(store, testAPIToken).routes()
+	require.NoError(t, err)
+
+	rec := ts.do(t, http.MethodGet, "/api/v1/comments?project_directory="+url.QueryEscape(ts.projectDir), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	id := rec.Header().Get("X-Request-Id")
+	require.NotEmpty(t, id)
+
+	var sqlLines, requestLines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.Contains(line, "request_id="+id) {
+			continue
+		}
+		switch {
+		case strings.Contains(line, `msg="SQL query"`):
+			sqlLines = append(sqlLines, line)
+		case strings.Contains(line, "msg=Request"):
+			requestLines = append(requestLines, line)
+		}
+	}
+	assert.NotEmpty(t, sqlLines, "the request's queries carry its ID:\n%s", buf)
+	require.Len(t, requestLines, 1, buf.String())
+	assert.Contains(t, requestLines[0], "level=INFO")
+	assert.Contains(t, requestLines[0], "method=GET")
+	assert.Contains(t, requestLines[0], "status=200")
+
+	// Polling is logged at DEBUG only
+	rec = ts.do(t, http.MethodGet, "/healthz", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, buf.String(), "level=DEBUG msg=Request request_id="+rec.Header().Get("X-Request-Id"))
+
+	t.Run("json", func(t *testing.T) {
+		buf := captureLogs(t, logConfig{level: slog.LevelInfo, json: true})
+
+		rec := ts.do(t, http.MethodGet, "/api/v1/comments?project_directory="+url.QueryEscape(ts.projectDir), nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, buf.String(), `"msg":"Request","request_id":"`+rec.Header().Get("X-Request-Id")+`"`)
+		assert.NotContains(t, buf.String(), "SQL query", "queries are logged at DEBUG")
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// logFollowInterval is how often `logs --follow` checks for new lines
+const logFollowInterval = 250 * time.Millisecond
+
+// logLineLevel returns the level of a line of server.log, in either format.
+// Lines that are not slog records, such as a panic's stack trace, have none.
+func logLineLevel(line string) (slog.Level, bool) {
+	var text string
+	if strings.HasPrefix(line, "{") {
+		var record struct {
+			Level string `json:"level"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record.Level == "" {
+			return 0, false
+		}
+		text = record.Level
+	} else {
+		var rest string
+		var ok bool
+		if rest, ok = strings.CutPrefix(line, "level="); !ok {
+			i := strings.Index(line, " level=")
+			if i < 0 {
+				return 0, false
+			}
+			rest = line[i+len(" level="):]
+		}
+		text, _, _ = strings.Cut(rest, " ")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		return 0, false
+	}
+	return level, true
+}
+
+// logFilter copies the lines of at least minLevel, and those without a level
+type logFilter struct {
+	w        io.Writer
+	minLevel slog.Level
+}
+
+func (f logFilter) writeLine(line string) error {
+	if level, ok := logLineLevel(strings.TrimRight(line, "\n")); ok && level < f.minLevel {
+		return nil
+	}
+	_, err := io.WriteString(f.w, line)
+	return err
+}
+
+// copyLines filters every complete line of r, the first continuing pending.
+// An unterminated last line is returned rather than written, as the daemon
+// may be halfway through it.
+func (f logFilter) copyLines(r *bufio.Reader, pending string) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		line, pending = pending+line, ""
+		if errors.Is(err, io.EOF) {
+			return line, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := f.writeLine(line); err != nil {
+			return "", err
+		}
+	}
+}
+
+// printLogs writes the rotated logs, oldest first, and then server.log
+func printLogs(w io.Writer, logFile string, maxBackups int, minLevel slog.Level) error {
+	filter := logFilter{w: w, minLevel: minLevel}
+
+	var paths []string
+	for i := maxBackups; i >= 1; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", logFile, i))
+	}
+	paths = append(paths, logFile)
+
+	found := false
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+
+		partial, err := filter.copyLines(bufio.NewReader(file), "")
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+		if partial != "" {
+			if err := filter.writeLine(partial + "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no log at %s (the server logs there when it runs as a daemon)", logFile)
+	}
+	return nil
+}
+
+// followLogs writes server.log and then each line appended to it until ctx is
+// done. When the daemon rotates the log, it continues with the new file.
+func followLogs(ctx context.Context, w io.Writer, logFile string, minLevel slog.Level) error {
+	filter := logFilter{w: w, minLevel: minLevel}
+
+	var file *os.File
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}()
+	var reader *bufio.Reader
+	var offset int64
+	var partial string
+
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		if file == nil {
+			var err error
+			file, err = os.Open(logFile)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if file != nil {
+				reader, offset, partial = bufio.NewReader(file), 0, ""
+			}
+		}
+
+		if file != nil {
+			var err error
+			if partial, err = filter.copyLines(reader, partial); err != nil {
+				return err
+			}
+			if pos, err := file.Seek(0, io.SeekCurrent); err == nil {
+				offset = pos - int64(reader.Buffered())
+			}
+			// Once rotated, finish the old file and go on with the new one
+			if logFileReplaced(file, logFile, offset) {
+				if partial, err = filter.copyLines(reader, partial); err != nil {
+					return err
+				}
+				if partial != "" {
+					if err := filter.writeLine(partial + "\n"); err != nil {
+						return err
+					}
+				}
+				_ = file.Close()
+				file = nil
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// logFileReplaced reports whether path no longer names the open file, or has
+// been truncated below what was read from it
+func logFileReplaced(file *os.File, path string, offset int64) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	open, err := file.Stat()
+	if err != nil {
+		return true
+	}
+	if !os.SameFile(open, current) {
+		return true
+	}
+	return current.Size() < offset
+}
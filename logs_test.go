@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLineLevel(t *testing.T) {
+	for line, want := range map[string]slog.Level{
+		`time=2026-01-02T03:04:05Z level=WARN msg="Falling back to polling"`:     slog.LevelWarn,
+		`level=DEBUG msg="SQL query"`:                                            slog.LevelDebug,
+		`{"time":"2026-01-02T03:04:05Z","level":"ERROR","msg":"Internal error"}`: slog.LevelError,
+	} {
+		level, ok := logLineLevel(line)
+		require.True(t, ok, line)
+		assert.Equal(t, want, level, line)
+	}
+
+	for _, line := range []string{
+		"goroutine 1 [running]:",
+		`{"msg":"no level"}`,
+		`time=2026-01-02T03:04:05Z msg="level=DEBUG"`,
+	} {
+		_, ok := logLineLevel(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestPrintLogs(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	require.NoError(t, os.WriteFile(logFile+".2", []byte("level=INFO msg=oldest\n"), 0644))
+	require.NoError(t, os.WriteFile(logFile+".1", []byte("level=DEBUG msg=older\n"), 0644))
+	require.NoError(t, os.WriteFile(logFile, []byte("level=WARN msg=newest\npanic: boom\nlevel=INFO msg=partial"), 0644))
+
+	var out bytes.Buffer
+	require.NoError(t, printLogs(&out, logFile, 3, slog.LevelDebug))
+	assert.Equal(t, "level=INFO msg=oldest\nlevel=DEBUG msg=older\nlevel=WARN msg=newest\npanic: boom\nlevel=INFO msg=partial\n", out.String())
+
+	out.Reset()
+	require.NoError(t, printLogs(&out, logFile, 3, slog.LevelWarn))
+	assert.Equal(t, "level=WARN msg=newest\npanic: boom\n", out.String(), "lines without a level are kept")
+
+	err := printLogs(&out, filepath.Join(t.TempDir(), "server.log"), 3, slog.LevelDebug)
+	assert.ErrorContains(t, err, "no log at")
+}
+
+func TestFollowLogs(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("level=INFO msg=first\n"), 0644))
+
+	out := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- followLogs(ctx, out, logFile, slog.LevelInfo) }()
+
+	waitForOutput := func(want string) {
+		t.Helper()
+		require.Eventually(t, func() bool { return strings.HasSuffix(out.String(), want) }, 5*time.Second, 10*time.Millisecond,
+			"got %q", out.String())
+	}
+	waitForOutput("level=INFO msg=first\n")
+
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("level=DEBUG msg=hidden\nlevel=WARN msg=sec")
+	require.NoError(t, err)
+	time.Sleep(2 * logFollowInterval)
+	_, err = f.WriteString("ond\n")
+	require.NoError(t, err)
+	waitForOutput("level=INFO msg=first\nlevel=WARN msg=second\n")
+
+	// Rotated: the last line of the old file and the new file both show
+	_, err = f.WriteString("level=INFO msg=last\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Rename(logFile, logFile+".1"))
+	require.NoError(t, os.WriteFile(logFile, []byte("level=ERROR msg=rotated\n"), 0644))
+	waitForOutput("level=WARN msg=second\nlevel=INFO msg=last\nlevel=ERROR msg=rotated\n")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("followLogs did not return")
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -20,37 +24,56 @@ func main() {
 		fmt.Println("  server                   Start the web server")
 		fmt.Println("  server --daemon          Start the web server as a background daemon")
 		fmt.Println("  server --stop            Stop the running daemon")
+		fmt.Println("  server --stop --force    Kill the daemon if it does not exit in time")
 		fmt.Println("  server --status          Check if the daemon is running")
+		fmt.Println("  server --status --verbose  Also show the daemon's metrics")
+		fmt.Println("  server --restart         Restart the daemon, e.g. after an upgrade")
+		fmt.Println("  logs [--follow] [--level L]  Show the daemon's log")
 		fmt.Println("  register                 Register the current project directory")
 		fmt.Println("  review                   Start server, register project, and show file URL")
 		fmt.Println("  address                  Show unresolved comments for a file")
 		fmt.Println("  reply                    Reply to a comment thread")
 		fmt.Println("  resolve                  Mark comments as resolved")
-		fmt.Println("  install                  Install slash commands")
-		fmt.Println("  uninstall                Uninstall slash commands")
+		fmt.Println("  install [--project]      Install slash commands, globally or into the project")
+		fmt.Println("  install --check          Report missing, outdated or edited slash commands")
+		fmt.Println("  uninstall [--project]    Uninstall slash commands, globally or from the project")
+		fmt.Println("  service install          Let systemd start the server on the first connection")
+		fmt.Println("  service uninstall        Remove the systemd units")
+		fmt.Println("  config list|get|set      Show or change settings")
+		fmt.Println("  doctor                   Diagnose the installation and suggest fixes")
 		fmt.Println("  version                  Show version information")
 		os.Exit(1)
 	}
 
+	setupCommandLogging()
+
 	cmd := os.Args[1]
 
 	switch cmd {
 	case "server":
 		runServer()
 	case "register":
-		runRegister()
+		runWithMutator(runRegister)
 	case "review":
 		runReview()
 	case "address":
-		runAddress()
+		runWithStore(runAddress)
 	case "reply":
-		runReply()
+		runWithMutator(runReply)
 	case "resolve":
-		runResolve()
+		runWithMutator(runResolve)
 	case "install":
 		runInstall()
 	case "uninstall":
 		runUninstall()
+	case "service":
+		runService()
+	case "logs":
+		runLogs()
+	case "config":
+		runConfig()
+	case "doctor":
+		runDoctor()
 	case "version":
 		runVersion()
 	default:
@@ -59,13 +82,58 @@ func main() {
 	}
 }
 
+// runWithStore opens the default store, runs the command with it and closes it afterwards.
+// A running daemon from another build is restarted first.
+func runWithStore(run func(store Store)) {
+	ensureDaemonVersion()
+
+	store, err := openStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	run(store)
+}
+
+// runWithMutator runs a command that changes projects or comments. A running
+// daemon from another build is restarted first.
+func runWithMutator(run func(m mutator)) {
+	ensureDaemonVersion()
+
+	m, closeMutator, err := openMutator()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer closeMutator()
+
+	run(m)
+}
+
+// openMutator sends changes through the daemon when it runs, and writes the
+// database directly when it does not
+func openMutator() (mutator, func(), error) {
+	if rpc, ok := dialDaemonRPC(); ok {
+		return rpc, func() {}, nil
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	return localMutator{store: store, publisher: daemonNotifier{}}, func() { _ = store.Close() }, nil
+}
+
 func runServer() {
 	// Parse server flags
 	serverCmd := flag.NewFlagSet("server", flag.ExitOnError)
 	daemon := serverCmd.Bool("daemon", false, "Run server as a daemon")
 	daemonChild := serverCmd.Bool("daemon-child", false, "Internal flag for daemon child process")
 	stop := serverCmd.Bool("stop", false, "Stop the running daemon")
+	force := serverCmd.Bool("force", false, "With --stop, kill a daemon that does not exit in time")
 	status := serverCmd.Bool("status", false, "Check daemon status")
+	verbose := serverCmd.Bool("verbose", false, "With --status, also print the daemon's metrics")
+	restart := serverCmd.Bool("restart", false, "Restart the daemon with this binary")
 
 	if err := serverCmd.Parse(os.Args[2:]); err != nil {
 		log.Fatalf("Failed to parse flags: %v", err)
@@ -73,15 +141,23 @@ func runServer() {
 
 	// Handle --stop flag
 	if *stop {
-		if err := stopDaemon(); err != nil {
+		if err := stopDaemon(*force); err != nil {
 			log.Fatalf("Failed to stop daemon: %v", err)
 		}
 		return
 	}
 
+	// Handle --restart flag
+	if *restart {
+		if err := restartDaemon(); err != nil {
+			log.Fatalf("Failed to restart daemon: %v", err)
+		}
+		return
+	}
+
 	// Handle --status flag
 	if *status {
-		if err := statusDaemon(); err != nil {
+		if err := statusDaemon(*verbose); err != nil {
 			log.Fatalf("Failed to check status: %v", err)
 		}
 		return
@@ -96,21 +172,73 @@ func runServer() {
 	}
 
 	// Actual server logic (runs in foreground or as daemon child)
+	drainTimeout, err := getDrainTimeout()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	idleTimeout, err := getIdleTimeout()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logCfg, err := getLogConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if _, err := loadConfig(""); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Under systemd socket activation, the socket is already listening
+	listener, err := activationListener()
+	if err != nil {
+		log.Fatalf("Failed to use the socket from systemd: %v", err)
+	}
+	socketActivated := listener != nil
+
+	daemonMode = *daemonChild || socketActivated
+
+	// Only one server per data directory; the lock is held until exit
+	lock, err := acquireInstanceLock()
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() { _ = lock.Close() }()
+
+	// Only the lock holder may rotate server.log
+	logOutput, err := setupLogging(logCfg)
+	if err != nil {
+		log.Fatalf("Failed to open the log: %v", err)
+	}
+	defer func() { _ = logOutput.Close() }()
+
+	// Initialize database, timing its queries for /metrics
+	db, err := openStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	store := newInstrumentedStore(db, serverMetrics)
+
+	srv := &http.Server{}
+	srv.RegisterOnShutdown(sseHub.shutdown)
+	rpcSrv := &http.Server{}
+
+	// A daemon nobody uses shuts down after a while; `review` starts it again
+	var idle <-chan struct{}
+	if daemonMode && idleTimeout > 0 {
+		idleShutdown = newIdleTimer(idleTimeout, sseHub.clientCount)
+		idle = idleShutdown.watch()
+	}
+
 	// Setup signal handlers for graceful shutdown (always, not just daemon)
-	setupSignalHandlers()
+	stopped := setupSignalHandlers(srv, rpcSrv, store, drainTimeout, idle)
 
-	if *daemonChild {
+	if daemonMode {
 		// Write PID file
 		if err := writePIDFile(); err != nil {
 			log.Fatalf("Failed to write PID file: %v", err)
 		}
 	}
 
-	// Initialize database
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-
 	// Initialize templates
 	if err := initTemplates(); err != nil {
 		log.Fatalf("Failed to load templates: %v", err)
@@ -120,49 +248,62 @@ func runServer() {
 	if err := initFileWatcher(); err != nil {
 		log.Fatalf("Failed to initialize file watcher: %v", err)
 	}
-	defer func() {
-		_ = fileWatcher.close()
-	}()
+
+	// Load (or generate) the per-install API token
+	apiToken, err := loadOrCreateAPIToken()
+	if err != nil {
+		log.Fatalf("Failed to load API token: %v", err)
+	}
 
 	// Setup router
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-
-	// HTML Routes
-	r.Get("/", handleHome)
-	r.Get("/projects/*", handleProjectFiles)
-
-	// API Routes
-	r.Post("/api/comments", handleCreateComment)
-	r.Patch("/api/comments/{id}", handleUpdateComment)
-	r.Patch("/api/comments/{id}/resolve", handleResolveThread)
-	r.Delete("/api/comments/{id}", handleDeleteComment)
-	r.Get("/api/events", handleSSE)
-	r.Post("/api/events", handleBroadcast)
-
-	// Static files from embedded FS
-	staticSubFS, err := fs.Sub(staticFS, "frontend/static")
+	server := newServer(store, apiToken)
+	router, err := server.routes()
 	if err != nil {
-		log.Fatalf("Failed to create static sub-filesystem: %v", err)
+		log.Fatalf("Failed to setup routes: %v", err)
+	}
+
+	// CLI commands send their changes through the Unix socket, making the
+	// daemon the only writer. Without it, they write the database themselves.
+	rpcListener, err := listenRPC()
+	if err != nil {
+		slog.Warn("Failed to listen on the Unix socket, CLI commands will write the database directly", "error", err)
+	} else {
+		rpcSrv.Handler = server.rpcRoutes()
+		go func() {
+			if err := rpcSrv.Serve(rpcListener); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Unix socket server failed", "error", err)
+			}
+		}()
+	}
+
+	// Start server, on another port if the preferred one is taken
+	if !socketActivated {
+		listener, err = listenLoopback()
+		if err != nil {
+			log.Fatalf("Failed to listen: %v", err)
+		}
 	}
-	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.FS(staticSubFS))))
+	port := listener.Addr().(*net.TCPAddr).Port
 
-	// Start server
-	port := os.Getenv("CR_LISTEN_PORT")
-	if port == "" {
-		port = "4779"
+	// Let CLI commands find the server
+	if err := writeStateFile(port); err != nil {
+		log.Fatalf("Failed to write state file: %v", err)
 	}
-	if !*daemonChild {
-		fmt.Printf("Starting server on http://localhost:%s\n", port)
+
+	srv.Handler = router
+	if !daemonMode {
+		fmt.Printf("Starting server on http://localhost:%d\n", port)
 	}
-	log.Printf("Server listening on port %s", port)
-	if err := http.ListenAndServe("127.0.0.1:"+port, r); err != nil {
+	slog.Info("Server listening", "port", port, "version", Version)
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed: %v", err)
 	}
+
+	// Serve returns as soon as shutdown begins
+	<-stopped
 }
 
-func runRegister() {
+func runRegister(m mutator) {
 	// Parse flags
 	registerCmd := flag.NewFlagSet("register", flag.ExitOnError)
 	projectDir := registerCmd.String("project", "", "Project directory (defaults to current directory)")
@@ -179,15 +320,14 @@ func runRegister() {
 		}
 		*projectDir = cwd
 	}
-
-	// Initialize database
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	absDir, err := filepath.Abs(*projectDir)
+	if err != nil {
+		log.Fatalf("Failed to resolve project directory: %v", err)
 	}
+	*projectDir = absDir
 
 	// Register project
-	_, err := createProject(*projectDir)
-	if err != nil {
+	if err := m.registerProject(*projectDir); err != nil {
 		log.Fatalf("Failed to register project: %v", err)
 	}
 
@@ -212,6 +352,11 @@ func runReview() {
 		}
 		*projectDir = cwd
 	}
+	absDir, err := filepath.Abs(*projectDir)
+	if err != nil {
+		log.Fatalf("Failed to resolve project directory: %v", err)
+	}
+	*projectDir = absDir
 
 	if *filePath == "" {
 		fmt.Println("Error: --file flag is required")
@@ -221,39 +366,41 @@ func runReview() {
 	// Remove @ prefix if present
 	*filePath = strings.TrimPrefix(*filePath, "@")
 
-	// Step 1: Start daemon if not running
+	// Step 1: Start daemon if not running, or restart one from another build
+	ensureDaemonVersion()
 	if !isServerRunning() {
-		if err := daemonize(); err != nil {
+		// A concurrent command may have started it in the meantime
+		if err := daemonize(); err != nil && !isServerRunning() {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}
 
-	// Step 2: Initialize database and register project
-	if err := initDB(); err != nil {
+	// Step 2: Register project, through the daemon that now runs
+	m, closeMutator, err := openMutator()
+	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-
-	_, err := createProject(*projectDir)
-	if err != nil {
+	defer closeMutator()
+	if err := m.registerProject(*projectDir); err != nil {
 		log.Fatalf("Failed to register project: %v", err)
 	}
 
-	// Step 3: Output URL
-	port := os.Getenv("CR_LISTEN_PORT")
-	if port == "" {
-		port = "4779"
+	// Step 3: Output URL, once the server answers where it said it would
+	state, err := waitForRunningServer(10 * time.Second)
+	if err != nil {
+		log.Fatalf("Failed to reach server: %v", err)
 	}
 
 	reviewURL := fmt.Sprintf(
-		"http://localhost:%s/projects%s/%s",
-		port,
+		"%s/projects%s/%s",
+		state.URL,
 		escapePathComponents(*projectDir),
 		escapePathComponents(*filePath),
 	)
 	fmt.Printf("Open this URL in your browser to start reviewing %s:\n\n%s\n", *filePath, reviewURL)
 }
 
-func runAddress() {
+func runAddress(store Store) {
 	// Parse flags
 	reviewCmd := flag.NewFlagSet("address", flag.ExitOnError)
 	projectDir := reviewCmd.String("project", "", "Project directory")
@@ -279,16 +426,11 @@ func runAddress() {
 	// Remove @ prefix if present
 	*filePath = strings.TrimPrefix(*filePath, "@")
 
-	// Initialize database
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-
 	// Debug: show what we're searching for
 	log.Printf("Searching for comments: project_directory=%q, file_path=%q", *projectDir, *filePath)
 
 	// Get unresolved comments
-	comments, err := getComments(*projectDir, *filePath, false)
+	comments, err := store.GetComments(*projectDir, *filePath, false)
 	if err != nil {
 		log.Fatalf("Failed to get comments: %v", err)
 	}
@@ -377,7 +519,7 @@ func groupCommentsByThread(comments []Comment) [][]Comment {
 	return threads
 }
 
-func runReply() {
+func runReply(m mutator) {
 	// Parse flags
 	replyCmd := flag.NewFlagSet("reply", flag.ExitOnError)
 	commentID := replyCmd.Int("comment-id", 0, "ID of the comment to reply to")
@@ -397,47 +539,23 @@ func runReply() {
 		os.Exit(1)
 	}
 
-	// Initialize database
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-
-	// Get the comment to reply to
-	parentComment, err := getCommentByID(*commentID)
-	if err != nil {
-		log.Fatalf("Failed to get comment: %v", err)
-	}
-	if parentComment == nil {
+	// Create the reply; viewers are notified of it
+	_, err := m.reply(*commentID, *message)
+	switch {
+	case errors.Is(err, errCommentNotFound):
 		fmt.Printf("Error: comment %d not found\n", *commentID)
 		os.Exit(1)
-	}
-
-	// Ensure we're replying to a root comment (not a reply)
-	if parentComment.RootID != nil {
-		fmt.Println("Error: can only reply to root comments, not to replies")
+	case errors.Is(err, errReplyToReply):
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
-	}
-
-	// Create the reply
-	reply := &Comment{
-		ProjectDirectory: parentComment.ProjectDirectory,
-		FilePath:         parentComment.FilePath,
-		CommentText:      *message,
-		Author:           "agent",
-		RootID:           &parentComment.ID,
-	}
-
-	if err := createComment(reply); err != nil {
-		log.Fatalf("Failed to create reply: %v", err)
+	case err != nil:
+		log.Fatalf("Failed to reply: %v", err)
 	}
 
 	fmt.Printf("Reply added to comment %d\n", *commentID)
-
-	// Notify server about the new reply (if server is running)
-	notifyServerCommentsChanged(parentComment.ProjectDirectory, parentComment.FilePath)
 }
 
-func runResolve() {
+func runResolve(m mutator) {
 	// Parse flags
 	resolveCmd := flag.NewFlagSet("resolve", flag.ExitOnError)
 	projectDir := resolveCmd.String("project", "", "Project directory")
@@ -448,30 +566,13 @@ func runResolve() {
 		log.Fatalf("Failed to parse flags: %v", err)
 	}
 
-	// Initialize database
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-
 	// Handle comment-id mode
 	if *commentID != 0 {
-		comment, err := getCommentByID(*commentID)
-		if err != nil {
-			log.Fatalf("Failed to get comment: %v", err)
-		}
-		if comment == nil {
+		rootID, count, err := m.resolveThread(*commentID)
+		if errors.Is(err, errCommentNotFound) {
 			fmt.Printf("Error: comment %d not found\n", *commentID)
 			os.Exit(1)
 		}
-
-		// Get the root comment ID
-		rootID := *commentID
-		if comment.RootID != nil {
-			rootID = *comment.RootID
-		}
-
-		// Resolve the thread
-		count, err := resolveThread(rootID, "user")
 		if err != nil {
 			log.Fatalf("Failed to resolve thread: %v", err)
 		}
@@ -480,9 +581,6 @@ func runResolve() {
 			fmt.Printf("Thread %d was already resolved\n", rootID)
 		} else {
 			fmt.Printf("Resolved thread %d (%d comment(s))\n", rootID, count)
-
-			// Notify server
-			notifyServerCommentsChanged(comment.ProjectDirectory, comment.FilePath)
 		}
 		return
 	}
@@ -504,18 +602,10 @@ func runResolve() {
 	// Remove @ prefix if present
 	*filePath = strings.TrimPrefix(*filePath, "@")
 
-	// Debug: show what we're searching for
-	log.Printf("Searching for comments: project_directory=%q, file_path=%q", *projectDir, *filePath)
+	// Debug: show what we're resolving
+	log.Printf("Resolving comments: project_directory=%q, file_path=%q", *projectDir, *filePath)
 
-	// First check if there are any unresolved comments
-	comments, err := getComments(*projectDir, *filePath, false)
-	if err != nil {
-		log.Fatalf("Failed to get comments: %v", err)
-	}
-	log.Printf("Found %d unresolved comments", len(comments))
-
-	// Resolve comments
-	count, err := resolveComments(*projectDir, *filePath)
+	count, err := m.resolveFile(*projectDir, *filePath)
 	if err != nil {
 		log.Fatalf("Failed to resolve comments: %v", err)
 	}
@@ -524,24 +614,216 @@ func runResolve() {
 		fmt.Printf("No unresolved comments found for %s\n", *filePath)
 	} else {
 		fmt.Printf("Resolved %d comment(s) for %s\n", count, *filePath)
-
-		// Notify server about resolved comments (if server is running)
-		notifyServerCommentsChanged(*projectDir, *filePath)
 	}
 }
 
 func runInstall() {
-	if err := installSlashCommands(); err != nil {
+	installCmd := flag.NewFlagSet("install", flag.ExitOnError)
+	project := installCmd.Bool("project", false, "Install into the current project's .claude/commands instead of ~/.claude/commands")
+	force := installCmd.Bool("force", false, "Replace slash commands that were edited since they were installed")
+	check := installCmd.Bool("check", false, "Only report missing, outdated or edited slash commands")
+
+	if err := installCmd.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	commandsDir, err := getCommandsDir()
+	if err != nil {
+		log.Fatalf("Failed to locate the slash commands: %v", err)
+	}
+	usage := "claude-review install"
+	if *project {
+		cwd, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Failed to get current directory: %v", err)
+		}
+		commandsDir = getProjectCommandsDir(cwd)
+		usage += " --project"
+	}
+
+	if *check {
+		upToDate, err := printSlashCommandStatus(os.Stdout, commandsDir, usage)
+		if err != nil {
+			log.Fatalf("Failed to check slash commands: %v", err)
+		}
+		if !upToDate {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := installSlashCommands(commandsDir, *force); err != nil {
 		log.Fatalf("Failed to install slash commands: %v", err)
 	}
 }
 
 func runUninstall() {
-	if err := uninstallSlashCommands(); err != nil {
+	uninstallCmd := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	project := uninstallCmd.Bool("project", false, "Remove the slash commands from the current project's .claude/commands instead of ~/.claude/commands")
+
+	if err := uninstallCmd.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	commandsDir, err := getCommandsDir()
+	if err != nil {
+		log.Fatalf("Failed to locate the slash commands: %v", err)
+	}
+	if *project {
+		cwd, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Failed to get current directory: %v", err)
+		}
+		commandsDir = getProjectCommandsDir(cwd)
+	}
+
+	if err := uninstallSlashCommands(commandsDir); err != nil {
 		log.Fatalf("Failed to uninstall slash commands: %v", err)
 	}
 }
 
+func runService() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: claude-review service install|uninstall")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "install":
+		if err := installService(); err != nil {
+			log.Fatalf("Failed to install service: %v", err)
+		}
+	case "uninstall":
+		if err := uninstallService(); err != nil {
+			log.Fatalf("Failed to uninstall service: %v", err)
+		}
+	default:
+		fmt.Printf("Unknown service command: %s\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+func runLogs() {
+	logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := logsCmd.Bool("follow", false, "Keep printing lines as the daemon logs them")
+	levelName := logsCmd.String("level", "debug", "Only show records of at least this level (debug, info, warn, error)")
+
+	if err := logsCmd.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(*levelName)); err != nil {
+		log.Fatalf("Invalid level %q, want debug, info, warn or error", *levelName)
+	}
+	logCfg, err := getLogConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logFile, err := getLogFilePath()
+	if err != nil {
+		log.Fatalf("Failed to locate the log: %v", err)
+	}
+
+	if !*follow {
+		if err := printLogs(os.Stdout, logFile, logCfg.maxBackups, minLevel); err != nil {
+			log.Fatalf("Failed to read the log: %v", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := followLogs(ctx, os.Stdout, logFile, minLevel); err != nil {
+		log.Fatalf("Failed to read the log: %v", err)
+	}
+}
+
+func printConfigUsage() {
+	fmt.Println("Usage: claude-review config list [--project DIR]")
+	fmt.Println("       claude-review config get [--project DIR] KEY")
+	fmt.Println("       claude-review config set [--project DIR] KEY VALUE")
+	fmt.Println("\nWithout --project, set changes the global config; list and get show the settings")
+	fmt.Println("for the current directory. Lists are comma-separated.")
+	fmt.Println("\nKeys:")
+	for _, key := range configKeys {
+		fmt.Printf("  %-15s %s\n", key.name, key.help)
+	}
+}
+
+func runConfig() {
+	if len(os.Args) < 3 {
+		printConfigUsage()
+		os.Exit(1)
+	}
+
+	sub := os.Args[2]
+	configCmd := flag.NewFlagSet("config "+sub, flag.ExitOnError)
+	projectDir := configCmd.String("project", "", "Project directory (default: current directory, for list and get)")
+	if err := configCmd.Parse(os.Args[3:]); err != nil {
+		log.Fatalf("Failed to parse flags: %v", err)
+	}
+	args := configCmd.Args()
+
+	project := *projectDir
+	if project == "" && sub != "set" {
+		project = "."
+	}
+	if project != "" {
+		abs, err := filepath.Abs(project)
+		if err != nil {
+			log.Fatalf("Failed to resolve project directory: %v", err)
+		}
+		project = abs
+	}
+
+	switch {
+	case sub == "list" && len(args) == 0:
+		cfg, err := loadConfig(project)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		printConfig(os.Stdout, cfg)
+	case sub == "get" && len(args) == 1:
+		key, err := lookupConfigKey(args[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cfg, err := loadConfig(project)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		fmt.Println(formatConfigValue(cfg.get(key)))
+	case sub == "set" && len(args) == 2:
+		path, err := setConfig(args[0], args[1], project)
+		if err != nil {
+			log.Fatalf("Failed to set %s: %v", args[0], err)
+		}
+		fmt.Printf("Set %s in %s\n", args[0], path)
+		if args[0] == "port" {
+			fmt.Println("Restart the server for it to take effect: claude-review server --restart")
+		}
+	default:
+		printConfigUsage()
+		os.Exit(1)
+	}
+}
+
+func runDoctor() {
+	warnings, failures := runDoctorChecks(os.Stdout, doctorChecks)
+
+	fmt.Println()
+	switch {
+	case failures > 0:
+		fmt.Printf("%d check(s) failed, %d warning(s)\n", failures, warnings)
+		os.Exit(1)
+	case warnings > 0:
+		fmt.Printf("No failures, %d warning(s)\n", warnings)
+	default:
+		fmt.Println("Everything looks fine")
+	}
+}
+
 func runVersion() {
 	fmt.Println(Version)
 }
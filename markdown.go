@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting/v2"
@@ -182,8 +183,15 @@ func addLineAttributesToCodeBlocks(html []byte, codeBlocks []*codeBlockInfo) []b
 	return result
 }
 
-// RenderMarkdownWithLineNumbers renders markdown to HTML with line number attributes
-func RenderMarkdownWithLineNumbers(source []byte) ([]byte, error) {
+// defaultTheme is the Chroma style of code blocks unless the config sets one
+const defaultTheme = "friendly"
+
+// RenderMarkdownWithLineNumbers renders markdown to HTML with line number
+// attributes, highlighting code blocks in the given Chroma style, and
+// sanitizes it with the policy of policy
+func RenderMarkdownWithLineNumbers(source []byte, theme string, policy htmlSource) ([]byte, error) {
+	defer serverMetrics.render.observe("document", time.Now())
+
 	transformer := &LineAttributeTransformer{}
 
 	md := goldmark.New(
@@ -191,11 +199,11 @@ func RenderMarkdownWithLineNumbers(source []byte) ([]byte, error) {
 			extension.GFM,
 			&LineAttributeExtension{transformer: transformer},
 			highlighting.NewHighlighting(
-				highlighting.WithStyle("friendly"),
+				highlighting.WithStyle(theme),
 			),
 		),
 		goldmark.WithRendererOptions(
-			gmhtml.WithUnsafe(), // Allow raw HTML
+			gmhtml.WithUnsafe(), // Allow raw HTML, the sanitizer below decides what survives
 		),
 	)
 
@@ -207,21 +215,22 @@ func RenderMarkdownWithLineNumbers(source []byte) ([]byte, error) {
 	// Post-process HTML to add data-line-* attributes to code blocks
 	html := addLineAttributesToCodeBlocks(buf.Bytes(), transformer.codeBlocks)
 
-	return html, nil
+	return sanitizeHTML(policy, html), nil
 }
 
-// RenderMarkdown renders markdown to HTML without line number attributes
-func RenderMarkdown(source []byte) ([]byte, error) {
+// RenderMarkdown renders comment markdown to HTML without line number attributes.
+// Raw HTML in comments is not rendered at all, and the output is sanitized with the
+// strict comment policy.
+func RenderMarkdown(source []byte, theme string) ([]byte, error) {
+	defer serverMetrics.render.observe("comment", time.Now())
+
 	md := goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
 			highlighting.NewHighlighting(
-				highlighting.WithStyle("friendly"),
+				highlighting.WithStyle(theme),
 			),
 		),
-		goldmark.WithRendererOptions(
-			gmhtml.WithUnsafe(), // Allow raw HTML
-		),
 	)
 
 	var buf bytes.Buffer
@@ -229,5 +238,5 @@ func RenderMarkdown(source []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	return sanitizeHTML(sourceComment, buf.Bytes()), nil
 }
@@ -27,14 +27,14 @@ func TestHTMLEscapingInCodeBlocks(t *testing.T) {
 		{
 			name:     "quotes",
 			markdown: "```\n\"quoted\"\n```",
-			want:     "&quot;quoted&quot;",
-			notWant:  "",
+			want:     "&#34;quoted&#34;", // the sanitizer re-encodes &quot; numerically
+			notWant:  "<code>\"quoted\"",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown))
+			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown), defaultTheme, sourceDocument)
 			if err != nil {
 				t.Fatalf("RenderMarkdownWithLineNumbers failed: %v", err)
 			}
@@ -77,7 +77,7 @@ func TestInlineCodeLineNumbers(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown))
+			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown), defaultTheme, sourceDocument)
 			if err != nil {
 				t.Fatalf("RenderMarkdownWithLineNumbers failed: %v", err)
 			}
@@ -132,7 +132,7 @@ func TestSyntaxHighlighting(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown))
+			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown), defaultTheme, sourceDocument)
 			if err != nil {
 				t.Fatalf("RenderMarkdownWithLineNumbers failed: %v", err)
 			}
@@ -206,7 +206,7 @@ func TestCodeBlockLineNumbers(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown))
+			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown), defaultTheme, sourceDocument)
 			if err != nil {
 				t.Fatalf("RenderMarkdownWithLineNumbers failed: %v", err)
 			}
@@ -249,7 +249,7 @@ func TestCustomWrapperRenderer(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown))
+			html, err := RenderMarkdownWithLineNumbers([]byte(tt.markdown), defaultTheme, sourceDocument)
 			if err != nil {
 				t.Fatalf("RenderMarkdownWithLineNumbers failed: %v", err)
 			}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store implementation. It mirrors the semantics of
+// SQLiteStore (ordering, idempotent project registration) and is intended for tests.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	projects map[string]Project
+	comments map[int]Comment
+	nextID   int
+}
+
+func newMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		projects: make(map[string]Project),
+		comments: make(map[int]Comment),
+		nextID:   1,
+	}
+}
+
+func (s *MemoryStore) CreateProject(directory string) (*Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, exists := s.projects[directory]
+	if !exists {
+		p = Project{Directory: directory, CreatedAt: time.Now()}
+		s.projects[directory] = p
+	}
+
+	return &p, nil
+}
+
+func (s *MemoryStore) GetProject(directory string) (*Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, exists := s.projects[directory]
+	if !exists {
+		return nil, nil
+	}
+
+	return &p, nil
+}
+
+func (s *MemoryStore) GetAllProjects() ([]Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var projects []Project
+	for _, p := range s.projects {
+		projects = append(projects, p)
+	}
+
+	sort.SliceStable(projects, func(i, j int) bool {
+		return projects[i].CreatedAt.After(projects[j].CreatedAt)
+	})
+
+	return projects, nil
+}
+
+func (s *MemoryStore) GetFileSummaries(projectDir string) ([]FileSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byFile := make(map[string]*FileSummary)
+	for _, c := range s.comments {
+		if c.ProjectDirectory != projectDir {
+			continue
+		}
+		fs, exists := byFile[c.FilePath]
+		if !exists {
+			fs = &FileSummary{FilePath: c.FilePath}
+			byFile[c.FilePath] = fs
+		}
+		switch {
+		case c.ResolvedAt != nil:
+			fs.ResolvedComments++
+		case c.RootID == nil:
+			fs.UnresolvedThreads++
+			fs.UnresolvedComments++
+		default:
+			fs.UnresolvedComments++
+		}
+	}
+
+	var summaries []FileSummary
+	for _, fs := range byFile {
+		summaries = append(summaries, *fs)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].FilePath < summaries[j].FilePath
+	})
+
+	return summaries, nil
+}
+
+func (s *MemoryStore) CreateComment(c *Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = s.nextID
+	c.CreatedAt = time.Now()
+	s.nextID++
+
+	s.comments[c.ID] = *c
+	return nil
+}
+
+func (s *MemoryStore) GetComments(projectDir, filePath string, resolved bool) ([]Comment, error) {
+	status := commentStatusUnresolved
+	if resolved {
+		status = commentStatusResolved
+	}
+
+	return s.ListComments(CommentFilter{
+		ProjectDirectory: projectDir,
+		FilePath:         filePath,
+		Status:           status,
+	})
+}
+
+func (s *MemoryStore) ListComments(filter CommentFilter) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var comments []Comment
+	for _, c := range s.comments {
+		if filter.matches(c) {
+			comments = append(comments, c)
+		}
+	}
+
+	// Same ordering as SQLite: by file, grouped by thread, then chronological within a thread
+	sort.SliceStable(comments, func(i, j int) bool {
+		if comments[i].FilePath != comments[j].FilePath {
+			return comments[i].FilePath < comments[j].FilePath
+		}
+		ri, rj := threadRootID(comments[i]), threadRootID(comments[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if !comments[i].CreatedAt.Equal(comments[j].CreatedAt) {
+			return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+		}
+		return comments[i].ID < comments[j].ID
+	})
+
+	return comments, nil
+}
+
+func (s *MemoryStore) GetCommentByID(commentID int) (*Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, exists := s.comments[commentID]
+	if !exists {
+		return nil, nil
+	}
+
+	return &c, nil
+}
+
+func (s *MemoryStore) UpdateComment(commentID int, commentText string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, exists := s.comments[commentID]; exists {
+		c.CommentText = commentText
+		s.comments[commentID] = c
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteComment(commentID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, c := range s.comments {
+		if id == commentID || (c.RootID != nil && *c.RootID == commentID) {
+			delete(s.comments, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetThread(rootCommentID int) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var thread []Comment
+	for _, c := range s.comments {
+		if threadRootID(c) == rootCommentID {
+			thread = append(thread, c)
+		}
+	}
+
+	// Root first, then replies in chronological order
+	sort.SliceStable(thread, func(i, j int) bool {
+		if (thread[i].ID == rootCommentID) != (thread[j].ID == rootCommentID) {
+			return thread[i].ID == rootCommentID
+		}
+		if !thread[i].CreatedAt.Equal(thread[j].CreatedAt) {
+			return thread[i].CreatedAt.Before(thread[j].CreatedAt)
+		}
+		return thread[i].ID < thread[j].ID
+	})
+
+	return thread, nil
+}
+
+func (s *MemoryStore) HasReplies(commentID int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.comments {
+		if c.RootID != nil && *c.RootID == commentID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStore) ResolveThread(rootCommentID int, resolvedBy string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resolveWhere(resolvedBy, func(c Comment) bool {
+		return threadRootID(c) == rootCommentID
+	}), nil
+}
+
+func (s *MemoryStore) ResolveComments(projectDir, filePath string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resolveWhere("user", func(c Comment) bool {
+		return c.ProjectDirectory == projectDir && c.FilePath == filePath
+	}), nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// resolveWhere marks all unresolved comments matching the predicate as resolved.
+// The caller must hold the write lock.
+func (s *MemoryStore) resolveWhere(resolvedBy string, match func(Comment) bool) int {
+	now := time.Now()
+	count := 0
+
+	for id, c := range s.comments {
+		if c.ResolvedAt != nil || !match(c) {
+			continue
+		}
+		by := resolvedBy
+		c.ResolvedAt = &now
+		c.ResolvedBy = &by
+		s.comments[id] = c
+		count++
+	}
+
+	return count
+}
+
+// threadRootID returns the ID of the root comment of the thread a comment belongs to
+func threadRootID(c Comment) int {
+	if c.RootID != nil {
+		return *c.RootID
+	}
+	return c.ID
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// LatencySummary is a latency histogram. Buckets are cumulative, as in
+// Prometheus: each counts the observations of at most LE seconds.
+type LatencySummary struct {
+	Count      uint64          `json:"count"`
+	SumSeconds float64         `json:"sum_seconds"`
+	Buckets    []LatencyBucket `json:"buckets"`
+}
+
+// LatencyBucket is one bucket of a LatencySummary
+type LatencyBucket struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// mean returns the average latency
+func (s LatencySummary) mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return time.Duration(s.SumSeconds / float64(s.Count) * float64(time.Second))
+}
+
+// latencyHistogram records durations in latencyBuckets
+type latencyHistogram struct {
+	counts []uint64 // Per bucket, plus one for slower observations
+	sum    float64
+	count  uint64
+}
+
+// latencyFamily is a set of latency histograms told apart by one label
+type latencyFamily struct {
+	mu      sync.Mutex
+	byLabel map[string]*latencyHistogram
+}
+
+// observe records the time elapsed since start under label
+func (f *latencyFamily) observe(label string, start time.Time) {
+	seconds := time.Since(start).Seconds()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.byLabel == nil {
+		f.byLabel = make(map[string]*latencyHistogram)
+	}
+	h := f.byLabel[label]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		f.byLabel[label] = h
+	}
+
+	h.counts[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	h.sum += seconds
+	h.count++
+}
+
+// snapshot returns the histograms by label
+func (f *latencyFamily) snapshot() map[string]LatencySummary {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	summaries := make(map[string]LatencySummary, len(f.byLabel))
+	for label, h := range f.byLabel {
+		s := LatencySummary{Count: h.count, SumSeconds: h.sum, Buckets: make([]LatencyBucket, len(latencyBuckets))}
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			s.Buckets[i] = LatencyBucket{LE: le, Count: cumulative}
+		}
+		summaries[label] = s
+	}
+	return summaries
+}
+
+// daemonMetrics holds the counters and histograms the daemon accumulates.
+// Gauges such as the SSE client count are read when metrics are collected.
+type daemonMetrics struct {
+	// render is labeled by what was rendered: document or comment
+	render latencyFamily
+	// dbQuery is labeled by Store method
+	dbQuery latencyFamily
+
+	commentsCreated  atomic.Int64
+	commentsResolved atomic.Int64
+}
+
+var serverMetrics = &daemonMetrics{}
+
+// MetricsSnapshot is what /metrics exposes, and `server --status --verbose`
+// prints
+type MetricsSnapshot struct {
+	SSEClients            int                       `json:"sse_clients"`
+	SlowClientDisconnects int64                     `json:"slow_client_disconnects"`
+	WatchedFiles          int                       `json:"watched_files"`
+	WatchedDirectories    int                       `json:"watched_directories"`
+	CommentsCreated       int64                     `json:"comments_created"`
+	CommentsResolved      int64                     `json:"comments_resolved"`
+	RenderLatency         map[string]LatencySummary `json:"render_latency"`
+	DBQueryLatency        map[string]LatencySummary `json:"db_query_latency"`
+}
+
+// collectMetrics reads the current value of every metric
+func collectMetrics() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		SSEClients:            sseHub.clientCount(),
+		SlowClientDisconnects: sseHub.slowClientDisconnects.Load(),
+		CommentsCreated:       serverMetrics.commentsCreated.Load(),
+		CommentsResolved:      serverMetrics.commentsResolved.Load(),
+		RenderLatency:         serverMetrics.render.snapshot(),
+		DBQueryLatency:        serverMetrics.dbQuery.snapshot(),
+	}
+	if fileWatcher != nil {
+		watcher := fileWatcher.status()
+		snapshot.WatchedFiles = watcher.WatchedFiles
+		snapshot.WatchedDirectories = watcher.WatchedDirectories
+	}
+	return snapshot
+}
+
+// writePrometheus writes a snapshot in the Prometheus text exposition format
+func writePrometheus(w io.Writer, s MetricsSnapshot) {
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("claude_review_sse_clients", "gauge", "Open SSE streams.", s.SSEClients)
+	metric("claude_review_sse_slow_client_disconnects_total", "counter",
+		"SSE clients disconnected because their event buffer was full.", s.SlowClientDisconnects)
+	metric("claude_review_watched_files", "gauge", "Files watched for viewers.", s.WatchedFiles)
+	metric("claude_review_watched_directories", "gauge", "Directories watched for changes.", s.WatchedDirectories)
+	metric("claude_review_comments_created_total", "counter", "Comments and replies created.", s.CommentsCreated)
+	metric("claude_review_comments_resolved_total", "counter", "Comments resolved.", s.CommentsResolved)
+
+	writeHistograms(w, "claude_review_render_duration_seconds", "Time to render Markdown to HTML.", "kind", s.RenderLatency)
+	writeHistograms(w, "claude_review_db_query_duration_seconds", "Time spent in database queries.", "operation", s.DBQueryLatency)
+}
+
+// writeHistograms writes a family of histograms, one per label value
+func writeHistograms(w io.Writer, name, help, label string, byLabel map[string]LatencySummary) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	values := make([]string, 0, len(byLabel))
+	for value := range byLabel {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		s := byLabel[value]
+		labels := fmt.Sprintf("%s=%q", label, value)
+		for _, b := range s.Buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, strconv.FormatFloat(b.LE, 'g', -1, 64), b.Count)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, s.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, s.SumSeconds)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, s.Count)
+	}
+}
+
+// printMetrics writes a snapshot for people, one metric per line
+func printMetrics(w io.Writer, s MetricsSnapshot) {
+	fmt.Fprintf(w, "  SSE clients: %d\n", s.SSEClients)
+	fmt.Fprintf(w, "  Slow SSE clients disconnected: %d\n", s.SlowClientDisconnects)
+	fmt.Fprintf(w, "  Watched files: %d (in %d directories)\n", s.WatchedFiles, s.WatchedDirectories)
+	fmt.Fprintf(w, "  Comments created: %d\n", s.CommentsCreated)
+	fmt.Fprintf(w, "  Comments resolved: %d\n", s.CommentsResolved)
+	printLatencies(w, "Render", s.RenderLatency)
+	printLatencies(w, "DB query", s.DBQueryLatency)
+}
+
+// printLatencies writes the count and mean of each histogram in a family
+func printLatencies(w io.Writer, what string, byLabel map[string]LatencySummary) {
+	labels := make([]string, 0, len(byLabel))
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		s := byLabel[label]
+		fmt.Fprintf(w, "  %s latency (%s): %d, mean %s\n", what, label, s.Count, s.mean().Round(time.Microsecond))
+	}
+}
+
+// handleMetrics serves the metrics for Prometheus
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheus(w, collectMetrics())
+}
+
+// handleHealthz reports that the daemon is up and serving requests
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "ok\n")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyFamily(t *testing.T) {
+	var f latencyFamily
+	now := time.Now()
+	f.observe("GetThread", now.Add(-300*time.Microsecond))
+	f.observe("GetThread", now.Add(-20*time.Millisecond))
+	f.observe("GetThread", now.Add(-10*time.Second))
+
+	s := f.snapshot()["GetThread"]
+	assert.Equal(t, uint64(3), s.Count)
+	assert.InDelta(t, 10.0203, s.SumSeconds, 0.01)
+	require.Len(t, s.Buckets, len(latencyBuckets))
+	assert.Equal(t, LatencyBucket{LE: 0.0005, Count: 1}, s.Buckets[0])
+	assert.Equal(t, LatencyBucket{LE: 0.025, Count: 2}, s.Buckets[5])
+	assert.Equal(t, LatencyBucket{LE: 2.5, Count: 2}, s.Buckets[len(s.Buckets)-1], "slower than every bucket")
+	assert.InDelta(t, float64(3340*time.Millisecond), float64(s.mean()), float64(10*time.Millisecond))
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	metrics := &daemonMetrics{}
+	store := newInstrumentedStore(newMemoryStore(), metrics)
+	projectDir := t.TempDir()
+
+	root := &Comment{ProjectDirectory: projectDir, FilePath: "doc.md", CommentText: "Root", Author: "user"}
+	require.NoError(t, store.CreateComment(root))
+	require.NoError(t, store.CreateComment(&Comment{ProjectDirectory: projectDir, FilePath: "doc.md", CommentText: "Reply", Author: "agent", RootID: &root.ID}))
+	require.NoError(t, store.CreateComment(&Comment{ProjectDirectory: projectDir, FilePath: "other.md", CommentText: "Other", Author: "user"}))
+
+	_, err := store.ResolveThread(root.ID, "user")
+	require.NoError(t, err)
+	_, err = store.ResolveThread(root.ID, "user")
+	require.NoError(t, err)
+	_, err = store.ResolveComments(projectDir, "other.md")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(3), metrics.commentsCreated.Load())
+	assert.Equal(t, int64(3), metrics.commentsResolved.Load(), "resolving twice counts once")
+	queries := metrics.dbQuery.snapshot()
+	assert.Equal(t, uint64(3), queries["CreateComment"].Count)
+	assert.Equal(t, uint64(2), queries["ResolveThread"].Count)
+	assert.Equal(t, uint64(1), queries["ResolveComments"].Count)
+}
+
+func TestHandleMetrics(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Rendering the viewer is measured
+	rec := ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/doc.md", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = ts.do(t, http.MethodGet, "/metrics", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE claude_review_sse_clients gauge\nclaude_review_sse_clients 0\n",
+		"# TYPE claude_review_sse_slow_client_disconnects_total counter\n",
+		"# TYPE claude_review_watched_files gauge\n",
+		"# TYPE claude_review_comments_created_total counter\n",
+		"# TYPE claude_review_comments_resolved_total counter\n",
+		"# TYPE claude_review_render_duration_seconds histogram\n",
+		`claude_review_render_duration_seconds_bucket{kind="document",le="0.0005"} `,
+		`claude_review_render_duration_seconds_bucket{kind="document",le="+Inf"} `,
+		`claude_review_render_duration_seconds_count{kind="document"} `,
+		"# TYPE claude_review_db_query_duration_seconds histogram\n",
+	} {
+		assert.Contains(t, body, want)
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodGet, "/healthz", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+}
+
+func TestPrintMetrics(t *testing.T) {
+	var out bytes.Buffer
+	printMetrics(&out, MetricsSnapshot{
+		SSEClients:         2,
+		WatchedFiles:       3,
+		WatchedDirectories: 1,
+		CommentsCreated:    5,
+		DBQueryLatency: map[string]LatencySummary{
+			"GetThread": {Count: 4, SumSeconds: 0.002},
+		},
+	})
+
+	assert.Contains(t, out.String(), "  SSE clients: 2\n")
+	assert.Contains(t, out.String(), "  Watched files: 3 (in 1 directories)\n")
+	assert.Contains(t, out.String(), "  Comments created: 5\n")
+	assert.Contains(t, out.String(), "  DB query latency (GetThread): 4, mean 500µs\n")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors CLI commands report to the user rather than as failures
+var (
+	errCommentNotFound = errors.New("comment not found")
+	errReplyToReply    = errors.New("can only reply to root comments, not to replies")
+)
+
+// mutator makes the changes CLI commands ask for. While the daemon runs, they
+// go through its socket (rpcMutator), so it is the only process writing the
+// database; otherwise the command writes it directly (localMutator).
+type mutator interface {
+	registerProject(projectDir string) error
+	reply(commentID int, message string) (*Comment, error)
+	// resolveThread resolves the thread of a comment, returning its root and
+	// the number of comments that were unresolved
+	resolveThread(commentID int) (rootID, count int, err error)
+	resolveFile(projectDir, filePath string) (int, error)
+}
+
+// localMutator writes the store and announces each change through publisher
+type localMutator struct {
+	store     Store
+	publisher eventPublisher
+}
+
+// registerProject registers a project, announcing it to open home pages if it is new
+func (m localMutator) registerProject(projectDir string) error {
+	projectDir, err := validateProjectDirectory(projectDir)
+	if err != nil {
+		return err
+	}
+	existing, err := m.store.GetProject(projectDir)
+	if err != nil {
+		return err
+	}
+	if _, err := m.store.CreateProject(projectDir); err != nil {
+		return err
+	}
+
+	if existing == nil {
+		m.publisher.broadcast(projectDir, "", eventProjectRegistered, ProjectRegisteredEvent{ProjectDirectory: projectDir})
+	}
+	return nil
+}
+
+// reply adds an agent reply to a root comment
+func (m localMutator) reply(commentID int, message string) (*Comment, error) {
+	parent, err := m.store.GetCommentByID(commentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+	if parent == nil {
+		return nil, errCommentNotFound
+	}
+	if parent.RootID != nil {
+		return nil, errReplyToReply
+	}
+
+	reply := &Comment{
+		ProjectDirectory: parent.ProjectDirectory,
+		FilePath:         parent.FilePath,
+		CommentText:      message,
+		Author:           "agent",
+		RootID:           &parent.ID,
+	}
+	if err := m.store.CreateComment(reply); err != nil {
+		return nil, fmt.Errorf("failed to create reply: %w", err)
+	}
+
+	publishCommentCreated(m.publisher, *reply)
+	return reply, nil
+}
+
+// resolveThread resolves the whole thread a comment belongs to
+func (m localMutator) resolveThread(commentID int) (int, int, error) {
+	comment, err := m.store.GetCommentByID(commentID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get comment: %w", err)
+	}
+	if comment == nil {
+		return 0, 0, errCommentNotFound
+	}
+	rootID := threadRootID(*comment)
+
+	// Collect the thread's unresolved comments so viewers can be notified
+	thread, err := m.store.GetThread(rootID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get thread: %w", err)
+	}
+	var unresolved []Comment
+	for _, c := range thread {
+		if c.ResolvedAt == nil {
+			unresolved = append(unresolved, c)
+		}
+	}
+
+	count, err := m.store.ResolveThread(rootID, "user")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to resolve thread: %w", err)
+	}
+	if count > 0 {
+		publishThreadsResolved(m.publisher, unresolved, "user")
+	}
+	return rootID, count, nil
+}
+
+// resolveFile resolves every unresolved comment on a file
+func (m localMutator) resolveFile(projectDir, filePath string) (int, error) {
+	comments, err := m.store.GetComments(projectDir, filePath, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get comments: %w", err)
+	}
+
+	count, err := m.store.ResolveComments(projectDir, filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve comments: %w", err)
+	}
+	if count > 0 {
+		publishThreadsResolved(m.publisher, comments, "user")
+	}
+	return count, nil
+}
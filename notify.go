@@ -5,30 +5,58 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
+	"time"
 )
 
-// notifyServerCommentsChanged sends a broadcast event to the server
-// to notify connected clients that comments have changed
-func notifyServerCommentsChanged(projectDir, filePath string) {
-	port := os.Getenv("CR_LISTEN_PORT")
-	if port == "" {
-		port = "4779"
-	}
+// daemonNotifier publishes events from CLI commands by relaying them through the
+// running daemon's /events endpoint. If the daemon is not running there is nobody
+// to notify, so failures are only logged.
+type daemonNotifier struct{}
 
-	payload := map[string]string{
+func (daemonNotifier) broadcast(projectDir, filePath, event string, data interface{}) {
+	payload := map[string]interface{}{
 		"project_directory": projectDir,
 		"file_path":         filePath,
-		"event":             "comments_resolved",
+		"event":             event,
+		"data":              data,
+	}
+
+	baseURL, ok := daemonURL()
+	if !ok {
+		// No server has recorded its address, so there is nobody to notify
+		return
 	}
 
-	data, err := json.Marshal(payload)
+	body, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("Failed to marshal broadcast payload: %v", err)
 		return
 	}
 
-	resp, err := http.Post("http://localhost:"+port+"/api/events", "application/json", bytes.NewReader(data))
+	// The daemon only accepts mutating requests carrying the per-install token
+	tokenPath, err := getAPITokenPath()
+	if err != nil {
+		log.Printf("Failed to locate API token: %v", err)
+		return
+	}
+	token, err := readAPIToken(tokenPath)
+	if err != nil {
+		// No token means the server has never started - nothing to notify
+		log.Printf("Note: Could not read API token (server might not be running): %v", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+apiPrefix+"/events", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to build broadcast request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiTokenHeader, token)
+
+	// Whatever holds the port might never answer
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
 	if err != nil {
 		// Server might not be running - just log and continue
 		log.Printf("Note: Could not notify server (server might not be running): %v", err)
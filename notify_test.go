@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonNotifier_OnlyNotifiesRecordedServer(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	token, err := loadOrCreateAPIToken()
+	require.NoError(t, err)
+
+	// Stands in for whatever holds the preferred port
+	var requests atomic.Int32
+	var gotToken atomic.Value
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		gotToken.Store(r.Header.Get(apiTokenHeader))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer listener.Close()
+	port := listener.Listener.Addr().(*net.TCPAddr).Port
+	t.Setenv("CR_LISTEN_PORT", strconv.Itoa(port))
+
+	daemonNotifier{}.broadcast("/project", "plan.md", "comments_updated", nil)
+	assert.Zero(t, requests.Load(), "no state file")
+
+	stateFile, err := getStateFilePath()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(stateFile, []byte(fmt.Sprintf(`{"pid": %d, "port": %d, "url": "%s"}`, os.Getpid(), port, listener.URL)), 0644))
+	daemonNotifier{}.broadcast("/project", "plan.md", "comments_updated", nil)
+	assert.Zero(t, requests.Load(), "a state file left behind by a server that is gone")
+
+	lock, err := acquireInstanceLock()
+	require.NoError(t, err)
+	defer func() { _ = lock.Close() }()
+	daemonNotifier{}.broadcast("/project", "plan.md", "comments_updated", nil)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Equal(t, token, gotToken.Load())
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher backends, selected with CR_WATCHER
+const (
+	// watchBackendAuto uses fsnotify and falls back to polling if it fails
+	watchBackendAuto     = "auto"
+	watchBackendFsnotify = "fsnotify"
+	watchBackendPoll     = "poll"
+)
+
+// defaultPollInterval is used when CR_POLL_INTERVAL is unset
+const defaultPollInterval = 2 * time.Second
+
+// maxPollHashBytes caps the size of files whose content the polling backend hashes
+const maxPollHashBytes = 4 << 20
+
+// pollRacyWindow is how recently a file must have been modified for a later
+// write to possibly leave its mtime unchanged. Network filesystems may have
+// timestamps as coarse as this.
+const pollRacyWindow = 2 * time.Second
+
+// watchBackend reports changes to the entries of watched directories. Events
+// use fsnotify's types whatever the mechanism behind them.
+type watchBackend interface {
+	name() string
+	add(dir string) error
+	remove(dir string) error
+	events() <-chan fsnotify.Event
+	errors() <-chan error
+	close() error
+}
+
+// fsnotifyBackend uses the operating system's notifications (inotify, kqueue, ...)
+type fsnotifyBackend struct {
+	watcher *fsnotify.Watcher
+}
+
+func newFsnotifyBackend() (*fsnotifyBackend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifyBackend{watcher: watcher}, nil
+}
+
+func (b *fsnotifyBackend) name() string                  { return watchBackendFsnotify }
+func (b *fsnotifyBackend) add(dir string) error          { return b.watcher.Add(dir) }
+func (b *fsnotifyBackend) remove(dir string) error       { return b.watcher.Remove(dir) }
+func (b *fsnotifyBackend) events() <-chan fsnotify.Event { return b.watcher.Events }
+func (b *fsnotifyBackend) errors() <-chan error          { return b.watcher.Errors }
+func (b *fsnotifyBackend) close() error                  { return b.watcher.Close() }
+
+// pollEntry is what the polling backend remembers about a directory entry
+type pollEntry struct {
+	isDir   bool
+	modTime time.Time
+	size    int64
+	// racy files were modified within pollRacyWindow of the listing, so a
+	// quick save may keep both mtime and size; their content is hashed
+	racy bool
+	hash uint64
+}
+
+// pollDir is the last listing of a watched directory
+type pollDir struct {
+	entries map[string]pollEntry
+}
+
+// pollBackend lists watched directories at a fixed interval and compares
+// each entry's mtime and size with the previous listing, and the content of
+// recently modified files. It works wherever the filesystem can be read,
+// including network mounts and containers that never deliver notifications.
+type pollBackend struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	dirs map[string]*pollDir
+
+	eventCh   chan fsnotify.Event
+	errorCh   chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPollBackend(interval time.Duration) *pollBackend {
+	b := &pollBackend{
+		interval: interval,
+		dirs:     make(map[string]*pollDir),
+		eventCh:  make(chan fsnotify.Event, 100),
+		errorCh:  make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *pollBackend) name() string                  { return watchBackendPoll }
+func (b *pollBackend) events() <-chan fsnotify.Event { return b.eventCh }
+func (b *pollBackend) errors() <-chan error          { return b.errorCh }
+
+func (b *pollBackend) add(dir string) error {
+	entries, err := scanPollDir(dir, nil)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.dirs[dir]; !ok {
+		b.dirs[dir] = &pollDir{entries: entries}
+	}
+	return nil
+}
+
+func (b *pollBackend) remove(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.dirs[dir]; !ok {
+		return fmt.Errorf("%s is not watched", dir)
+	}
+	delete(b.dirs, dir)
+	return nil
+}
+
+func (b *pollBackend) close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
+
+func (b *pollBackend) run() {
+	defer close(b.eventCh)
+	defer close(b.errorCh)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Events are sent without holding the lock, since handling one
+			// may add or remove directories
+			for _, event := range b.poll() {
+				select {
+				case b.eventCh <- event:
+				case <-b.done:
+					return
+				}
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// poll rescans every watched directory and returns the differences. The
+// directories are read without holding the lock, so that a slow filesystem
+// does not hold up add and remove.
+func (b *pollBackend) poll() []fsnotify.Event {
+	b.mu.Lock()
+	watched := make(map[string]*pollDir, len(b.dirs))
+	for dir, listing := range b.dirs {
+		watched[dir] = listing
+	}
+	b.mu.Unlock()
+
+	var events []fsnotify.Event
+	for dir, listing := range watched {
+		current, err := scanPollDir(dir, listing.entries)
+		if err != nil {
+			// The directory is gone: its entries are too. It stays watched,
+			// so a recreated directory is picked up again.
+			current = map[string]pollEntry{}
+		}
+
+		b.mu.Lock()
+		// Only the poll goroutine replaces the entries, but the directory
+		// may have been removed, or removed and added again, meanwhile
+		if b.dirs[dir] == listing {
+			events = append(events, diffPollEntries(dir, listing.entries, current)...)
+			listing.entries = current
+		}
+		b.mu.Unlock()
+	}
+	return events
+}
+
+// diffPollEntries turns two listings of dir into Create, Write and Remove events
+func diffPollEntries(dir string, previous, current map[string]pollEntry) []fsnotify.Event {
+	var events []fsnotify.Event
+	for name, before := range previous {
+		after, ok := current[name]
+		switch {
+		case !ok || after.isDir != before.isDir:
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+		case !after.isDir && (!after.modTime.Equal(before.modTime) || after.size != before.size || before.racy && after.hash != before.hash):
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Write})
+		}
+	}
+	for name, after := range current {
+		if before, ok := previous[name]; !ok || before.isDir != after.isDir {
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create})
+		}
+	}
+	return events
+}
+
+// scanPollDir lists the entries of dir. Files are compared by modification
+// time and size; the content is only hashed while a file is racy, or when it
+// was racy in the previous listing and neither has changed since.
+func scanPollDir(dir string, previous map[string]pollEntry) (map[string]pollEntry, error) {
+	now := time.Now()
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]pollEntry, len(dirEntries))
+	for _, d := range dirEntries {
+		info, err := d.Info()
+		if err != nil {
+			// Deleted since the listing
+			continue
+		}
+		entry := pollEntry{isDir: info.IsDir(), modTime: info.ModTime(), size: info.Size()}
+		if info.Mode().IsRegular() && info.Size() <= maxPollHashBytes {
+			before, known := previous[d.Name()]
+			unchanged := known && before.modTime.Equal(entry.modTime) && before.size == entry.size
+			entry.racy = now.Sub(entry.modTime) < pollRacyWindow
+			if entry.racy || unchanged && before.racy {
+				entry.hash, _ = hashFile(filepath.Join(dir, d.Name()))
+			}
+		}
+		entries[d.Name()] = entry
+	}
+	return entries, nil
+}
+
+func hashFile(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := fnv.New64a()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+// shouldFallBack reports whether a failure to add a watch is a limitation of
+// the notification mechanism (e.g. the inotify watch limit), rather than a
+// problem with the directory that polling would run into as well
+func shouldFallBack(err error) bool {
+	return !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectPollEvent waits for the next event of b
+func expectPollEvent(t *testing.T, b *pollBackend) fsnotify.Event {
+	t.Helper()
+
+	select {
+	case event := <-b.events():
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+		return fsnotify.Event{}
+	}
+}
+
+func TestPollBackend_DetectsChanges(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# One\n"), 0644))
+
+	b := newPollBackend(20 * time.Millisecond)
+	defer func() { _ = b.close() }()
+	require.NoError(t, b.add(dir))
+
+	// Same size and mtime: only the hash tells the versions apart
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("# Two\n"), 0644))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	assert.Equal(t, fsnotify.Event{Name: path, Op: fsnotify.Write}, expectPollEvent(t, b))
+
+	other := filepath.Join(dir, "other.md")
+	require.NoError(t, os.WriteFile(other, []byte("# Other\n"), 0644))
+	assert.Equal(t, fsnotify.Event{Name: other, Op: fsnotify.Create}, expectPollEvent(t, b))
+
+	require.NoError(t, os.Remove(other))
+	assert.Equal(t, fsnotify.Event{Name: other, Op: fsnotify.Remove}, expectPollEvent(t, b))
+
+	// Unchanged directories produce nothing
+	select {
+	case event := <-b.events():
+		t.Fatalf("unexpected event %v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, b.remove(dir))
+	assert.Error(t, b.remove(dir))
+	assert.ErrorIs(t, b.add(filepath.Join(dir, "missing")), os.ErrNotExist)
+}
+
+func TestScanPollDir_HashesRacyFilesOnly(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.md")
+	recent := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(old, []byte("# Old\n"), 0644))
+	require.NoError(t, os.WriteFile(recent, []byte("Recent\n"), 0644))
+	hourAgo := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(old, hourAgo, hourAgo))
+
+	entries, err := scanPollDir(dir, nil)
+	require.NoError(t, err)
+	assert.False(t, entries["old.md"].racy)
+	assert.Zero(t, entries["old.md"].hash, "a file that has not changed in a while is not read")
+	assert.True(t, entries["notes.txt"].racy, "whatever the project's extensions")
+	assert.NotZero(t, entries["notes.txt"].hash)
+
+	// A racy file is hashed once more after it settles, to catch a write that
+	// kept its mtime and size
+	previous := map[string]pollEntry{
+		"old.md":    entries["old.md"],
+		"notes.txt": {modTime: hourAgo, size: 7, racy: true, hash: entries["notes.txt"].hash},
+	}
+	require.NoError(t, os.WriteFile(recent, []byte("Edited\n"), 0644))
+	require.NoError(t, os.Chtimes(recent, hourAgo, hourAgo))
+	current, err := scanPollDir(dir, previous)
+	require.NoError(t, err)
+	assert.False(t, current["notes.txt"].racy)
+	assert.Equal(t, []fsnotify.Event{{Name: recent, Op: fsnotify.Write}}, diffPollEntries(dir, previous, current))
+}
+
+// fakeBackend stands in for fsnotify: it never reports events and fails on demand
+type fakeBackend struct {
+	mu      sync.Mutex
+	addErr  error
+	dirs    map[string]bool
+	eventCh chan fsnotify.Event
+	errorCh chan error
+	closed  atomic.Bool
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		dirs:    make(map[string]bool),
+		eventCh: make(chan fsnotify.Event),
+		errorCh: make(chan error),
+	}
+}
+
+func (b *fakeBackend) name() string                  { return watchBackendFsnotify }
+func (b *fakeBackend) events() <-chan fsnotify.Event { return b.eventCh }
+func (b *fakeBackend) errors() <-chan error          { return b.errorCh }
+
+func (b *fakeBackend) add(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.addErr != nil {
+		return b.addErr
+	}
+	b.dirs[dir] = true
+	return nil
+}
+
+func (b *fakeBackend) remove(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.dirs, dir)
+	return nil
+}
+
+func (b *fakeBackend) close() error {
+	if b.closed.CompareAndSwap(false, true) {
+		close(b.eventCh)
+		close(b.errorCh)
+	}
+	return nil
+}
+
+func newFallbackTestWatcher(t *testing.T, backend watchBackend) *FileWatcher {
+	t.Helper()
+
+	fw := newFileWatcherWithBackend(backend, true, 20*time.Millisecond)
+	fw.debounce = 20 * time.Millisecond
+	t.Cleanup(func() { _ = fw.close() })
+	return fw
+}
+
+func TestFileWatcher_FallsBackOnError(t *testing.T) {
+	fake := newFakeBackend()
+	fw := newFallbackTestWatcher(t, fake)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n"), 0644))
+
+	var calls atomic.Int32
+	sub, err := fw.subscribeFile(path, "", func() { calls.Add(1) })
+	require.NoError(t, err)
+	defer sub.close()
+	assert.Equal(t, watchBackendFsnotify, fw.status().Backend)
+
+	// e.g. the inotify queue overflowed
+	fake.errorCh <- fsnotify.ErrEventOverflow
+
+	require.Eventually(t, func() bool { return fw.status().Backend == watchBackendPoll }, 2*time.Second, 5*time.Millisecond)
+	require.Eventually(t, fake.closed.Load, 2*time.Second, 5*time.Millisecond)
+	status := fw.status()
+	assert.Equal(t, "20ms", status.PollInterval)
+	assert.Equal(t, fsnotify.ErrEventOverflow.Error(), status.FallbackReason)
+	assert.Equal(t, 1, status.WatchedDirectories)
+
+	// Existing subscriptions keep working on the new backend
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n\nPolled\n"), 0644))
+	waitForCount(t, &calls, 1)
+}
+
+func TestFileWatcher_FallsBackWhenWatchLimitReached(t *testing.T) {
+	fake := newFakeBackend()
+	fake.addErr = syscall.ENOSPC
+	fw := newFallbackTestWatcher(t, fake)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n"), 0644))
+
+	var calls atomic.Int32
+	sub, err := fw.subscribeFile(path, "", func() { calls.Add(1) })
+	require.NoError(t, err, "the subscription succeeds on the polling backend")
+	defer sub.close()
+	assert.Equal(t, watchBackendPoll, fw.status().Backend)
+
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n\nPolled\n"), 0644))
+	waitForCount(t, &calls, 1)
+}
+
+func TestFileWatcher_NoFallbackForMissingDirectory(t *testing.T) {
+	fake := newFakeBackend()
+	fake.addErr = os.ErrNotExist
+	fw := newFallbackTestWatcher(t, fake)
+
+	_, err := fw.subscribeFile(filepath.Join(t.TempDir(), "missing", "doc.md"), "", func() {})
+	assert.True(t, errors.Is(err, os.ErrNotExist), err)
+	assert.Equal(t, watchBackendFsnotify, fw.status().Backend)
+}
+
+func TestNewFileWatcher_Backend(t *testing.T) {
+	tests := []struct {
+		name     string
+		watcher  string
+		interval string
+		backend  string
+		wantErr  bool
+	}{
+		{"default", "", "", watchBackendFsnotify, false},
+		{"fsnotify", "fsnotify", "", watchBackendFsnotify, false},
+		{"poll", "poll", "250ms", watchBackendPoll, false},
+		{"unknown backend", "inotify", "", "", true},
+		{"invalid interval", "poll", "soon", "", true},
+		{"negative interval", "poll", "-1s", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CR_WATCHER", tt.watcher)
+			t.Setenv("CR_POLL_INTERVAL", tt.interval)
+
+			fw, err := newFileWatcher()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() { _ = fw.close() }()
+			assert.Equal(t, tt.backend, fw.status().Backend)
+		})
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	ts := newTestServer(t)
+
+	previous := fileWatcher
+	fileWatcher = newFileWatcherWithBackend(newPollBackend(time.Second), false, time.Second)
+	t.Cleanup(func() {
+		_ = fileWatcher.close()
+		fileWatcher = previous
+	})
+
+	rec := ts.do(t, http.MethodGet, "/api/v1/status", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var status DaemonStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, serviceName, status.Service)
+	assert.Equal(t, os.Getpid(), status.PID)
+	assert.Equal(t, &WatcherStatus{Backend: "poll", PollInterval: "1s"}, status.Watcher)
+	assert.NotNil(t, status.Metrics)
+}
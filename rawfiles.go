@@ -0,0 +1,139 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultRawFileTypes is used when CR_RAW_FILE_TYPES is unset. Images are what
+// Markdown documents typically reference.
+const defaultRawFileTypes = "image/*"
+
+// secretFilePatterns are base names that are never served, whatever their type.
+// Dotfiles (.env, .npmrc, .git/...) are refused separately.
+var secretFilePatterns = []string{
+	"*.pem",
+	"*.key",
+	"*.p12",
+	"*.pfx",
+	"*.jks",
+	"*.keystore",
+	"*.kdbx",
+	"id_rsa*",
+	"id_dsa*",
+	"id_ecdsa*",
+	"id_ed25519*",
+	"credentials",
+	"credentials.json",
+	"secrets.json",
+	"secrets.yaml",
+	"secrets.yml",
+}
+
+// isSecretFileName reports whether a base name is a dotfile or matches one of
+// secretFilePatterns
+func isSecretFileName(name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range secretFilePatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rawFilePolicy decides which non-Markdown project files may be served as-is
+type rawFilePolicy struct {
+	// allowedTypes are MIME types, optionally with a "type/*" wildcard
+	allowedTypes []string
+}
+
+// newRawFilePolicy parses a comma-separated list of MIME types such as
+// "image/*,application/pdf". An empty spec selects defaultRawFileTypes.
+func newRawFilePolicy(spec string) rawFilePolicy {
+	if strings.TrimSpace(spec) == "" {
+		spec = defaultRawFileTypes
+	}
+
+	var policy rawFilePolicy
+	for _, t := range strings.Split(spec, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			policy.allowedTypes = append(policy.allowedTypes, t)
+		}
+	}
+	return policy
+}
+
+// contentType returns the MIME type to serve relPath with, or false if the
+// policy refuses it. The type comes from the extension only; content is never
+// sniffed, so a file cannot smuggle in a type the policy does not allow.
+func (p rawFilePolicy) contentType(relPath string) (string, bool) {
+	for _, part := range strings.Split(path.Clean(filepath.ToSlash(relPath)), "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+
+	base := strings.ToLower(path.Base(filepath.ToSlash(relPath)))
+	if isSecretFileName(base) {
+		return "", false
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(base))
+	if contentType == "" {
+		return "", false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	for _, allowed := range p.allowedTypes {
+		if allowed == mediaType {
+			return contentType, true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return contentType, true
+		}
+	}
+	return "", false
+}
+
+// serveRawFile serves a non-Markdown file from a project if the policy allows it.
+// canonicalPath must already be resolved inside the project (see resolveProjectPath).
+func (s *Server) serveRawFile(w http.ResponseWriter, r *http.Request, relPath, canonicalPath string) {
+	contentType, ok := s.rawFiles.contentType(relPath)
+	if !ok {
+		http.Error(w, "File type not served", http.StatusForbidden)
+		return
+	}
+	// A symlink inside the project may point at a differently named file, e.g.
+	// logo.png -> .env; the target has to pass the policy too
+	if _, ok := s.rawFiles.contentType(filepath.Base(canonicalPath)); !ok {
+		http.Error(w, "File type not served", http.StatusForbidden)
+		return
+	}
+
+	f, err := os.Open(canonicalPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawFilePolicy_ContentType(t *testing.T) {
+	policy := newRawFilePolicy("")
+
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"diagram.png", "image/png", true},
+		{"docs/photo.JPG", "image/jpeg", true},
+		{"notes.txt", "", false},
+		{"script.js", "", false},
+		{"no-extension", "", false},
+		{".env", "", false},
+		{".git/config", "", false},
+		{"assets/.hidden.png", "", false},
+		{".github/logo.png", "", false},
+		{"server.pem", "", false},
+		{"keys/id_ed25519", "", false},
+		{"credentials.json", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := policy.contentType(tt.path)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	custom := newRawFilePolicy(" text/plain , application/pdf ")
+	_, ok := custom.contentType("notes.txt")
+	assert.True(t, ok)
+	_, ok = custom.contentType("paper.pdf")
+	assert.True(t, ok)
+	_, ok = custom.contentType("diagram.png")
+	assert.False(t, ok, "a custom list replaces the default")
+}
+
+func TestHandlers_RawFileServing(t *testing.T) {
+	ts := newTestServer(t)
+	outside := filepath.Dir(ts.projectDir)
+
+	writeFile := func(path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeFile(filepath.Join(ts.projectDir, "img", "diagram.png"), "PNG")
+	writeFile(filepath.Join(ts.projectDir, ".env"), "SECRET=1")
+	writeFile(filepath.Join(ts.projectDir, "server.pem"), "KEY")
+	writeFile(filepath.Join(ts.projectDir, "notes.txt"), "notes")
+	writeFile(filepath.Join(outside, "secret.png"), "PNG")
+	writeFile(filepath.Join(outside, "secret.md"), "# Secret")
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.png"), filepath.Join(ts.projectDir, "escape.png")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.md"), filepath.Join(ts.projectDir, "escape.md")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(ts.projectDir, "parent")))
+	require.NoError(t, os.Symlink(filepath.Join(ts.projectDir, ".env"), filepath.Join(ts.projectDir, "env.png")))
+	require.NoError(t, os.Symlink(filepath.Join(ts.projectDir, "img", "diagram.png"), filepath.Join(ts.projectDir, "alias.png")))
+
+	base := "/projects" + ts.projectDir
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"image", base + "/img/diagram.png", http.StatusOK},
+		{"symlink inside project", base + "/alias.png", http.StatusOK},
+		{"dotfile", base + "/.env", http.StatusForbidden},
+		{"secret", base + "/server.pem", http.StatusForbidden},
+		{"type not allowed", base + "/notes.txt", http.StatusForbidden},
+		{"missing file", base + "/img/missing.png", http.StatusNotFound},
+		{"dot-dot traversal", base + "/../secret.png", http.StatusForbidden},
+		{"nested dot-dot traversal", base + "/img/../../secret.png", http.StatusForbidden},
+		{"encoded traversal", base + "/%2e%2e/secret.png", http.StatusForbidden},
+		{"encoded slash traversal", base + "/img/..%2f..%2fsecret.png", http.StatusForbidden},
+		{"symlinked file escape", base + "/escape.png", http.StatusForbidden},
+		{"symlinked markdown escape", base + "/escape.md", http.StatusForbidden},
+		{"symlinked directory escape", base + "/parent/secret.png", http.StatusForbidden},
+		{"symlink to dotfile", base + "/env.png", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, tt.path, nil)
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.NotContains(t, rec.Body.String(), "SECRET")
+		})
+	}
+
+	rec := ts.do(t, http.MethodGet, base+"/img/diagram.png", nil)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "PNG", rec.Body.String())
+}
+
+func TestHandlers_RawFileServing_ConfiguredTypes(t *testing.T) {
+	t.Setenv("CR_RAW_FILE_TYPES", "text/plain")
+	ts := newTestServer(t)
+	require.NoError(t, os.WriteFile(filepath.Join(ts.projectDir, "notes.txt"), []byte("notes"), 0644))
+
+	rec := ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/notes.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "notes", rec.Body.String())
+}
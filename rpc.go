@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// rpcPrefix is the mount point of the CLI RPC on the daemon's Unix socket. It
+// is internal: only the claude-review binary calls it, and it may change
+// between releases (the CLI restarts daemons from other builds).
+const rpcPrefix = "/rpc"
+
+// RPC error codes for the errors CLI commands handle themselves
+var rpcErrors = map[string]error{
+	"comment_not_found": errCommentNotFound,
+	"reply_to_reply":    errReplyToReply,
+}
+
+// getSocketPath returns the path to the daemon's Unix socket
+func getSocketPath() (string, error) {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "server.sock"), nil
+}
+
+// listenRPC listens on the daemon's Unix socket. Only the user running the
+// daemon may connect: filesystem permissions are the access control, so the
+// RPC needs no API token.
+func listenRPC() (net.Listener, error) {
+	socketPath, err := getSocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// The instance lock is ours, so any socket left here is stale
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Created as 0600 rather than chmod'ed later, so nobody can sneak in
+	oldMask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, err
+	}
+	// Shutdown removes the file only once every connection is served
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	return listener, nil
+}
+
+// removeSocketFile removes the daemon's Unix socket
+func removeSocketFile() error {
+	socketPath, err := getSocketPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// rpcRoutes builds the router for the daemon's Unix socket
+func (s *Server) rpcRoutes() http.Handler {
+	rpc := rpcServer{mutatorFor: func(r *http.Request) mutator {
+		return localMutator{store: s.storeFor(r), publisher: sseHub}
+	}}
+
+	r := chi.NewRouter()
+	r.Use(logRequests)
+	r.Use(middleware.Recoverer)
+	r.Use(trackActivity)
+
+	r.Route(rpcPrefix, func(r chi.Router) {
+		r.NotFound(handleAPINotFound)
+		r.MethodNotAllowed(handleAPIMethodNotAllowed)
+
+		r.Post("/register", rpc.handleRegister)
+		r.Post("/reply", rpc.handleReply)
+		r.Post("/resolve-thread", rpc.handleResolveThread)
+		r.Post("/resolve-file", rpc.handleResolveFile)
+	})
+	return r
+}
+
+// RPC request and response bodies
+
+type rpcRegisterRequest struct {
+	ProjectDirectory string `json:"project_directory"`
+}
+
+type rpcReplyRequest struct {
+	CommentID int    `json:"comment_id"`
+	Message   string `json:"message"`
+}
+
+type rpcResolveThreadRequest struct {
+	CommentID int `json:"comment_id"`
+}
+
+type rpcResolveThreadResponse struct {
+	RootID int `json:"root_id"`
+	Count  int `json:"count"`
+}
+
+type rpcResolveFileRequest struct {
+	ProjectDirectory string `json:"project_directory"`
+	FilePath         string `json:"file_path"`
+}
+
+type rpcResolveFileResponse struct {
+	Count int `json:"count"`
+}
+
+// rpcServer applies CLI mutations inside the daemon
+type rpcServer struct {
+	// mutatorFor returns the mutator applying a request's changes
+	mutatorFor func(r *http.Request) mutator
+}
+
+// writeRPCError reports err with its RPC error code, if it has one
+func writeRPCError(w http.ResponseWriter, r *http.Request, err error) {
+	for code, known := range rpcErrors {
+		if errors.Is(err, known) {
+			writeError(w, http.StatusUnprocessableEntity, code, err.Error())
+			return
+		}
+	}
+	writeInternalError(w, r, err)
+}
+
+func (rpc rpcServer) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req rpcRegisterRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	projectDir, err := validateProjectDirectory(req.ProjectDirectory)
+	if errors.Is(err, errInvalidProjectDirectory) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err := rpc.mutatorFor(r).registerProject(projectDir); err != nil {
+		writeRPCError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (rpc rpcServer) handleReply(w http.ResponseWriter, r *http.Request) {
+	var req rpcReplyRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	reply, err := rpc.mutatorFor(r).reply(req.CommentID, req.Message)
+	if err != nil {
+		writeRPCError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+func (rpc rpcServer) handleResolveThread(w http.ResponseWriter, r *http.Request) {
+	var req rpcResolveThreadRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	rootID, count, err := rpc.mutatorFor(r).resolveThread(req.CommentID)
+	if err != nil {
+		writeRPCError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rpcResolveThreadResponse{RootID: rootID, Count: count})
+}
+
+func (rpc rpcServer) handleResolveFile(w http.ResponseWriter, r *http.Request) {
+	var req rpcResolveFileRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	count, err := rpc.mutatorFor(r).resolveFile(req.ProjectDirectory, req.FilePath)
+	if err != nil {
+		writeRPCError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rpcResolveFileResponse{Count: count})
+}
+
+// rpcMutator sends CLI mutations to the daemon over its Unix socket
+type rpcMutator struct {
+	client *http.Client
+}
+
+// dialDaemonRPC returns an rpcMutator if the daemon accepts connections on
+// its socket
+func dialDaemonRPC() (*rpcMutator, bool) {
+	socketPath, err := getSocketPath()
+	if err != nil {
+		return nil, false
+	}
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, false
+	}
+	_ = conn.Close()
+	return newRPCMutator(socketPath), true
+}
+
+func newRPCMutator(socketPath string) *rpcMutator {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &rpcMutator{client: &http.Client{Transport: transport, Timeout: 10 * time.Second}}
+}
+
+// call posts req to an RPC method and decodes the response into resp
+func (m *rpcMutator) call(method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	// The host is ignored on a Unix socket
+	r, err := m.client.Post("http://claude-review"+rpcPrefix+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("daemon RPC %s failed: %w", method, err)
+	}
+	defer func() {
+		_ = r.Body.Close()
+	}()
+
+	if r.StatusCode != http.StatusOK {
+		var envelope apiErrorEnvelope
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			return fmt.Errorf("daemon RPC %s failed with status %d", method, r.StatusCode)
+		}
+		if known, ok := rpcErrors[envelope.Error.Code]; ok {
+			return known
+		}
+		return fmt.Errorf("daemon RPC %s failed: %s", method, envelope.Error.Message)
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func (m *rpcMutator) registerProject(projectDir string) error {
+	return m.call("register", rpcRegisterRequest{ProjectDirectory: projectDir}, &struct{}{})
+}
+
+func (m *rpcMutator) reply(commentID int, message string) (*Comment, error) {
+	var reply Comment
+	if err := m.call("reply", rpcReplyRequest{CommentID: commentID, Message: message}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+func (m *rpcMutator) resolveThread(commentID int) (int, int, error) {
+	var resp rpcResolveThreadResponse
+	if err := m.call("resolve-thread", rpcResolveThreadRequest{CommentID: commentID}, &resp); err != nil {
+		return 0, 0, err
+	}
+	return resp.RootID, resp.Count, nil
+}
+
+func (m *rpcMutator) resolveFile(projectDir, filePath string) (int, error) {
+	var resp rpcResolveFileResponse
+	if err := m.call("resolve-file", rpcResolveFileRequest{ProjectDirectory: projectDir, FilePath: filePath}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
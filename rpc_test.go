@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRPCServer serves the RPC of a daemon backed by a fresh memory store
+func startRPCServer(t *testing.T) Store {
+	t.Helper()
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+
+	store := newMemoryStore()
+	listener, err := listenRPC()
+	require.NoError(t, err)
+	srv := &http.Server{Handler: newServer(store, testAPIToken).rpcRoutes()}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return store
+}
+
+func TestRPC(t *testing.T) {
+	store := startRPCServer(t)
+	projectDir := t.TempDir()
+
+	m, ok := dialDaemonRPC()
+	require.True(t, ok)
+
+	require.NoError(t, m.registerProject(projectDir))
+	project, err := store.GetProject(projectDir)
+	require.NoError(t, err)
+	assert.NotNil(t, project)
+
+	// Only existing directories, by absolute path, are registered
+	require.NoError(t, m.registerProject(projectDir+"/sub/.."+"/"))
+	projects, err := store.GetAllProjects()
+	require.NoError(t, err)
+	assert.Len(t, projects, 1, "the path is cleaned")
+	notADir := filepath.Join(projectDir, "doc.md")
+	require.NoError(t, os.WriteFile(notADir, []byte("# Doc\n"), 0644))
+	for _, dir := range []string{"relative/dir", filepath.Join(projectDir, "missing"), notADir} {
+		err := m.registerProject(dir)
+		assert.ErrorContains(t, err, "invalid project directory", dir)
+	}
+
+	root := &Comment{ProjectDirectory: projectDir, FilePath: "doc.md", CommentText: "Why?", Author: "user"}
+	require.NoError(t, store.CreateComment(root))
+
+	reply, err := m.reply(root.ID, "Because.")
+	require.NoError(t, err)
+	assert.Equal(t, "agent", reply.Author)
+	assert.Equal(t, root.ID, *reply.RootID)
+	thread, err := store.GetThread(root.ID)
+	require.NoError(t, err)
+	assert.Len(t, thread, 2)
+
+	_, err = m.reply(reply.ID, "Nested")
+	assert.ErrorIs(t, err, errReplyToReply)
+	_, err = m.reply(9999, "Nobody")
+	assert.ErrorIs(t, err, errCommentNotFound)
+	_, _, err = m.resolveThread(9999)
+	assert.ErrorIs(t, err, errCommentNotFound)
+
+	rootID, count, err := m.resolveThread(reply.ID)
+	require.NoError(t, err)
+	assert.Equal(t, root.ID, rootID)
+	assert.Equal(t, 2, count)
+	_, count, err = m.resolveThread(root.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count, "already resolved")
+
+	other := &Comment{ProjectDirectory: projectDir, FilePath: "doc.md", CommentText: "And this?", Author: "user"}
+	require.NoError(t, store.CreateComment(other))
+	count, err = m.resolveFile(projectDir, "doc.md")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestListenRPC(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	socketPath, err := getSocketPath()
+	require.NoError(t, err)
+
+	// Left behind by a daemon that was killed
+	require.NoError(t, os.WriteFile(socketPath, nil, 0644))
+
+	listener, err := listenRPC()
+	require.NoError(t, err)
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket|0600, info.Mode())
+
+	// Closing leaves the file for shutdown to remove once requests are served
+	require.NoError(t, listener.Close())
+	_, err = os.Stat(socketPath)
+	require.NoError(t, err)
+	require.NoError(t, removeSocketFile())
+	_, err = os.Stat(socketPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOpenMutator_DaemonDown(t *testing.T) {
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+
+	m, closeMutator, err := openMutator()
+	require.NoError(t, err)
+	defer closeMutator()
+	assert.IsType(t, localMutator{}, m)
+
+	startRPCServer(t)
+	m, closeMutator, err = openMutator()
+	require.NoError(t, err)
+	defer closeMutator()
+	assert.IsType(t, &rpcMutator{}, m)
+}
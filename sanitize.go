@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// htmlSource identifies where rendered HTML came from. Each source is sanitized
+// with its own allow-list policy before it reaches the viewer.
+type htmlSource int
+
+const (
+	// sourceComment is comment and reply text, written by users and agents
+	sourceComment htmlSource = iota
+	// sourceDocument is a Markdown document from a project
+	sourceDocument
+	// sourceTrustedDocument is a document from a project the user trusts
+	// (trusted_projects), which may also use inline styles, ids and classes
+	sourceTrustedDocument
+)
+
+// chromaStyleProperties are the CSS properties Chroma emits as inline styles for
+// syntax highlighting (it is configured without CSS classes)
+var chromaStyleProperties = []string{
+	"color",
+	"background-color",
+	"font-weight",
+	"font-style",
+	"text-decoration",
+	"display",
+	"border",
+}
+
+var (
+	lineNumberPattern = regexp.MustCompile(`^[0-9]+$`)
+	textAlignPattern  = regexp.MustCompile(`^(left|right|center)$`)
+)
+
+var htmlPolicies = map[htmlSource]*bluemonday.Policy{
+	sourceComment:         newCommentPolicy(),
+	sourceDocument:        newDocumentPolicy(),
+	sourceTrustedDocument: newTrustedDocumentPolicy(),
+}
+
+// newMarkdownPolicy allows the HTML that goldmark produces for Markdown with
+// GFM and highlighting, and nothing else
+func newMarkdownPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	// Links stay as written; the viewer already sends no referrer
+	p.RequireNoFollowOnLinks(false)
+
+	// Chroma code blocks
+	p.AllowAttrs("tabindex").Matching(regexp.MustCompile(`^0$`)).OnElements("pre")
+	p.AllowStyles(chromaStyleProperties...).OnElements("pre", "code", "span")
+
+	// GFM task lists and table alignment
+	p.AllowElements("input")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowStyles("text-align").MatchingEnum("left", "right", "center").OnElements("th", "td")
+
+	return p
+}
+
+// newCommentPolicy is the Markdown policy with images kept local. Images load
+// by themselves, so a remote one would tell its server who read the comment
+// and when. Only the project's own files and inline data are allowed.
+func newCommentPolicy() *bluemonday.Policy {
+	p := newMarkdownPolicy()
+	p.AllowDataURIImages()
+	p.RewriteSrc(func(u *url.URL) {
+		if u.Host != "" || (u.Scheme != "" && u.Scheme != "data") {
+			*u = url.URL{}
+		}
+	})
+	return p
+}
+
+// newDocumentPolicy extends the Markdown policy with the line attributes the
+// viewer uses to map selections back to source lines. Documents come from the
+// project itself, so their images may load from anywhere the CSP allows.
+func newDocumentPolicy() *bluemonday.Policy {
+	p := newMarkdownPolicy()
+	p.AllowAttrs("data-line-start", "data-line-end").Matching(lineNumberPattern).Globally()
+	p.AllowAttrs("align").Matching(textAlignPattern).OnElements("p", "div", "img", "td", "th", "h1", "h2", "h3", "h4", "h5", "h6")
+	return p
+}
+
+// trustedStyleProperties are the inline styles trusted documents may use on any
+// element. Properties that can load resources (background, content, ...) stay out.
+var trustedStyleProperties = []string{
+	"color",
+	"background-color",
+	"font-weight",
+	"font-style",
+	"font-size",
+	"text-align",
+	"text-decoration",
+	"vertical-align",
+	"display",
+	"float",
+	"width",
+	"height",
+	"max-width",
+	"margin",
+	"padding",
+	"border",
+}
+
+// newTrustedDocumentPolicy relaxes the document policy for layout HTML that
+// READMEs commonly use. Scripts, event handlers and embeds are still removed.
+func newTrustedDocumentPolicy() *bluemonday.Policy {
+	p := newDocumentPolicy()
+	p.AllowStyling()
+	p.AllowAttrs("id").Globally()
+	p.AllowStyles(trustedStyleProperties...).Globally()
+	p.AllowDataAttributes()
+	return p
+}
+
+// sanitizeHTML removes everything not allowed by the policy for source
+func sanitizeHTML(source htmlSource, html []byte) []byte {
+	return htmlPolicies[source].SanitizeBytes(html)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMarkdown_SanitizesComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		notWant []string
+	}{
+		{"script tag", "Looks good <script>alert(1)</script>", []string{"<script", "alert(1)</script>"}},
+		{"event handler", `<img src="x" onerror="alert(1)">`, []string{"onerror", "<img"}},
+		{"javascript link", "[click](javascript:alert(1))", []string{"javascript:"}},
+		{"raw html is not rendered", `<div style="position:fixed">overlay</div>`, []string{"<div", "position"}},
+		{"iframe", `<iframe src="https://example.com"></iframe>`, []string{"<iframe"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := RenderMarkdown([]byte(tt.comment), defaultTheme)
+			require.NoError(t, err)
+			for _, s := range tt.notWant {
+				assert.NotContains(t, string(html), s)
+			}
+		})
+	}
+
+	// Images may not load from elsewhere
+	for _, src := range []string{"https://tracker.example/p.gif", "HTTP://tracker.example/p.gif", "//tracker.example/p.gif",
+		"ftp://tracker.example/p.gif"} {
+		html, err := RenderMarkdown([]byte("![x]("+src+")"), defaultTheme)
+		require.NoError(t, err)
+		assert.NotContains(t, string(html), "tracker.example", src)
+	}
+	for _, src := range []string{"diagram.png", "./img/diagram.png", "../diagram.png", "/projects/app/diagram.png",
+		"data:image/png;base64,iVBORw0KGgo="} {
+		html, err := RenderMarkdown([]byte("![x]("+src+")"), defaultTheme)
+		require.NoError(t, err)
+		assert.Contains(t, string(html), `src="`+src+`"`)
+	}
+
+	// Markdown features still render
+	html, err := RenderMarkdown([]byte("**bold** [link](https://example.com)\n\n- [x] done\n\n```go\nfunc main() {}\n```\n"), defaultTheme)
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "<strong>bold</strong>")
+	assert.Contains(t, string(html), `<a href="https://example.com">link</a>`)
+	assert.Contains(t, string(html), `<input checked="" disabled="" type="checkbox"`)
+	assert.Contains(t, string(html), `style="color: #007020; font-weight: bold"`)
+}
+
+func TestRenderMarkdownWithLineNumbers_SanitizesDocuments(t *testing.T) {
+	source := []byte("# Title\n\n<div onclick=\"alert(1)\" style=\"color: red\" class=\"intro\">Intro</div>\n\n" +
+		"<script>alert(1)</script>\n\n<details><summary>More</summary>Hidden</details>\n\n" +
+		"| a |\n|:-:|\n| 1 |\n\n```go\nfunc main() {}\n```\n")
+
+	html, err := RenderMarkdownWithLineNumbers(source, defaultTheme, sourceDocument)
+	require.NoError(t, err)
+	out := string(html)
+
+	assert.Contains(t, out, `<h1 data-line-start="1" data-line-end="1">Title</h1>`)
+	assert.Contains(t, out, `<pre data-line-start="13" data-line-end="16" style="background-color: #f0f0f0">`)
+	assert.Contains(t, out, `style="text-align: center"`)
+	assert.Contains(t, out, "<details><summary>More</summary>Hidden</details>")
+	assert.NotContains(t, out, "<script")
+	assert.NotContains(t, out, "onclick")
+	assert.NotContains(t, out, "color: red", "inline styles are only allowed for trusted documents")
+	assert.NotContains(t, out, `class="intro"`)
+
+	t.Run("trusted documents", func(t *testing.T) {
+		html, err := RenderMarkdownWithLineNumbers(append(source, []byte(
+			"\n<p style=\"background: url(https://attacker.example/x)\">Bg</p>\n")...), defaultTheme, sourceTrustedDocument)
+		require.NoError(t, err)
+		out := string(html)
+
+		assert.Contains(t, out, `style="color: red"`)
+		assert.Contains(t, out, `class="intro"`)
+		assert.Contains(t, out, `data-line-start="1"`)
+		assert.NotContains(t, out, "<script")
+		assert.NotContains(t, out, "onclick")
+		assert.NotContains(t, out, "attacker.example")
+	})
+}
+
+func TestRenderMarkdownWithLineNumbers_DocumentImagesStayRemote(t *testing.T) {
+	// Unlike in comments, remote images in documents are left alone: badges
+	// and diagrams in READMEs commonly load from elsewhere
+	source := []byte("![badge](https://img.shields.io/x.svg)\n\n<img src=\"https://example.com/diagram.png\" alt=\"diagram\">\n")
+	for _, policy := range []htmlSource{sourceDocument, sourceTrustedDocument} {
+		html, err := RenderMarkdownWithLineNumbers(source, defaultTheme, policy)
+		require.NoError(t, err)
+		assert.Contains(t, string(html), `src="https://img.shields.io/x.svg"`, policy)
+		assert.Contains(t, string(html), `src="https://example.com/diagram.png"`, policy)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// apiTokenHeader carries the per-install secret on mutating API requests
+const apiTokenHeader = "X-Claude-Review-Token"
+
+// apiTokenFileName is the name of the token file inside the data directory
+const apiTokenFileName = "api.token"
+
+// loopbackHosts are the only host names the daemon answers to. Rejecting every
+// other Host header defeats DNS rebinding, where an attacker-controlled name is
+// re-pointed at 127.0.0.1.
+var loopbackHosts = map[string]bool{
+	"localhost": true,
+	"127.0.0.1": true,
+	"::1":       true,
+}
+
+// getAPITokenPath returns the path to the API token file
+func getAPITokenPath() (string, error) {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, apiTokenFileName), nil
+}
+
+// loadOrCreateAPIToken returns the per-install API token, generating it on first use.
+// The file is only readable by the current user, which is what restricts access.
+func loadOrCreateAPIToken() (string, error) {
+	tokenPath, err := getAPITokenPath()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := readAPIToken(tokenPath)
+	if err == nil && token != "" {
+		return token, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token = hex.EncodeToString(buf)
+
+	// O_EXCL so that two processes racing to create the token agree on one value
+	f, err := os.OpenFile(tokenPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if os.IsExist(err) {
+		return readAPIToken(tokenPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create API token file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		return "", fmt.Errorf("failed to write API token file: %w", err)
+	}
+
+	return token, nil
+}
+
+// readAPIToken reads the token from the given file
+func readAPIToken(tokenPath string) (string, error) {
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// isLoopbackHost reports whether a Host header value (with optional port) names the local machine
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return loopbackHosts[strings.ToLower(host)]
+}
+
+// isSameOrigin reports whether the Origin header matches the origin the request was sent to
+func isSameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" && strings.EqualFold(u.Host, r.Host)
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// requireLocalRequest rejects requests whose Host or Origin is not the daemon itself
+func requireLocalRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(r.Host) {
+			writeError(w, http.StatusForbidden, errCodeForbiddenHost, "Host not allowed: "+r.Host)
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" && !isSameOrigin(origin, r) {
+			writeError(w, http.StatusForbidden, errCodeForbiddenOrigin, "Cross-origin request not allowed: "+origin)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIToken enforces the per-install token on mutating requests (CSRF protection).
+// The viewer receives the token in-page, the CLI reads it from the data directory.
+func (s *Server) requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutatingMethod(r.Method) {
+			got := r.Header.Get(apiTokenHeader)
+			if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(s.apiToken)) != 1 {
+				writeError(w, http.StatusForbidden, errCodeInvalidToken, "Missing or invalid "+apiTokenHeader+" header")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type cspNonceKey struct{}
+
+// cspNonce returns the Content-Security-Policy nonce for inline scripts of this request
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// securityHeaders sets standard hardening headers, including a CSP with a per-request
+// nonce for the small inline bootstrap script in viewer.html
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to generate CSP nonce: %w", err))
+			return
+		}
+		nonce := hex.EncodeToString(buf)
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", strings.Join([]string{
+			"default-src 'self'",
+			"script-src 'self' 'nonce-" + nonce + "'",
+			// Chroma emits inline style attributes for syntax highlighting
+			"style-src 'self' 'unsafe-inline'",
+			"img-src 'self' data: https:",
+			"connect-src 'self'",
+			"object-src 'none'",
+			"base-uri 'none'",
+			"form-action 'self'",
+			"frame-ancestors 'none'",
+		}, "; "))
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		h.Set("Cross-Origin-Resource-Policy", "same-origin")
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurity_HostAndOrigin(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		status int
+		code   string
+	}{
+		{"localhost", "localhost:4779", "", http.StatusOK, ""},
+		{"ipv4 loopback", "127.0.0.1:4779", "", http.StatusOK, ""},
+		{"ipv6 loopback", "[::1]:4779", "", http.StatusOK, ""},
+		{"same origin", "localhost:4779", "http://localhost:4779", http.StatusOK, ""},
+		{"rebound host name", "attacker.example:4779", "", http.StatusForbidden, errCodeForbiddenHost},
+		{"foreign origin", "localhost:4779", "http://attacker.example", http.StatusForbidden, errCodeForbiddenOrigin},
+		{"other local port", "localhost:4779", "http://localhost:3000", http.StatusForbidden, errCodeForbiddenOrigin},
+		{"null origin", "localhost:4779", "null", http.StatusForbidden, errCodeForbiddenOrigin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil)
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			ts.handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.code != "" {
+				var envelope apiErrorEnvelope
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&envelope))
+				assert.Equal(t, tt.code, envelope.Error.Code)
+			}
+		})
+	}
+}
+
+func TestSecurity_TokenRequiredForMutations(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, token := range []string{"", "wrong-token"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/comments", strings.NewReader(`{}`))
+		req.Host = "localhost:4779"
+		if token != "" {
+			req.Header.Set(apiTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		ts.handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusForbidden, rec.Code, "token %q", token)
+		var envelope apiErrorEnvelope
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&envelope))
+		assert.Equal(t, errCodeInvalidToken, envelope.Error.Code)
+	}
+
+	// Reads do not need the token
+	req := httptest.NewRequest(http.MethodGet, "/projects"+ts.projectDir+"/doc.md", nil)
+	req.Host = "localhost:4779"
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSecurity_Headers(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodGet, "/projects"+ts.projectDir+"/doc.md", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "no-referrer", rec.Header().Get("Referrer-Policy"))
+
+	csp := rec.Header().Get("Content-Security-Policy")
+	assert.Contains(t, csp, "default-src 'self'")
+	assert.Contains(t, csp, "frame-ancestors 'none'")
+
+	// The viewer's inline script must carry the nonce from the policy, and the
+	// page must hand the token to viewer.js
+	body := rec.Body.String()
+	start := strings.Index(csp, "'nonce-")
+	require.NotEqual(t, -1, start)
+	nonce := csp[start+len("'nonce-"):]
+	nonce = nonce[:strings.Index(nonce, "'")]
+	assert.Contains(t, body, `nonce="`+nonce+`"`)
+	assert.Contains(t, body, `content="`+testAPIToken+`"`)
+}
+
+func TestSecurity_APITokenFile(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("CR_DATA_DIR", dataDir)
+
+	token, err := loadOrCreateAPIToken()
+	require.NoError(t, err)
+	assert.Len(t, token, 64)
+
+	info, err := os.Stat(filepath.Join(dataDir, apiTokenFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// The token is stable across restarts
+	again, err := loadOrCreateAPIToken()
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the systemd user units written by `service install`
+const (
+	serviceUnitName = "claude-review.service"
+	socketUnitName  = "claude-review.socket"
+)
+
+// systemctl runs systemctl against the user's service manager; replaced in
+// tests
+var systemctl = func(args ...string) error {
+	output, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl --user %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// getSystemdUserDir returns the directory for the user's own systemd units
+func getSystemdUserDir() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configDir, "systemd", "user"), nil
+}
+
+// serviceUnits describes the socket and service units for one data directory
+type serviceUnits struct {
+	Executable string
+	DataDir    string
+	Address    string
+	// Environment holds the other CR_* settings, as NAME=value
+	Environment []string
+}
+
+// systemdQuote quotes a value for a unit file. Percent signs would otherwise
+// be read as specifiers.
+func systemdQuote(s string) string {
+	return strings.ReplaceAll(strconv.Quote(s), "%", "%%")
+}
+
+func (u serviceUnits) socketUnit() string {
+	return fmt.Sprintf(`[Unit]
+Description=claude-review server socket
+
+[Socket]
+ListenStream=%s
+
+[Install]
+WantedBy=sockets.target
+`, u.Address)
+}
+
+func (u serviceUnits) serviceUnit() string {
+	logFile := systemdQuote(filepath.Join(u.DataDir, "server.log"))
+
+	var env strings.Builder
+	for _, kv := range append([]string{"CR_DATA_DIR=" + u.DataDir}, u.Environment...) {
+		fmt.Fprintf(&env, "Environment=%s\n", systemdQuote(kv))
+	}
+
+	// No [Install] section: the socket starts the service
+	return fmt.Sprintf(`[Unit]
+Description=claude-review server
+Requires=%s
+After=%s
+
+[Service]
+ExecStart=%s server
+%sStandardOutput=append:%s
+StandardError=append:%s
+Restart=on-failure
+`, socketUnitName, socketUnitName, systemdQuote(u.Executable), env.String(), logFile, logFile)
+}
+
+// currentServiceUnits describes the units for this binary, data directory and
+// environment
+func currentServiceUnits() (serviceUnits, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return serviceUnits{}, fmt.Errorf("failed to get executable path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		return serviceUnits{}, err
+	}
+
+	// The server reads the same settings it would get from this shell
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "CR_") && !strings.HasPrefix(kv, "CR_DATA_DIR=") {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+
+	return serviceUnits{
+		Executable:  executable,
+		DataDir:     dataDir,
+		Address:     net.JoinHostPort("127.0.0.1", preferredPort()),
+		Environment: env,
+	}, nil
+}
+
+// installService writes the units and enables the socket, so systemd starts
+// the server on the first connection
+func installService() error {
+	units, err := currentServiceUnits()
+	if err != nil {
+		return err
+	}
+	unitDir, err := getSystemdUserDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", unitDir, err)
+	}
+
+	files := map[string]string{
+		socketUnitName:  units.socketUnit(),
+		serviceUnitName: units.serviceUnit(),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(unitDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	fmt.Printf("Installed %s and %s to %s\n", socketUnitName, serviceUnitName, unitDir)
+
+	// A daemon started the old way holds the port the socket needs
+	if isServerRunning() {
+		if err := stopDaemon(false); err != nil {
+			return err
+		}
+		if err := waitForServerStopped(30 * time.Second); err != nil {
+			return err
+		}
+	}
+
+	err = systemctl("daemon-reload")
+	if err == nil {
+		err = systemctl("enable", "--now", socketUnitName)
+	}
+	if err != nil {
+		fmt.Printf("Could not enable the socket: %v\n", err)
+		fmt.Println("Enable it with:")
+		fmt.Println("  systemctl --user daemon-reload")
+		fmt.Printf("  systemctl --user enable --now %s\n", socketUnitName)
+		return nil
+	}
+
+	fmt.Printf("Listening on %s; systemd starts the server on the first connection\n", units.Address)
+	return nil
+}
+
+// uninstallService stops and disables the units and removes them
+func uninstallService() error {
+	unitDir, err := getSystemdUserDir()
+	if err != nil {
+		return err
+	}
+
+	// Best effort: the user's service manager may be unavailable
+	_ = systemctl("disable", "--now", socketUnitName)
+	_ = systemctl("stop", serviceUnitName)
+
+	var removed []string
+	for _, name := range []string{socketUnitName, serviceUnitName} {
+		if err := os.Remove(filepath.Join(unitDir, name)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+		removed = append(removed, name)
+	}
+	_ = systemctl("daemon-reload")
+
+	if len(removed) == 0 {
+		fmt.Println("The systemd service was not installed")
+		return nil
+	}
+	fmt.Printf("Removed %s from %s\n", strings.Join(removed, " and "), unitDir)
+	return nil
+}
+
+// installedSocketAddress returns the address of the installed socket unit,
+// if its service serves this data directory
+func installedSocketAddress() (string, bool) {
+	unitDir, err := getSystemdUserDir()
+	if err != nil {
+		return "", false
+	}
+	dataDir, err := getDataDir()
+	if err != nil {
+		return "", false
+	}
+
+	address, _ := readUnitSetting(filepath.Join(unitDir, socketUnitName), "ListenStream")
+	environment, _ := readUnitSetting(filepath.Join(unitDir, serviceUnitName), "Environment")
+	if address == "" {
+		return "", false
+	}
+	if kv, err := strconv.Unquote(strings.ReplaceAll(environment, "%%", "%")); err != nil || kv != "CR_DATA_DIR="+dataDir {
+		return "", false
+	}
+	return address, true
+}
+
+// readUnitSetting returns the first value of key in a unit file
+func readUnitSetting(path, key string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), key+"="); ok {
+			return value, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// activateService connects to the systemd socket so systemd starts the
+// server, and waits until it answers
+func activateService(address string) (*DaemonState, error) {
+	conn, err := net.DialTimeout("tcp", address, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !instanceLocked() {
+		if time.Now().After(deadline) {
+			return nil, errors.New("systemd did not start the server")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return waitForRunningServer(10 * time.Second)
+}
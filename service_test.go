@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSystemctl records systemctl invocations instead of running them
+func fakeSystemctl(t *testing.T, err error) *[]string {
+	t.Helper()
+
+	var calls []string
+	previous := systemctl
+	systemctl = func(args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return err
+	}
+	t.Cleanup(func() { systemctl = previous })
+	return &calls
+}
+
+func TestServiceUnits(t *testing.T) {
+	units := serviceUnits{
+		Executable:  "/opt/claude review/bin/claude-review",
+		DataDir:     "/home/me/100%/data",
+		Address:     "127.0.0.1:4779",
+		Environment: []string{"CR_IDLE_TIMEOUT=1h"},
+	}
+
+	socket := units.socketUnit()
+	assert.Contains(t, socket, "ListenStream=127.0.0.1:4779\n")
+	assert.Contains(t, socket, "WantedBy=sockets.target\n")
+
+	service := units.serviceUnit()
+	assert.Contains(t, service, `ExecStart="/opt/claude review/bin/claude-review" server`+"\n")
+	assert.Contains(t, service, `Environment="CR_DATA_DIR=/home/me/100%%/data"`+"\n")
+	assert.Contains(t, service, `Environment="CR_IDLE_TIMEOUT=1h"`+"\n")
+	assert.Contains(t, service, `StandardOutput=append:"/home/me/100%%/data/server.log"`+"\n")
+	assert.Contains(t, service, "Requires=claude-review.socket\n")
+	assert.NotContains(t, service, "[Install]")
+}
+
+func TestInstallService(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	t.Setenv("CR_LISTEN_PORT", "4809")
+	t.Setenv("CR_WATCHER", "poll")
+	calls := fakeSystemctl(t, nil)
+
+	_, ok := installedSocketAddress()
+	assert.False(t, ok)
+
+	require.NoError(t, installService())
+	assert.Equal(t, []string{"daemon-reload", "enable --now claude-review.socket"}, *calls)
+
+	unitDir := filepath.Join(configDir, "systemd", "user")
+	service, err := os.ReadFile(filepath.Join(unitDir, serviceUnitName))
+	require.NoError(t, err)
+	assert.Contains(t, string(service), `Environment="CR_WATCHER=poll"`)
+	assert.Contains(t, string(service), `Environment="CR_LISTEN_PORT=4809"`)
+
+	address, ok := installedSocketAddress()
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:4809", address)
+
+	// The units serve another data directory
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	_, ok = installedSocketAddress()
+	assert.False(t, ok)
+
+	*calls = nil
+	require.NoError(t, uninstallService())
+	assert.Equal(t, []string{"disable --now claude-review.socket", "stop claude-review.service", "daemon-reload"}, *calls)
+	entries, err := os.ReadDir(unitDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, uninstallService(), "uninstalling twice is harmless")
+}
+
+func TestInstallService_NoServiceManager(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("CR_DATA_DIR", t.TempDir())
+	fakeSystemctl(t, assert.AnError)
+
+	// The units are still written, for the user to enable
+	require.NoError(t, installService())
+	_, ok := installedSocketAddress()
+	assert.True(t, ok)
+}
@@ -2,64 +2,455 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	// sseClientBufferSize is how many events a client may fall behind before
+	// it is disconnected. It reconnects and catches up from the replay buffer.
+	sseClientBufferSize = 64
+	// sseReplayBufferSize is how many recent events are kept per file
+	sseReplayBufferSize = 256
+	// sseReplayBufferBytes bounds the events kept per file by size, since a
+	// rendered document can be large
+	sseReplayBufferBytes = 1 << 20
+	// sseReplayGracePeriod is how long a topic's events are kept after its
+	// last subscriber left, for viewers that reconnect
+	sseReplayGracePeriod = 5 * time.Minute
+	// sseHeartbeatInterval is how often an idle stream gets a comment line, so
+	// proxies and browsers do not time it out and dead connections are noticed
+	sseHeartbeatInterval = 15 * time.Second
+	// sseWriteTimeout bounds a single write to a client
+	sseWriteTimeout = 10 * time.Second
+	// sseRetryMillis is the reconnect delay suggested to browsers
+	sseRetryMillis = 1000
+)
+
+// Subscription scopes of GET /events
+const (
+	// sseScopeFile receives the events of one file (the viewer)
+	sseScopeFile = "file"
+	// sseScopeProject receives project events: Markdown files added or removed
+	// and comment counts changed (directory listings)
+	sseScopeProject = "project"
+	// sseScopeGlobal receives the project events of every project (home page)
+	sseScopeGlobal = "global"
+)
+
+// SSEClient is one open event stream. FilePath is empty for project
+// subscribers, and both fields are empty for global subscribers.
 type SSEClient struct {
 	ProjectDir string
 	FilePath   string
 	Channel    chan []byte
+	// done is closed when the client is removed from the hub, either because
+	// its stream ended or because it could not keep up
+	done chan struct{}
+}
+
+// sseTopic identifies the subscribers of one file, one project (filePath
+// empty) or all projects (both empty)
+type sseTopic struct {
+	projectDir string
+	filePath   string
+}
+
+// sseEvent is a formatted event kept for replay
+type sseEvent struct {
+	seq     uint64
+	event   string
+	message []byte
+}
+
+// sseReplayBuffer holds the most recent events of a topic
+type sseReplayBuffer struct {
+	events []sseEvent
+	bytes  int
+	// evicted is the sequence number of the newest event dropped from the
+	// buffer. Superseded renders are dropped without counting, since the
+	// render that replaced them is replayed instead.
+	evicted uint64
+	// subscribers is the number of clients of the topic
+	subscribers int
+	// expiry deletes the buffer once the topic has had no subscribers for
+	// the grace period
+	expiry *time.Timer
 }
 
 type SSEHub struct {
 	clients map[*SSEClient]bool
 	mu      sync.RWMutex
+
+	// epoch distinguishes event IDs of this process from those of an earlier
+	// daemon, whose events cannot be replayed
+	epoch   string
+	lastSeq uint64
+	replay  map[sseTopic]*sseReplayBuffer
+	// expired is the sequence number of the newest event in a deleted buffer.
+	// A client of a topic without a buffer that has seen less may have missed
+	// events.
+	expired     uint64
+	replayGrace time.Duration
+
+	heartbeatInterval time.Duration
+
+	// slowClientDisconnects counts clients dropped for not keeping up
+	slowClientDisconnects atomic.Int64
+
+	// stopping is closed when the server shuts down; streams then write what
+	// they have queued and end
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+var sseHub = newSSEHub()
+
+func newSSEHub() *SSEHub {
+	return &SSEHub{
+		clients:           make(map[*SSEClient]bool),
+		epoch:             strconv.FormatInt(time.Now().UnixNano(), 36),
+		replay:            make(map[sseTopic]*sseReplayBuffer),
+		replayGrace:       sseReplayGracePeriod,
+		heartbeatInterval: sseHeartbeatInterval,
+		stopping:          make(chan struct{}),
+	}
 }
 
-var sseHub = &SSEHub{
-	clients: make(map[*SSEClient]bool),
+func newSSEClient(projectDir, filePath string) *SSEClient {
+	return &SSEClient{
+		ProjectDir: projectDir,
+		FilePath:   filePath,
+		Channel:    make(chan []byte, sseClientBufferSize),
+		done:       make(chan struct{}),
+	}
 }
 
-func (h *SSEHub) addClient(client *SSEClient) {
+// addClient registers a client and returns the events it missed since
+// lastEventID (empty for a fresh connection). Registration and the replay
+// snapshot happen under one lock, so no event is lost or sent twice. If the
+// missed events are no longer buffered, ok is false and the client must resync.
+func (h *SSEHub) addClient(client *SSEClient, lastEventID string) (missed [][]byte, ok bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	h.clients[client] = true
+
+	topic := sseTopic{client.ProjectDir, client.FilePath}
+	buf := h.replay[topic]
+	existed := buf != nil
+	if !existed {
+		buf = h.replayBuffer(topic)
+	}
+	buf.subscribers++
+	if buf.expiry != nil {
+		buf.expiry.Stop()
+		buf.expiry = nil
+	}
+
+	if lastEventID == "" {
+		return nil, true
+	}
+
+	epoch, seqStr, found := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !found || err != nil || epoch != h.epoch || seq > h.lastSeq {
+		return nil, false
+	}
+
+	if !existed {
+		return nil, seq >= h.expired
+	}
+	if seq < buf.evicted {
+		return nil, false
+	}
+	for _, e := range buf.events {
+		if e.seq > seq {
+			missed = append(missed, e.message)
+		}
+	}
+	return missed, true
+}
+
+// clientCount returns the number of open streams
+func (h *SSEHub) clientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
 }
 
 func (h *SSEHub) removeClient(client *SSEClient) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.dropClient(client)
+}
+
+// dropClient removes a client; h.mu must be held
+func (h *SSEHub) dropClient(client *SSEClient) {
+	if !h.clients[client] {
+		return
+	}
 	delete(h.clients, client)
-	close(client.Channel)
+	close(client.done)
+
+	topic := sseTopic{client.ProjectDir, client.FilePath}
+	if buf := h.replay[topic]; buf != nil {
+		if buf.subscribers--; buf.subscribers == 0 {
+			h.expireLater(topic, buf)
+		}
+	}
 }
 
-func (h *SSEHub) broadcast(projectDir, filePath, event string, data interface{}) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// replayBuffer returns the buffer of a topic, creating it if needed. A new
+// buffer expires unless a subscriber turns up; h.mu must be held.
+func (h *SSEHub) replayBuffer(topic sseTopic) *sseReplayBuffer {
+	buf := h.replay[topic]
+	if buf == nil {
+		buf = &sseReplayBuffer{}
+		h.replay[topic] = buf
+		h.expireLater(topic, buf)
+	}
+	return buf
+}
+
+// expireLater deletes a topic's buffer after the grace period, unless a
+// subscriber arrives first; h.mu must be held
+func (h *SSEHub) expireLater(topic sseTopic, buf *sseReplayBuffer) {
+	if buf.expiry != nil {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(h.replayGrace, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
 
+		// A subscriber that came and went restarted the countdown
+		if h.replay[topic] != buf || buf.expiry != timer {
+			return
+		}
+		delete(h.replay, topic)
+		if n := len(buf.events); n > 0 && buf.events[n-1].seq > h.expired {
+			h.expired = buf.events[n-1].seq
+		}
+	})
+	buf.expiry = timer
+}
+
+// broadcast sends an event to the subscribers of a file, or of a project if
+// filePath is empty. Project events also reach global subscribers, and
+// comment events on a file produce a comments_changed event for its project.
+func (h *SSEHub) broadcast(projectDir, filePath, event string, data interface{}) {
 	jsonData, _ := json.Marshal(data)
-	message := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, jsonData))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.publish(sseTopic{projectDir, filePath}, event, jsonData)
+
+	if filePath != "" && commentEvents[event] {
+		changed, _ := json.Marshal(CommentsChangedEvent{ProjectDirectory: projectDir, FilePath: filePath})
+		h.publish(sseTopic{projectDir: projectDir}, eventCommentsChanged, changed)
+	}
+}
+
+// publish assigns the next event ID and delivers the event; h.mu must be held
+func (h *SSEHub) publish(topic sseTopic, event string, jsonData []byte) {
+	h.lastSeq++
+	message := []byte(fmt.Sprintf("id: %s-%d\nevent: %s\ndata: %s\n\n", h.epoch, h.lastSeq, event, jsonData))
+
+	h.deliver(topic, h.lastSeq, event, message)
+	if topic.filePath == "" && topic.projectDir != "" {
+		h.deliver(sseTopic{}, h.lastSeq, event, message)
+	}
+}
+
+// add appends an event, dropping the render it supersedes and then the
+// oldest events while the buffer is over its count or size limit. The newest
+// event is always kept.
+func (b *sseReplayBuffer) add(e sseEvent) {
+	if e.event == eventDocumentRendered {
+		kept := b.events[:0]
+		for _, old := range b.events {
+			if old.event == eventDocumentRendered {
+				b.bytes -= len(old.message)
+				continue
+			}
+			kept = append(kept, old)
+		}
+		clear(b.events[len(kept):])
+		b.events = kept
+	}
+
+	b.events = append(b.events, e)
+	b.bytes += len(e.message)
+	for len(b.events) > 1 && (len(b.events) > sseReplayBufferSize || b.bytes > sseReplayBufferBytes) {
+		b.evicted = b.events[0].seq
+		b.bytes -= len(b.events[0].message)
+		b.events[0] = sseEvent{}
+		b.events = b.events[1:]
+	}
+}
+
+// deliver buffers a message for replay and sends it to the topic's
+// subscribers; h.mu must be held
+func (h *SSEHub) deliver(topic sseTopic, seq uint64, event string, message []byte) {
+	h.replayBuffer(topic).add(sseEvent{seq: seq, event: event, message: message})
 
 	for client := range h.clients {
-		if client.ProjectDir == projectDir && client.FilePath == filePath {
+		if client.ProjectDir == topic.projectDir && client.FilePath == topic.filePath {
 			select {
 			case client.Channel <- message:
 			default:
-				// Client can't keep up, skip
+				// Client can't keep up. Dropping the event would leave its
+				// page silently stale, so disconnect it instead; it
+				// reconnects with Last-Event-ID and replays what it missed.
+				h.dropClient(client)
+				total := h.slowClientDisconnects.Add(1)
+				slog.Warn("Disconnected slow SSE client", "file", filepath.Join(topic.projectDir, topic.filePath),
+					"slow_client_disconnects", total)
 			}
 		}
 	}
 }
 
-func handleSSE(w http.ResponseWriter, r *http.Request) {
+// shutdown queues a server_stopping event for every client and ends their
+// streams once they have written everything queued before it. It is
+// registered with http.Server.RegisterOnShutdown, since Shutdown otherwise
+// waits for streams that never end by themselves.
+func (h *SSEHub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Not replayable: a reconnecting viewer reaches a new daemon
+	message := []byte(fmt.Sprintf("event: %s\ndata: {\"reason\":\"shutdown\"}\n\n", eventServerStopping))
+	for client := range h.clients {
+		select {
+		case client.Channel <- message:
+		default:
+			// Its buffer is full; it still gets what is queued
+		}
+	}
+	h.stopOnce.Do(func() { close(h.stopping) })
+}
+
+// watchTopic keeps the files behind a topic watched for a subscriber: the
+// document of a file topic, or the directory tree of a project topic. The
+// subscribers of a topic share a key, so each change is rendered and
+// broadcast once however many tabs are open. The returned function releases
+// the caller's subscription.
+func (h *SSEHub) watchTopic(fw *FileWatcher, topic sseTopic) (release func()) {
+	if fw == nil || topic.projectDir == "" {
+		return func() {}
+	}
+
+	key := topic.projectDir + "\x00" + topic.filePath
+	var sub *watchSubscription
+	var err error
+	if topic.filePath != "" {
+		sub, err = fw.subscribeFile(filepath.Join(topic.projectDir, topic.filePath), key, func() {
+			publishDocumentRendered(h, topic.projectDir, topic.filePath)
+		})
+	} else {
+		sub, err = fw.subscribeTree(topic.projectDir, key, func(relPath string) {
+			h.broadcast(topic.projectDir, "", eventFilesChanged, FilesChangedEvent{
+				ProjectDirectory: topic.projectDir,
+				Path:             relPath,
+			})
+		})
+	}
+	if err != nil {
+		slog.Error("Failed to watch", "file", filepath.Join(topic.projectDir, topic.filePath), "error", err)
+		return func() {}
+	}
+	return sub.close
+}
+
+// sseStream writes to one client with a deadline on every write, so a stalled
+// connection cannot block its handler forever
+type sseStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s sseStream) write(msg []byte) error {
+	// Not every ResponseWriter supports deadlines (e.g. in tests); writes are
+	// then unbounded, as before
+	_ = s.rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+	if _, err := s.w.Write(msg); err != nil {
+		return err
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// requireSSEProject checks that a subscription is for a registered project.
+// Subscriptions start file watches, so they must not reach arbitrary
+// directories.
+func (s *Server) requireSSEProject(w http.ResponseWriter, r *http.Request, projectDir string) bool {
+	project, err := s.storeFor(r).GetProject(projectDir)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return false
+	}
+	if project == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Project not found")
+		return false
+	}
+	return true
+}
+
+// handleSSE streams the events of one file, one project or all projects,
+// selected with the scope parameter. Every event has an ID; a reconnecting
+// browser sends the last one it saw in Last-Event-ID and first receives the
+// events it missed.
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
 	projectDir := r.URL.Query().Get("project_directory")
 	filePath := r.URL.Query().Get("file_path")
 
-	if projectDir == "" || filePath == "" {
-		http.Error(w, "Missing project_directory or file_path", http.StatusBadRequest)
+	switch scope := r.URL.Query().Get("scope"); scope {
+	case "", sseScopeFile:
+		if projectDir == "" || filePath == "" {
+			writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "Missing project_directory or file_path")
+			return
+		}
+		if !s.requireSSEProject(w, r, projectDir) {
+			return
+		}
+		// Viewers may subscribe before the file exists, but never to a path
+		// outside the project
+		filePath = path.Clean(filepath.ToSlash(filePath))
+		_, err := resolveProjectPath(projectDir, filePath)
+		switch {
+		case errors.Is(err, errPathOutsideProject):
+			writeError(w, http.StatusBadRequest, errCodeValidationFailed, "file_path must stay inside the project directory")
+			return
+		case err != nil && !errors.Is(err, errFileNotFound):
+			writeInternalError(w, r, err)
+			return
+		}
+	case sseScopeProject:
+		if projectDir == "" {
+			writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "Missing project_directory")
+			return
+		}
+		if !s.requireSSEProject(w, r, projectDir) {
+			return
+		}
+		filePath = ""
+	case sseScopeGlobal:
+		projectDir, filePath = "", ""
+	default:
+		writeError(w, http.StatusBadRequest, errCodeInvalidParameter, "Invalid scope: "+scope)
 		return
 	}
 
@@ -68,47 +459,64 @@ func handleSSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	// Create client
-	client := &SSEClient{
-		ProjectDir: projectDir,
-		FilePath:   filePath,
-		Channel:    make(chan []byte, 10),
+	client := newSSEClient(projectDir, filePath)
+	// Browsers send Last-Event-ID when they reconnect by themselves; a viewer
+	// that has to open a new EventSource passes it as a query parameter
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
 	}
-
-	sseHub.addClient(client)
+	missed, ok := sseHub.addClient(client, lastEventID)
 	defer sseHub.removeClient(client)
 
-	// Setup file watcher for this file
-	if fileWatcher != nil {
-		if err := fileWatcher.watchFile(projectDir, filePath, func() {
-			sseHub.broadcast(projectDir, filePath, "file_updated", map[string]string{
-				"file_path": filePath,
-			})
-		}); err != nil {
-			log.Printf("Failed to watch file: %v", err)
-		}
-		defer func() {
-			_ = fileWatcher.unwatchFile(projectDir, filePath)
-		}()
-	}
+	// Watch the file, or the project's directory tree, while anyone subscribes
+	release := sseHub.watchTopic(fileWatcher, sseTopic{projectDir, filePath})
+	defer release()
+
+	stream := sseStream{w: w, rc: http.NewResponseController(w)}
 
 	// Send initial connection message
-	if _, err := fmt.Fprintf(w, "event: connected\ndata: {\"status\":\"ok\"}\n\n"); err != nil {
+	if err := stream.write([]byte(fmt.Sprintf("event: connected\ndata: {\"status\":\"ok\"}\nretry: %d\n\n", sseRetryMillis))); err != nil {
 		return
 	}
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
+
+	// Catch up on missed events, or ask the viewer to resync if they are gone
+	if !ok {
+		missed = [][]byte{[]byte("event: reload\ndata: {\"reason\":\"missed events\"}\n\n")}
+	}
+	for _, msg := range missed {
+		if err := stream.write(msg); err != nil {
+			return
+		}
 	}
 
+	heartbeat := time.NewTicker(sseHub.heartbeatInterval)
+	defer heartbeat.Stop()
+
 	// Stream messages
 	for {
 		select {
 		case msg := <-client.Channel:
-			if _, err := w.Write(msg); err != nil {
+			if err := stream.write(msg); err != nil {
 				return
 			}
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
+		case <-heartbeat.C:
+			if err := stream.write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case <-client.done:
+			return
+		case <-sseHub.stopping:
+			// Finish the queued writes, ending with server_stopping
+			for {
+				select {
+				case msg := <-client.Channel:
+					if err := stream.write(msg); err != nil {
+						return
+					}
+				default:
+					return
+				}
 			}
 		case <-r.Context().Done():
 			return
@@ -116,24 +524,36 @@ func handleSSE(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleBroadcast relays an event to the subscribers of a file, or of a project
+// if file_path is empty. CLI commands use it to publish the changes they make
+// directly in the database.
 func handleBroadcast(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ProjectDirectory string `json:"project_directory"`
-		FilePath         string `json:"file_path"`
-		Event            string `json:"event"`
+		ProjectDirectory string          `json:"project_directory"`
+		FilePath         string          `json:"file_path"`
+		Event            string          `json:"event"`
+		Data             json.RawMessage `json:"data"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
-	sseHub.broadcast(req.ProjectDirectory, req.FilePath, req.Event, map[string]string{
-		"file_path": req.FilePath,
-	})
+	if req.ProjectDirectory == "" || req.Event == "" {
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed, "project_directory and event are required")
+		return
+	}
+	// The name is written into the stream as-is
+	if strings.ContainsAny(req.Event, "\r\n") {
+		writeError(w, http.StatusBadRequest, errCodeValidationFailed, "event must be a single line")
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]string{"status": "broadcast"}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var data interface{} = req.Data
+	if len(req.Data) == 0 {
+		data = map[string]string{"file_path": req.FilePath}
 	}
+	sseHub.broadcast(req.ProjectDirectory, req.FilePath, req.Event, data)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "broadcast"})
 }
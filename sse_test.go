@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventID returns the id field of a formatted SSE message
+func eventID(t *testing.T, message []byte) string {
+	t.Helper()
+	line, _, _ := strings.Cut(string(message), "\n")
+	id, ok := strings.CutPrefix(line, "id: ")
+	require.True(t, ok, "message has no id: %q", message)
+	return id
+}
+
+func TestSSEHub_SlowClientDisconnected(t *testing.T) {
+	logs := captureLogs(t, logConfig{level: slog.LevelInfo})
+	hub := newSSEHub()
+
+	stalled := newSSEClient("/proj", "doc.md")
+	other := newSSEClient("/proj", "other.md")
+	_, ok := hub.addClient(stalled, "")
+	require.True(t, ok)
+	_, ok = hub.addClient(other, "")
+	require.True(t, ok)
+
+	// The stalled client never reads from its channel
+	for i := 0; i < sseClientBufferSize; i++ {
+		hub.broadcast("/proj", "doc.md", "comment_created", map[string]int{"i": i})
+	}
+	select {
+	case <-stalled.done:
+		t.Fatal("client disconnected before its buffer was full")
+	default:
+	}
+
+	hub.broadcast("/proj", "doc.md", "comment_created", map[string]int{"i": sseClientBufferSize})
+
+	select {
+	case <-stalled.done:
+	default:
+		t.Fatal("stalled client was not disconnected")
+	}
+	assert.Equal(t, int64(1), hub.slowClientDisconnects.Load())
+	assert.Contains(t, logs.String(), `level=WARN msg="Disconnected slow SSE client" file=/proj/doc.md slow_client_disconnects=1`)
+
+	// Later broadcasts and the handler's own cleanup must not touch it again
+	hub.broadcast("/proj", "doc.md", "comment_created", nil)
+	hub.removeClient(stalled)
+	assert.Equal(t, int64(1), hub.slowClientDisconnects.Load())
+
+	// Clients of other files are unaffected
+	select {
+	case <-other.done:
+		t.Fatal("unrelated client was disconnected")
+	default:
+	}
+
+	// The dropped client catches up from the replay buffer on reconnect
+	lastSeen := eventID(t, <-stalled.Channel)
+	missed, ok := hub.addClient(newSSEClient("/proj", "doc.md"), lastSeen)
+	require.True(t, ok)
+	assert.Len(t, missed, sseClientBufferSize+1)
+}
+
+func TestSSEHub_Replay(t *testing.T) {
+	hub := newSSEHub()
+
+	listener := newSSEClient("/proj", "doc.md")
+	hub.addClient(listener, "")
+
+	hub.broadcast("/proj", "doc.md", "comment_created", map[string]int{"id": 1})
+	hub.broadcast("/proj", "other.md", "comment_created", map[string]int{"id": 2})
+	hub.broadcast("/proj", "doc.md", "comment_updated", map[string]int{"id": 1})
+	hub.broadcast("/proj", "doc.md", "comment_deleted", map[string]int{"id": 1})
+
+	first := eventID(t, <-listener.Channel)
+	<-listener.Channel
+	last := eventID(t, <-listener.Channel)
+
+	t.Run("missed events of the same file", func(t *testing.T) {
+		missed, ok := hub.addClient(newSSEClient("/proj", "doc.md"), first)
+		require.True(t, ok)
+		require.Len(t, missed, 2)
+		assert.Contains(t, string(missed[0]), "event: comment_updated")
+		assert.Contains(t, string(missed[1]), "event: comment_deleted")
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		missed, ok := hub.addClient(newSSEClient("/proj", "doc.md"), last)
+		require.True(t, ok)
+		assert.Empty(t, missed)
+	})
+
+	t.Run("fresh connection", func(t *testing.T) {
+		missed, ok := hub.addClient(newSSEClient("/proj", "doc.md"), "")
+		require.True(t, ok)
+		assert.Empty(t, missed)
+	})
+
+	t.Run("id from an earlier daemon", func(t *testing.T) {
+		for _, id := range []string{"otherepoch-1", "garbage", hub.epoch + "-999"} {
+			_, ok := hub.addClient(newSSEClient("/proj", "doc.md"), id)
+			assert.False(t, ok, id)
+		}
+	})
+
+	t.Run("evicted events", func(t *testing.T) {
+		for i := 0; i < sseReplayBufferSize; i++ {
+			hub.broadcast("/proj", "doc.md", "comment_updated", nil)
+		}
+		_, ok := hub.addClient(newSSEClient("/proj", "doc.md"), first)
+		assert.False(t, ok, "missed events are gone, the client must resync")
+	})
+}
+
+func TestSSEHub_ReplayLimits(t *testing.T) {
+	t.Run("only the latest render is kept", func(t *testing.T) {
+		hub := newSSEHub()
+		listener := newSSEClient("/proj", "doc.md")
+		hub.addClient(listener, "")
+
+		hub.broadcast("/proj", "doc.md", "comment_created", nil)
+		first := eventID(t, <-listener.Channel)
+		for i := 1; i <= 3; i++ {
+			hub.broadcast("/proj", "doc.md", eventDocumentRendered, map[string]int{"render": i})
+		}
+		hub.broadcast("/proj", "doc.md", "comment_deleted", nil)
+
+		buf := hub.replay[sseTopic{"/proj", "doc.md"}]
+		require.Len(t, buf.events, 3)
+		missed, ok := hub.addClient(newSSEClient("/proj", "doc.md"), first)
+		require.True(t, ok, "superseded renders do not force a resync")
+		require.Len(t, missed, 2)
+		assert.Contains(t, string(missed[0]), `data: {"render":3}`)
+		assert.Contains(t, string(missed[1]), "event: comment_deleted")
+	})
+
+	t.Run("size", func(t *testing.T) {
+		hub := newSSEHub()
+		listener := newSSEClient("/proj", "doc.md")
+		hub.addClient(listener, "")
+
+		large := strings.Repeat("x", sseReplayBufferBytes/3)
+		hub.broadcast("/proj", "doc.md", "comment_created", large)
+		first := eventID(t, <-listener.Channel)
+		for i := 0; i < 3; i++ {
+			hub.broadcast("/proj", "doc.md", "comment_updated", large)
+		}
+
+		buf := hub.replay[sseTopic{"/proj", "doc.md"}]
+		assert.Len(t, buf.events, 2)
+		assert.LessOrEqual(t, buf.bytes, sseReplayBufferBytes)
+		_, ok := hub.addClient(newSSEClient("/proj", "doc.md"), first)
+		assert.False(t, ok, "missed events are gone, the client must resync")
+
+		// An event over the limit by itself is still kept
+		hub.broadcast("/proj", "doc.md", "comment_updated", strings.Repeat("x", sseReplayBufferBytes))
+		assert.Len(t, buf.events, 1)
+	})
+
+	t.Run("expired after the last subscriber leaves", func(t *testing.T) {
+		hub := newSSEHub()
+		hub.replayGrace = 20 * time.Millisecond
+		topic := sseTopic{"/proj", "doc.md"}
+		hasBuffer := func(topic sseTopic) bool {
+			hub.mu.RLock()
+			defer hub.mu.RUnlock()
+			return hub.replay[topic] != nil
+		}
+
+		listener := newSSEClient("/proj", "doc.md")
+		hub.addClient(listener, "")
+		hub.broadcast("/proj", "doc.md", "comment_created", nil)
+		seen := eventID(t, <-listener.Channel)
+		hub.broadcast("/proj", "doc.md", "comment_deleted", nil)
+
+		// Nobody subscribes to the project, so its events are not kept for long
+		require.Eventually(t, func() bool { return !hasBuffer(sseTopic{projectDir: "/proj"}) }, time.Second, 5*time.Millisecond)
+
+		time.Sleep(2 * hub.replayGrace)
+		assert.True(t, hasBuffer(topic), "kept while subscribed")
+
+		hub.removeClient(listener)
+		require.Eventually(t, func() bool { return !hasBuffer(topic) }, time.Second, 5*time.Millisecond)
+
+		_, ok := hub.addClient(newSSEClient("/proj", "doc.md"), seen)
+		assert.False(t, ok, "the missed event expired, the client must resync")
+
+		hub.broadcast("/proj", "other.md", "comment_created", nil)
+		_, ok = hub.addClient(newSSEClient("/proj", "doc.md"), fmt.Sprintf("%s-%d", hub.epoch, hub.lastSeq))
+		assert.True(t, ok)
+	})
+}
+
+func TestHandleSSE_ReplayAndHeartbeat(t *testing.T) {
+	ts := newTestServer(t)
+	server := httptest.NewServer(ts.handler)
+	defer server.Close()
+
+	interval := sseHub.heartbeatInterval
+	sseHub.heartbeatInterval = 50 * time.Millisecond
+	t.Cleanup(func() { sseHub.heartbeatInterval = interval })
+
+	connect := func(lastEventID string) (*bufio.Scanner, func()) {
+		u := fmt.Sprintf("%s/api/v1/events?project_directory=%s&file_path=doc.md", server.URL, url.QueryEscape(ts.projectDir))
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		req.Host = "localhost:4779"
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return bufio.NewScanner(resp.Body), func() { _ = resp.Body.Close() }
+	}
+
+	// nextLine reads the next non-empty line
+	nextLine := func(scanner *bufio.Scanner) string {
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				return line
+			}
+		}
+		t.Fatal("stream ended")
+		return ""
+	}
+
+	scanner, closeStream := connect("")
+	assert.Equal(t, "event: connected", nextLine(scanner))
+	nextLine(scanner) // data
+	assert.Equal(t, fmt.Sprintf("retry: %d", sseRetryMillis), nextLine(scanner))
+	assert.Equal(t, ": heartbeat", nextLine(scanner))
+
+	sseHub.broadcast(ts.projectDir, "doc.md", "comment_created", map[string]int{"id": 1})
+	idLine := nextLine(scanner)
+	require.True(t, strings.HasPrefix(idLine, "id: "), idLine)
+	closeStream()
+
+	// Events sent while the viewer was disconnected are replayed on reconnect
+	sseHub.broadcast(ts.projectDir, "doc.md", "comment_deleted", map[string]int{"id": 1})
+
+	scanner, closeStream = connect(strings.TrimPrefix(idLine, "id: "))
+	defer closeStream()
+	for nextLine(scanner) != "event: connected" {
+	}
+	for {
+		line := nextLine(scanner)
+		if strings.HasPrefix(line, "event: ") {
+			assert.Equal(t, "event: comment_deleted", line)
+			break
+		}
+	}
+
+	// A stale ID triggers a full resync
+	scanner, closeStale := connect("stale-1")
+	defer closeStale()
+	for nextLine(scanner) != "event: connected" {
+	}
+	for {
+		line := nextLine(scanner)
+		if strings.HasPrefix(line, "event: ") {
+			assert.Equal(t, "event: reload", line)
+			break
+		}
+	}
+}
+
+func TestSSEHub_Scopes(t *testing.T) {
+	hub := newSSEHub()
+
+	viewer := newSSEClient("/proj", "doc.md")
+	listing := newSSEClient("/proj", "")
+	otherListing := newSSEClient("/other", "")
+	home := newSSEClient("", "")
+	for _, c := range []*SSEClient{viewer, listing, otherListing, home} {
+		hub.addClient(c, "")
+	}
+
+	received := func(c *SSEClient) []string {
+		var events []string
+		for {
+			select {
+			case msg := <-c.Channel:
+				for _, line := range strings.Split(string(msg), "\n") {
+					if name, ok := strings.CutPrefix(line, "event: "); ok {
+						events = append(events, name)
+					}
+				}
+			default:
+				return events
+			}
+		}
+	}
+
+	// Comment events reach the viewer, and their project and home page learn
+	// that counts changed
+	hub.broadcast("/proj", "doc.md", eventCommentCreated, nil)
+	assert.Equal(t, []string{eventCommentCreated}, received(viewer))
+	assert.Equal(t, []string{eventCommentsChanged}, received(listing))
+	assert.Equal(t, []string{eventCommentsChanged}, received(home))
+	assert.Empty(t, received(otherListing))
+
+	// Document events stay with the viewer
+	hub.broadcast("/proj", "doc.md", eventDocumentRendered, nil)
+	assert.Equal(t, []string{eventDocumentRendered}, received(viewer))
+	assert.Empty(t, received(listing))
+	assert.Empty(t, received(home))
+
+	// Project events reach the project and the home page
+	hub.broadcast("/proj", "", eventFilesChanged, nil)
+	assert.Empty(t, received(viewer))
+	assert.Equal(t, []string{eventFilesChanged}, received(listing))
+	assert.Equal(t, []string{eventFilesChanged}, received(home))
+	assert.Empty(t, received(otherListing))
+}
+
+func TestHandleSSE_Scope(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"unknown scope", "scope=everything", http.StatusBadRequest},
+		{"project without directory", "scope=project", http.StatusBadRequest},
+		{"unregistered project", "scope=project&project_directory=" + url.QueryEscape(t.TempDir()), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.do(t, http.MethodGet, "/api/v1/events?"+tt.query, nil)
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestHandleSSE_ServerShutdown(t *testing.T) {
+	ts := newTestServer(t)
+
+	hub := sseHub
+	sseHub = newSSEHub()
+	t.Cleanup(func() { sseHub = hub })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: ts.handler}
+	srv.RegisterOnShutdown(sseHub.shutdown)
+	go func() { _ = srv.Serve(listener) }()
+
+	u := fmt.Sprintf("http://%s/api/v1/events?project_directory=%s&file_path=doc.md", listener.Addr(), url.QueryEscape(ts.projectDir))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	require.NoError(t, err)
+	req.Host = "localhost:4779"
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "event: connected" {
+	}
+
+	// An event queued just before shutdown is still delivered
+	sseHub.broadcast(ts.projectDir, "doc.md", eventCommentDeleted, map[string]int{"id": 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, srv.Shutdown(ctx), "open streams must not hold up shutdown")
+	assert.Less(t, time.Since(start), time.Second)
+
+	var events []string
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, name)
+		}
+	}
+	assert.Equal(t, []string{eventCommentDeleted, eventServerStopping}, events, "the stream ends after server_stopping")
+}
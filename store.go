@@ -0,0 +1,68 @@
+package main
+
+// Store is the persistence layer for projects and comments.
+// SQLiteStore is used by the daemon and CLI; MemoryStore backs fast tests.
+type Store interface {
+	// Projects
+	CreateProject(directory string) (*Project, error)
+	GetProject(directory string) (*Project, error)
+	GetAllProjects() ([]Project, error)
+	GetFileSummaries(projectDir string) ([]FileSummary, error)
+
+	// Comments
+	CreateComment(c *Comment) error
+	GetComments(projectDir, filePath string, resolved bool) ([]Comment, error)
+	ListComments(filter CommentFilter) ([]Comment, error)
+	GetCommentByID(commentID int) (*Comment, error)
+	UpdateComment(commentID int, commentText string) error
+	// DeleteComment deletes a comment; deleting a root deletes its replies too
+	DeleteComment(commentID int) error
+
+	// Threads and resolution
+	GetThread(rootCommentID int) ([]Comment, error)
+	HasReplies(commentID int) (bool, error)
+	ResolveThread(rootCommentID int, resolvedBy string) (int, error)
+	ResolveComments(projectDir, filePath string) (int, error)
+
+	Close() error
+}
+
+// Comment status values accepted by CommentFilter
+const (
+	commentStatusUnresolved = "unresolved"
+	commentStatusResolved   = "resolved"
+	commentStatusAll        = "all"
+)
+
+// CommentFilter selects comments for ListComments
+type CommentFilter struct {
+	ProjectDirectory string
+	FilePath         string // Empty matches all files in the project
+	Status           string // One of the commentStatus* values
+}
+
+// matches reports whether a comment satisfies the filter
+func (f CommentFilter) matches(c Comment) bool {
+	if c.ProjectDirectory != f.ProjectDirectory {
+		return false
+	}
+	if f.FilePath != "" && c.FilePath != f.FilePath {
+		return false
+	}
+	switch f.Status {
+	case commentStatusResolved:
+		return c.ResolvedAt != nil
+	case commentStatusAll:
+		return true
+	default:
+		return c.ResolvedAt == nil
+	}
+}
+
+// FileSummary holds per-file comment counts for a project
+type FileSummary struct {
+	FilePath           string `json:"file_path"`
+	UnresolvedThreads  int    `json:"unresolved_threads"`
+	UnresolvedComments int    `json:"unresolved_comments"`
+	ResolvedComments   int    `json:"resolved_comments"`
+}
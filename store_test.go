@@ -0,0 +1,251 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeFactories returns a constructor for every Store implementation so the
+// same behavioral tests run against each backend
+func storeFactories() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"sqlite": func(t *testing.T) Store {
+			s, err := openSQLiteStore(filepath.Join(t.TempDir(), "comments.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = s.Close() })
+			return s
+		},
+		"memory": func(t *testing.T) Store {
+			return newMemoryStore()
+		},
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestStore_Projects(t *testing.T) {
+	for name, newStore := range storeFactories() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+
+			p, err := s.CreateProject("/tmp/proj-a")
+			require.NoError(t, err)
+			assert.Equal(t, "/tmp/proj-a", p.Directory)
+
+			// Idempotent
+			_, err = s.CreateProject("/tmp/proj-a")
+			require.NoError(t, err)
+
+			_, err = s.CreateProject("/tmp/proj-b")
+			require.NoError(t, err)
+
+			projects, err := s.GetAllProjects()
+			require.NoError(t, err)
+			assert.Len(t, projects, 2)
+		})
+	}
+}
+
+func TestStore_CommentLifecycle(t *testing.T) {
+	for name, newStore := range storeFactories() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			_, err := s.CreateProject("/tmp/proj")
+			require.NoError(t, err)
+
+			root := &Comment{
+				ProjectDirectory: "/tmp/proj",
+				FilePath:         "doc.md",
+				LineStart:        intPtr(1),
+				LineEnd:          intPtr(2),
+				SelectedText:     "Heading",
+				CommentText:      "Root",
+				Author:           "user",
+			}
+			require.NoError(t, s.CreateComment(root))
+			assert.NotZero(t, root.ID)
+
+			other := &Comment{
+				ProjectDirectory: "/tmp/proj",
+				FilePath:         "doc.md",
+				LineStart:        intPtr(5),
+				LineEnd:          intPtr(5),
+				SelectedText:     "Body",
+				CommentText:      "Other",
+				Author:           "user",
+			}
+			require.NoError(t, s.CreateComment(other))
+
+			reply := &Comment{
+				ProjectDirectory: "/tmp/proj",
+				FilePath:         "doc.md",
+				CommentText:      "Reply",
+				Author:           "agent",
+				RootID:           &root.ID,
+			}
+			require.NoError(t, s.CreateComment(reply))
+
+			// Replies are grouped with their root
+			comments, err := s.GetComments("/tmp/proj", "doc.md", false)
+			require.NoError(t, err)
+			require.Len(t, comments, 3)
+			assert.Equal(t, []int{root.ID, reply.ID, other.ID},
+				[]int{comments[0].ID, comments[1].ID, comments[2].ID})
+
+			hasReply, err := s.HasReplies(root.ID)
+			require.NoError(t, err)
+			assert.True(t, hasReply)
+
+			hasReply, err = s.HasReplies(other.ID)
+			require.NoError(t, err)
+			assert.False(t, hasReply)
+
+			// Update
+			require.NoError(t, s.UpdateComment(other.ID, "Edited"))
+			got, err := s.GetCommentByID(other.ID)
+			require.NoError(t, err)
+			require.NotNil(t, got)
+			assert.Equal(t, "Edited", got.CommentText)
+
+			// Resolve thread resolves root and replies only
+			count, err := s.ResolveThread(root.ID, "agent")
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+
+			got, err = s.GetCommentByID(reply.ID)
+			require.NoError(t, err)
+			require.NotNil(t, got.ResolvedAt)
+			require.NotNil(t, got.ResolvedBy)
+			assert.Equal(t, "agent", *got.ResolvedBy)
+
+			resolved, err := s.GetComments("/tmp/proj", "doc.md", true)
+			require.NoError(t, err)
+			assert.Len(t, resolved, 2)
+
+			// Resolving again is a no-op
+			count, err = s.ResolveThread(root.ID, "agent")
+			require.NoError(t, err)
+			assert.Equal(t, 0, count)
+
+			// Resolve remaining comments for the file
+			count, err = s.ResolveComments("/tmp/proj", "doc.md")
+			require.NoError(t, err)
+			assert.Equal(t, 1, count)
+
+			unresolved, err := s.GetComments("/tmp/proj", "doc.md", false)
+			require.NoError(t, err)
+			assert.Empty(t, unresolved)
+
+			// Delete
+			require.NoError(t, s.DeleteComment(other.ID))
+			got, err = s.GetCommentByID(other.ID)
+			require.NoError(t, err)
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestStore_DeleteCommentThread(t *testing.T) {
+	for name, newStore := range storeFactories() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+
+			root := &Comment{ProjectDirectory: "/tmp/proj", FilePath: "doc.md", CommentText: "Root", Author: "user"}
+			require.NoError(t, s.CreateComment(root))
+			reply := &Comment{ProjectDirectory: "/tmp/proj", FilePath: "doc.md", CommentText: "Reply", Author: "agent", RootID: &root.ID}
+			require.NoError(t, s.CreateComment(reply))
+			other := &Comment{ProjectDirectory: "/tmp/proj", FilePath: "doc.md", CommentText: "Other", Author: "user"}
+			require.NoError(t, s.CreateComment(other))
+
+			require.NoError(t, s.DeleteComment(root.ID))
+			comments, err := s.GetComments("/tmp/proj", "doc.md", false)
+			require.NoError(t, err)
+			require.Len(t, comments, 1, "the reply is deleted with its root")
+			assert.Equal(t, other.ID, comments[0].ID)
+		})
+	}
+}
+
+func TestStore_GetCommentByID_NotFound(t *testing.T) {
+	for name, newStore := range storeFactories() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+
+			got, err := s.GetCommentByID(12345)
+			require.NoError(t, err)
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestStore_ListCommentsAndSummaries(t *testing.T) {
+	for name, newStore := range storeFactories() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			_, err := s.CreateProject("/tmp/proj")
+			require.NoError(t, err)
+
+			newRoot := func(file, text string) *Comment {
+				c := &Comment{
+					ProjectDirectory: "/tmp/proj",
+					FilePath:         file,
+					LineStart:        intPtr(1),
+					LineEnd:          intPtr(1),
+					SelectedText:     "x",
+					CommentText:      text,
+					Author:           "user",
+				}
+				require.NoError(t, s.CreateComment(c))
+				return c
+			}
+
+			a1 := newRoot("a.md", "a1")
+			newRoot("a.md", "a2")
+			b1 := newRoot("b.md", "b1")
+			reply := &Comment{ProjectDirectory: "/tmp/proj", FilePath: "a.md", CommentText: "r", Author: "agent", RootID: &a1.ID}
+			require.NoError(t, s.CreateComment(reply))
+
+			_, err = s.ResolveThread(b1.ID, "user")
+			require.NoError(t, err)
+
+			all, err := s.ListComments(CommentFilter{ProjectDirectory: "/tmp/proj", Status: commentStatusAll})
+			require.NoError(t, err)
+			assert.Len(t, all, 4)
+
+			unresolved, err := s.ListComments(CommentFilter{ProjectDirectory: "/tmp/proj", Status: commentStatusUnresolved})
+			require.NoError(t, err)
+			assert.Len(t, unresolved, 3)
+
+			resolved, err := s.ListComments(CommentFilter{ProjectDirectory: "/tmp/proj", FilePath: "b.md", Status: commentStatusResolved})
+			require.NoError(t, err)
+			require.Len(t, resolved, 1)
+			assert.Equal(t, b1.ID, resolved[0].ID)
+
+			thread, err := s.GetThread(a1.ID)
+			require.NoError(t, err)
+			require.Len(t, thread, 2)
+			assert.Equal(t, a1.ID, thread[0].ID)
+			assert.Equal(t, reply.ID, thread[1].ID)
+
+			summaries, err := s.GetFileSummaries("/tmp/proj")
+			require.NoError(t, err)
+			assert.Equal(t, []FileSummary{
+				{FilePath: "a.md", UnresolvedThreads: 2, UnresolvedComments: 3, ResolvedComments: 0},
+				{FilePath: "b.md", UnresolvedThreads: 0, UnresolvedComments: 0, ResolvedComments: 1},
+			}, summaries)
+
+			project, err := s.GetProject("/tmp/proj")
+			require.NoError(t, err)
+			require.NotNil(t, project)
+
+			project, err = s.GetProject("/tmp/missing")
+			require.NoError(t, err)
+			assert.Nil(t, project)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// instrumentedStore times every query of the daemon's store and counts the
+// comments it creates and resolves
+type instrumentedStore struct {
+	Store
+	metrics *daemonMetrics
+}
+
+func newInstrumentedStore(store Store, metrics *daemonMetrics) *instrumentedStore {
+	return &instrumentedStore{Store: store, metrics: metrics}
+}
+
+func (s *instrumentedStore) CreateProject(directory string) (*Project, error) {
+	defer s.metrics.dbQuery.observe("CreateProject", time.Now())
+	return s.Store.CreateProject(directory)
+}
+
+func (s *instrumentedStore) GetProject(directory string) (*Project, error) {
+	defer s.metrics.dbQuery.observe("GetProject", time.Now())
+	return s.Store.GetProject(directory)
+}
+
+func (s *instrumentedStore) GetAllProjects() ([]Project, error) {
+	defer s.metrics.dbQuery.observe("GetAllProjects", time.Now())
+	return s.Store.GetAllProjects()
+}
+
+func (s *instrumentedStore) GetFileSummaries(projectDir string) ([]FileSummary, error) {
+	defer s.metrics.dbQuery.observe("GetFileSummaries", time.Now())
+	return s.Store.GetFileSummaries(projectDir)
+}
+
+func (s *instrumentedStore) CreateComment(c *Comment) error {
+	defer s.metrics.dbQuery.observe("CreateComment", time.Now())
+	err := s.Store.CreateComment(c)
+	if err == nil {
+		s.metrics.commentsCreated.Add(1)
+	}
+	return err
+}
+
+func (s *instrumentedStore) GetComments(projectDir, filePath string, resolved bool) ([]Comment, error) {
+	defer s.metrics.dbQuery.observe("GetComments", time.Now())
+	return s.Store.GetComments(projectDir, filePath, resolved)
+}
+
+func (s *instrumentedStore) ListComments(filter CommentFilter) ([]Comment, error) {
+	defer s.metrics.dbQuery.observe("ListComments", time.Now())
+	return s.Store.ListComments(filter)
+}
+
+func (s *instrumentedStore) GetCommentByID(commentID int) (*Comment, error) {
+	defer s.metrics.dbQuery.observe("GetCommentByID", time.Now())
+	return s.Store.GetCommentByID(commentID)
+}
+
+func (s *instrumentedStore) UpdateComment(commentID int, commentText string) error {
+	defer s.metrics.dbQuery.observe("UpdateComment", time.Now())
+	return s.Store.UpdateComment(commentID, commentText)
+}
+
+func (s *instrumentedStore) DeleteComment(commentID int) error {
+	defer s.metrics.dbQuery.observe("DeleteComment", time.Now())
+	return s.Store.DeleteComment(commentID)
+}
+
+func (s *instrumentedStore) GetThread(rootCommentID int) ([]Comment, error) {
+	defer s.metrics.dbQuery.observe("GetThread", time.Now())
+	return s.Store.GetThread(rootCommentID)
+}
+
+func (s *instrumentedStore) HasReplies(commentID int) (bool, error) {
+	defer s.metrics.dbQuery.observe("HasReplies", time.Now())
+	return s.Store.HasReplies(commentID)
+}
+
+func (s *instrumentedStore) ResolveThread(rootCommentID int, resolvedBy string) (int, error) {
+	defer s.metrics.dbQuery.observe("ResolveThread", time.Now())
+	count, err := s.Store.ResolveThread(rootCommentID, resolvedBy)
+	s.metrics.commentsResolved.Add(int64(count))
+	return count, err
+}
+
+func (s *instrumentedStore) ResolveComments(projectDir, filePath string) (int, error) {
+	defer s.metrics.dbQuery.observe("ResolveComments", time.Now())
+	count, err := s.Store.ResolveComments(projectDir, filePath)
+	s.metrics.commentsResolved.Add(int64(count))
+	return count, err
+}
+
+// withLogger keeps the instrumentation around the inner store's logger
+func (s *instrumentedStore) withLogger(logger *slog.Logger) Store {
+	return &instrumentedStore{Store: storeWithLogger(s.Store, logger), metrics: s.metrics}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxRequestBodyBytes caps every JSON request body accepted by the API
+	maxRequestBodyBytes = 1 << 20
+
+	// maxCommentTextBytes caps the markdown body of a single comment
+	maxCommentTextBytes = 64 << 10
+
+	// maxSelectedTextBytes caps the quoted selection stored with a root comment
+	maxSelectedTextBytes = 16 << 10
+
+	// selectedTextLineSlack is how many lines around the given range are searched
+	// for selected_text. The viewer maps selections to source lines through the
+	// rendered block, which can be off by a few lines for lists and code blocks.
+	selectedTextLineSlack = 3
+)
+
+var (
+	errFileNotFound       = errors.New("file not found")
+	errPathOutsideProject = errors.New("path escapes the project directory")
+
+	errInvalidProjectDirectory = errors.New("invalid project directory")
+)
+
+// validateProjectDirectory checks that dir can be registered as a project: an
+// absolute path to an existing directory. It returns the path cleaned.
+func validateProjectDirectory(dir string) (string, error) {
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("%w: %q is not an absolute path", errInvalidProjectDirectory, dir)
+	}
+	dir = filepath.Clean(dir)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %s does not exist", errInvalidProjectDirectory, dir)
+		}
+		return "", fmt.Errorf("failed to stat project directory: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%w: %s is not a directory", errInvalidProjectDirectory, dir)
+	}
+	return dir, nil
+}
+
+// resolveProjectPath maps a project-relative path onto the filesystem and returns
+// its canonical path. Symlinks are resolved on both sides, so a link that points
+// outside the project is rejected just like a path containing "..".
+func resolveProjectPath(projectDir, relPath string) (string, error) {
+	if filepath.IsAbs(relPath) {
+		return "", fmt.Errorf("path must be relative to the project: %w", errPathOutsideProject)
+	}
+
+	canonicalProject, err := filepath.EvalSymlinks(projectDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve project directory: %w", err)
+	}
+
+	// Reject lexical escapes before touching the filesystem outside the project
+	joined := filepath.Join(canonicalProject, filepath.FromSlash(relPath))
+	if !isWithinDir(canonicalProject, joined) {
+		return "", errPathOutsideProject
+	}
+
+	canonical, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errFileNotFound
+		}
+		return "", err
+	}
+
+	if !isWithinDir(canonicalProject, canonical) {
+		return "", errPathOutsideProject
+	}
+
+	return canonical, nil
+}
+
+// resolveProjectFile is resolveProjectPath restricted to regular files
+func resolveProjectFile(projectDir, filePath string) (string, error) {
+	canonical, err := resolveProjectPath(projectDir, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(canonical)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", errFileNotFound
+	}
+
+	return canonical, nil
+}
+
+// isWithinDir reports whether path is dir itself or lies beneath it. Both must be clean.
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// normalizeWords reduces text to its lowercase words separated by single spaces.
+// Selections are made on rendered HTML, so markdown syntax, punctuation and
+// whitespace differ from the source and are ignored.
+func normalizeWords(text string) string {
+	return strings.ToLower(strings.Join(wordPattern.FindAllString(text, -1), " "))
+}
+
+// selectedTextNearLines reports whether selected occurs in content within
+// selectedTextLineSlack lines of the 1-based range [lineStart, lineEnd]
+func selectedTextNearLines(content, selected string, lineStart, lineEnd int) bool {
+	want := normalizeWords(selected)
+	if want == "" {
+		// Nothing but punctuation was selected - there is nothing to compare
+		return true
+	}
+
+	lines := strings.Split(content, "\n")
+	from := max(lineStart-1-selectedTextLineSlack, 0)
+	to := min(lineEnd+selectedTextLineSlack, len(lines))
+	if from >= to {
+		return false
+	}
+
+	window := " " + normalizeWords(strings.Join(lines[from:to], "\n")) + " "
+	return strings.Contains(window, " "+want+" ")
+}
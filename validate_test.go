@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProjectFile(t *testing.T) {
+	root := t.TempDir()
+	projectDir := filepath.Join(root, "project")
+	require.NoError(t, os.MkdirAll(filepath.Join(projectDir, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "docs", "guide.md"), []byte("# Guide\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret.md"), []byte("# Secret\n"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "secret.md"), filepath.Join(projectDir, "escape.md")))
+	require.NoError(t, os.Symlink(filepath.Join(projectDir, "docs", "guide.md"), filepath.Join(projectDir, "alias.md")))
+
+	tests := []struct {
+		name     string
+		filePath string
+		err      error
+	}{
+		{"plain file", "docs/guide.md", nil},
+		{"symlink inside project", "alias.md", nil},
+		{"dot segments inside project", "docs/../docs/guide.md", nil},
+		{"parent traversal", "../secret.md", errPathOutsideProject},
+		{"absolute path", filepath.Join(root, "secret.md"), errPathOutsideProject},
+		{"symlink escape", "escape.md", errPathOutsideProject},
+		{"missing file", "docs/missing.md", errFileNotFound},
+		{"directory", "docs", errFileNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := resolveProjectFile(projectDir, tt.filePath)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "guide.md", filepath.Base(resolved))
+		})
+	}
+}
+
+func TestSelectedTextNearLines(t *testing.T) {
+	content := "# Title\n\nSome **bold** text\nspanning two lines.\n\n- item one\n- item two\n\n\n\n\n\nFar away.\n"
+
+	tests := []struct {
+		name      string
+		selected  string
+		lineStart int
+		lineEnd   int
+		want      bool
+	}{
+		{"heading without markdown syntax", "Title", 1, 1, true},
+		{"rendered emphasis", "Some bold text", 3, 3, true},
+		{"selection across lines", "bold text\n spanning", 3, 4, true},
+		{"case and punctuation ignored", "ITEM ONE - item two", 6, 7, true},
+		{"within slack", "item one", 3, 3, true},
+		{"outside slack", "Far away", 1, 1, false},
+		{"not in file", "missing words", 1, 13, false},
+		{"partial word does not match", "tem one", 6, 6, false},
+		{"range beyond end of file", "Title", 100, 100, false},
+		{"punctuation only", "--", 1, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, selectedTextNearLines(content, tt.selected, tt.lineStart, tt.lineEnd))
+		})
+	}
+}
@@ -1,4 +1,120 @@
 package main
 
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Version is set at build time via -ldflags
 var Version = "dev"
+
+// daemonMode is set when the server runs as a background daemon, as opposed
+// to in the foreground of someone's terminal
+var daemonMode bool
+
+// VersionInfo is served at /api/version. It sits outside /api/v1 so that any
+// build, whatever API version it speaks, can tell which build it talks to.
+type VersionInfo struct {
+	Version string `json:"version"`
+	PID     int    `json:"pid"`
+	Daemon  bool   `json:"daemon"`
+}
+
+// handleVersion reports the build of the running server
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, VersionInfo{Version: Version, PID: os.Getpid(), Daemon: daemonMode})
+}
+
+// compareVersions compares two release versions such as "v1.2.3". ok is false
+// if either is not a release version (e.g. "dev").
+func compareVersions(a, b string) (cmp int, ok bool) {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// parseVersion parses "v1.2.3" or "1.2.3", ignoring any pre-release or build
+// suffix
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// restartDecision decides what to do about a server running another build.
+// Restarting drops its connections, which viewers recover from, but a server
+// in someone's terminal is theirs to restart, and a newer daemon is not
+// downgraded.
+func restartDecision(cliVersion string, server VersionInfo) (restart bool, reason string) {
+	switch {
+	case server.Version == cliVersion:
+		return false, ""
+	case !server.Daemon:
+		return false, "it runs in the foreground; restart it to pick up this version"
+	}
+	if cmp, ok := compareVersions(server.Version, cliVersion); ok && cmp > 0 {
+		return false, "it is newer than this command"
+	}
+	return true, ""
+}
+
+// ensureDaemonVersion restarts a daemon that runs another build than this
+// binary, since it would serve stale templates and scripts, or warns when
+// that is not safe. Nothing happens if no server runs.
+func ensureDaemonVersion() {
+	if !isServerRunning() {
+		return
+	}
+	state, err := waitForRunningServer(5 * time.Second)
+	if err != nil {
+		log.Printf("Warning: could not check the server version: %v", err)
+		return
+	}
+	info, err := fetchDaemonVersion(state.URL)
+	if err != nil {
+		log.Printf("Warning: could not check the server version: %v", err)
+		return
+	}
+
+	restart, reason := restartDecision(Version, *info)
+	if !restart {
+		if reason != "" {
+			log.Printf("Warning: server (PID %d) runs version %s, not %s: %s", info.PID, info.Version, Version, reason)
+		}
+		return
+	}
+
+	log.Printf("Server (PID %d) runs version %s, restarting it with %s", info.PID, info.Version, Version)
+	if err := restartDaemon(); err != nil {
+		log.Printf("Warning: failed to restart server: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+		ok   bool
+	}{
+		{"v1.2.3", "v1.2.3", 0, true},
+		{"v1.2.3", "1.2.3", 0, true},
+		{"v1.2.3", "v1.10.0", -1, true},
+		{"v2.0.0", "v1.99.99", 1, true},
+		{"v1.2.3-rc1", "v1.2.3", 0, true},
+		{"dev", "v1.2.3", 0, false},
+		{"v1.2", "v1.2.0", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			cmp, ok := compareVersions(tt.a, tt.b)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.cmp, cmp)
+		})
+	}
+}
+
+func TestRestartDecision(t *testing.T) {
+	tests := []struct {
+		name    string
+		cli     string
+		server  VersionInfo
+		restart bool
+		reason  string
+	}{
+		{"same version", "v1.2.0", VersionInfo{Version: "v1.2.0", Daemon: true}, false, ""},
+		{"older daemon", "v1.2.0", VersionInfo{Version: "v1.1.0", Daemon: true}, true, ""},
+		{"newer daemon", "v1.2.0", VersionInfo{Version: "v1.3.0", Daemon: true}, false, "newer"},
+		{"development build", "dev", VersionInfo{Version: "v1.3.0", Daemon: true}, true, ""},
+		{"foreground server", "v1.2.0", VersionInfo{Version: "v1.1.0", Daemon: false}, false, "foreground"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restart, reason := restartDecision(tt.cli, tt.server)
+			assert.Equal(t, tt.restart, restart)
+			if tt.reason == "" {
+				assert.Empty(t, reason)
+			} else {
+				assert.Contains(t, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodGet, "/api/version", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var info VersionInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, VersionInfo{Version: Version, PID: os.Getpid(), Daemon: false}, info)
+}
@@ -1,117 +1,534 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// watchDebounce is how long a watched file or tree must be quiet before its
+// subscribers are notified, so a burst of writes produces a single event
+const watchDebounce = 100 * time.Millisecond
+
+// FileWatcher watches directories rather than individual files. Editors and
+// agents often save by writing a temporary file and renaming it over the
+// original, or by deleting and recreating it; a watch on the file itself would
+// follow the old inode and go silent. Events are matched to watched files by name.
+//
+// Interest in a file or a project tree is expressed through subscriptions.
+// Each subscriber gets its own handle, and the OS watches are only released
+// once the last subscriber of a directory has closed its handle. Subscribers
+// that share a key are notified once per change between them.
+//
+// Directories are watched by a backend (see pollwatcher.go): fsnotify, or
+// polling where notifications are unavailable. In auto mode, fsnotify errors
+// switch the watcher to polling.
 type FileWatcher struct {
-	watcher   *fsnotify.Watcher
-	watches   map[string]bool // Track watched files
-	mu        sync.RWMutex
-	callbacks map[string]func() // Callbacks per file path
+	backend  watchBackend
+	mu       sync.Mutex
+	files    map[string]*watchedFile // Watched files by absolute path
+	trees    map[string]*watchedTree // Watched project directories by root
+	dirs     map[string]int          // Watched directories and how many files and trees use them
+	debounce time.Duration
+
+	// autoFallback switches to polling when fsnotify fails
+	autoFallback   bool
+	pollInterval   time.Duration
+	fallbackReason string
+}
+
+// WatcherStatus describes the active watcher backend
+type WatcherStatus struct {
+	Backend            string `json:"backend"`
+	PollInterval       string `json:"poll_interval,omitempty"`
+	FallbackReason     string `json:"fallback_reason,omitempty"`
+	WatchedFiles       int    `json:"watched_files"`
+	WatchedDirectories int    `json:"watched_directories"`
+}
+
+// watchedFile is a file with at least one subscriber
+type watchedFile struct {
+	subscribers map[*watchSubscription]func()
+	timer       *time.Timer
+	// generation identifies the latest timer; one that fired while a newer
+	// event restarted the debounce finds it changed and does nothing
+	generation uint64
+}
+
+// watchedTree is a project directory watched recursively for Markdown files
+// and directories being added or removed
+type watchedTree struct {
+	// config decides which directories are ignored and which files are documents
+	config      *Config
+	dirs        map[string]bool
+	subscribers map[*watchSubscription]func(relPath string)
+	// pending holds the paths changed since the debounce timer started
+	pending    map[string]bool
+	timer      *time.Timer
+	generation uint64
+}
+
+// watchSubscription is a subscriber's handle on a watched file or tree
+type watchSubscription struct {
+	fw   *FileWatcher
+	path string
+	tree bool
+	// key groups subscribers that want one notification between them; empty
+	// means the subscriber is notified on its own
+	key  string
+	once sync.Once
 }
 
 var fileWatcher *FileWatcher
 
 func initFileWatcher() error {
-	watcher, err := fsnotify.NewWatcher()
+	fw, err := newFileWatcher()
 	if err != nil {
 		return err
 	}
+	fileWatcher = fw
+	return nil
+}
 
-	fileWatcher = &FileWatcher{
-		watcher:   watcher,
-		watches:   make(map[string]bool),
-		callbacks: make(map[string]func()),
+// newFileWatcher creates a watcher with the backend selected by CR_WATCHER
+// (auto, fsnotify or poll; default auto) and CR_POLL_INTERVAL (e.g. "500ms";
+// default 2s), and starts processing its events in the background
+func newFileWatcher() (*FileWatcher, error) {
+	pollInterval := defaultPollInterval
+	if value := os.Getenv("CR_POLL_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid CR_POLL_INTERVAL %q", value)
+		}
+		pollInterval = interval
+	}
+
+	switch mode := os.Getenv("CR_WATCHER"); mode {
+	case watchBackendPoll:
+		return newFileWatcherWithBackend(newPollBackend(pollInterval), false, pollInterval), nil
+	case watchBackendFsnotify:
+		backend, err := newFsnotifyBackend()
+		if err != nil {
+			return nil, err
+		}
+		return newFileWatcherWithBackend(backend, false, pollInterval), nil
+	case "", watchBackendAuto:
+		backend, err := newFsnotifyBackend()
+		if err != nil {
+			slog.Warn("File notifications unavailable, polling", "poll_interval", pollInterval, "error", err)
+			fw := newFileWatcherWithBackend(newPollBackend(pollInterval), false, pollInterval)
+			fw.fallbackReason = err.Error()
+			return fw, nil
+		}
+		return newFileWatcherWithBackend(backend, true, pollInterval), nil
+	default:
+		return nil, fmt.Errorf("invalid CR_WATCHER %q (want auto, fsnotify or poll)", mode)
+	}
+}
+
+func newFileWatcherWithBackend(backend watchBackend, autoFallback bool, pollInterval time.Duration) *FileWatcher {
+	fw := &FileWatcher{
+		backend:      backend,
+		files:        make(map[string]*watchedFile),
+		trees:        make(map[string]*watchedTree),
+		dirs:         make(map[string]int),
+		debounce:     watchDebounce,
+		autoFallback: autoFallback,
+		pollInterval: pollInterval,
 	}
 
 	// Start event processing in background
-	go fileWatcher.processEvents()
+	go fw.processEvents(backend)
 
-	return nil
+	return fw
 }
 
-func (fw *FileWatcher) processEvents() {
+// processEvents handles the events of one backend until it is closed. After a
+// fallback, the old backend's remaining events are drained and ignored.
+func (fw *FileWatcher) processEvents(backend watchBackend) {
 	for {
 		select {
-		case event, ok := <-fw.watcher.Events:
+		case event, ok := <-backend.events():
 			if !ok {
 				return
 			}
-
-			// Only care about write/create events
-			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-				fw.mu.RLock()
-				callback, exists := fw.callbacks[event.Name]
-				fw.mu.RUnlock()
-
-				if exists && callback != nil {
-					callback()
-				}
+			if fw.activeBackend() != backend {
+				continue
 			}
 
-		case err, ok := <-fw.watcher.Errors:
+			fw.handleFileEvent(event)
+			fw.handleTreeEvent(event)
+
+		case err, ok := <-backend.errors():
 			if !ok {
 				return
 			}
-			log.Printf("File watcher error: %v", err)
+			slog.Error("File watcher error", "error", err)
+
+			// Events may have been lost (e.g. a queue overflow); polling
+			// cannot lose them
+			fw.mu.Lock()
+			if fw.backend == backend && fw.autoFallback {
+				fw.fallBack(err)
+			}
+			fw.mu.Unlock()
 		}
 	}
 }
 
-func (fw *FileWatcher) watchFile(projectDir, filePath string, callback func()) error {
-	absPath := filepath.Join(projectDir, filePath)
+func (fw *FileWatcher) activeBackend() watchBackend {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.backend
+}
+
+// fallBack replaces fsnotify with polling, moving every watched directory over;
+// fw.mu must be held
+func (fw *FileWatcher) fallBack(reason error) {
+	slog.Warn("Falling back to polling", "poll_interval", fw.pollInterval, "reason", reason)
 
+	old := fw.backend
+	poll := newPollBackend(fw.pollInterval)
+	for dir := range fw.dirs {
+		if err := poll.add(dir); err != nil {
+			slog.Error("Failed to poll", "directory", dir, "error", err)
+		}
+	}
+
+	fw.backend = poll
+	fw.autoFallback = false
+	fw.fallbackReason = reason.Error()
+	go fw.processEvents(poll)
+
+	// Closing fsnotify waits for its reader, which may be blocked handing an
+	// event to processEvents, which in turn waits for fw.mu
+	go func() { _ = old.close() }()
+}
+
+// status reports the active backend
+func (fw *FileWatcher) status() WatcherStatus {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
-	// Check if already watching
-	if fw.watches[absPath] {
-		// Update callback
-		fw.callbacks[absPath] = callback
-		return nil
+	status := WatcherStatus{
+		Backend:            fw.backend.name(),
+		FallbackReason:     fw.fallbackReason,
+		WatchedFiles:       len(fw.files),
+		WatchedDirectories: len(fw.dirs),
+	}
+	if status.Backend == watchBackendPoll {
+		status.PollInterval = fw.pollInterval.String()
 	}
+	return status
+}
 
-	// Add watch
-	if err := fw.watcher.Add(absPath); err != nil {
-		return err
+// subscribeFile calls callback whenever the file at absPath changes, until the
+// returned subscription is closed. Of the subscribers with the same non-empty
+// key, only one is called per change.
+func (fw *FileWatcher) subscribeFile(absPath, key string, callback func()) (*watchSubscription, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	file, ok := fw.files[absPath]
+	if !ok {
+		// Watch the parent directory, which survives the file being replaced
+		if err := fw.addDir(filepath.Dir(absPath)); err != nil {
+			return nil, err
+		}
+		file = &watchedFile{subscribers: make(map[*watchSubscription]func())}
+		fw.files[absPath] = file
+		slog.Debug("Started watching file", "file", absPath)
+	}
+
+	sub := &watchSubscription{fw: fw, path: absPath, key: key}
+	file.subscribers[sub] = callback
+	return sub, nil
+}
+
+// subscribeTree watches root and its subdirectories, except those skipped in
+// directory listings. callback receives the path, relative to root, of each
+// Markdown file or directory that is created, removed or renamed. The
+// project's config is read when the watch starts. Keys work as in
+// subscribeFile.
+func (fw *FileWatcher) subscribeTree(root, key string, callback func(relPath string)) (*watchSubscription, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	tree, ok := fw.trees[root]
+	if !ok {
+		tree = &watchedTree{
+			config:      configFor(root),
+			dirs:        make(map[string]bool),
+			subscribers: make(map[*watchSubscription]func(string)),
+			pending:     make(map[string]bool),
+		}
+		if err := fw.addTreeDirs(tree, root); err != nil {
+			fw.removeTreeDirs(tree, root)
+			return nil, err
+		}
+		fw.trees[root] = tree
+		slog.Debug("Started watching project", "project", root, "directories", len(tree.dirs))
 	}
 
-	fw.watches[absPath] = true
-	fw.callbacks[absPath] = callback
+	sub := &watchSubscription{fw: fw, path: root, tree: true, key: key}
+	tree.subscribers[sub] = callback
+	return sub, nil
+}
+
+// close ends the subscription. The watch itself is released when its last
+// subscriber leaves. Closing twice is harmless.
+func (s *watchSubscription) close() {
+	s.once.Do(func() {
+		fw := s.fw
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+
+		if s.tree {
+			tree := fw.trees[s.path]
+			delete(tree.subscribers, s)
+			if len(tree.subscribers) > 0 {
+				return
+			}
+			if tree.timer != nil {
+				tree.timer.Stop()
+			}
+			fw.removeTreeDirs(tree, s.path)
+			delete(fw.trees, s.path)
+			slog.Debug("Stopped watching project", "project", s.path)
+			return
+		}
+
+		file := fw.files[s.path]
+		delete(file.subscribers, s)
+		if len(file.subscribers) > 0 {
+			return
+		}
+		if file.timer != nil {
+			file.timer.Stop()
+		}
+		fw.removeDir(filepath.Dir(s.path))
+		delete(fw.files, s.path)
+		slog.Debug("Stopped watching file", "file", s.path)
+	})
+}
 
-	log.Printf("Started watching file: %s", absPath)
+// addDir starts watching dir and counts another user of the watch; fw.mu must
+// be held. Adding an existing watch again is harmless and restores it if the
+// directory was deleted and recreated in the meantime.
+func (fw *FileWatcher) addDir(dir string) error {
+	err := fw.backend.add(dir)
+	if err != nil && fw.autoFallback && shouldFallBack(err) {
+		// Typically the inotify watch limit
+		fw.fallBack(err)
+		err = fw.backend.add(dir)
+	}
+	if err != nil {
+		return err
+	}
+	fw.dirs[dir]++
 	return nil
 }
 
-func (fw *FileWatcher) unwatchFile(projectDir, filePath string) error {
-	absPath := filepath.Join(projectDir, filePath)
+// removeDir stops watching dir once its last user is gone; fw.mu must be held
+func (fw *FileWatcher) removeDir(dir string) {
+	if fw.dirs[dir] > 1 {
+		fw.dirs[dir]--
+		return
+	}
+	delete(fw.dirs, dir)
+	// The watch is already gone if the directory was deleted
+	_ = fw.backend.remove(dir)
+}
+
+// addTreeDirs watches dir and every directory below it; fw.mu must be held
+func (fw *FileWatcher) addTreeDirs(tree *watchedTree, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable subdirectories are skipped, a missing root is an error
+			if path == dir {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && tree.config.skipDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		if tree.dirs[path] {
+			return nil
+		}
+		if err := fw.addDir(path); err != nil {
+			return err
+		}
+		tree.dirs[path] = true
+		return nil
+	})
+}
+
+// removeTreeDirs stops watching dir and every directory below it; fw.mu must be held
+func (fw *FileWatcher) removeTreeDirs(tree *watchedTree, dir string) {
+	for path := range tree.dirs {
+		if isWithinDir(dir, path) {
+			fw.removeDir(path)
+			delete(tree.dirs, path)
+		}
+	}
+}
+
+// handleFileEvent schedules a notification for the subscribers of a changed file
+func (fw *FileWatcher) handleFileEvent(event fsnotify.Event) {
+	// A file is (re)written in place, or a new file takes its name: by rename
+	// (atomic save) or by being recreated after a delete. Remove and Rename of
+	// the name itself mean the content is going away, and the Create that
+	// follows in a save reports the new content.
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+		return
+	}
 
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
-	if !fw.watches[absPath] {
-		return nil
+	file, ok := fw.files[event.Name]
+	if !ok {
+		return
 	}
+	fw.scheduleFileNotification(event.Name, file)
+}
 
-	if err := fw.watcher.Remove(absPath); err != nil {
-		return err
+// scheduleFileNotification (re)starts the debounce timer of a file; fw.mu must
+// be held. A timer that already fired may be waiting for fw.mu, so rather
+// than being reset it is superseded by a new generation.
+func (fw *FileWatcher) scheduleFileNotification(absPath string, file *watchedFile) {
+	if file.timer != nil {
+		file.timer.Stop()
 	}
+	file.generation++
+	generation := file.generation
+	file.timer = time.AfterFunc(fw.debounce, func() { fw.notifyFile(absPath, file, generation) })
+}
 
-	delete(fw.watches, absPath)
-	delete(fw.callbacks, absPath)
+// notifyFile calls the subscribers of a file once its debounce timer fires
+func (fw *FileWatcher) notifyFile(absPath string, file *watchedFile, generation uint64) {
+	fw.mu.Lock()
+	if fw.files[absPath] != file || file.generation != generation {
+		// Every subscriber left in the meantime, or a later event restarted
+		// the debounce
+		fw.mu.Unlock()
+		return
+	}
+	file.timer = nil
+	callbacks := make([]func(), 0, len(file.subscribers))
+	keys := make(map[string]bool)
+	for sub, callback := range file.subscribers {
+		if sub.key != "" {
+			if keys[sub.key] {
+				continue
+			}
+			keys[sub.key] = true
+		}
+		callbacks = append(callbacks, callback)
+	}
+	fw.mu.Unlock()
 
-	log.Printf("Stopped watching file: %s", absPath)
-	return nil
+	for _, callback := range callbacks {
+		callback()
+	}
 }
 
-func (fw *FileWatcher) close() error {
-	if fw.watcher != nil {
-		return fw.watcher.Close()
+// handleTreeEvent updates the watched directories of the trees containing the
+// event's path and schedules a notification for Markdown files and
+// directories coming and going
+func (fw *FileWatcher) handleTreeEvent(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+		return
 	}
-	return nil
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	for root, tree := range fw.trees {
+		if event.Name == root || !isWithinDir(root, event.Name) {
+			continue
+		}
+
+		relevant := tree.config.isDocument(event.Name)
+		switch {
+		case event.Has(fsnotify.Create):
+			info, err := os.Stat(event.Name)
+			if err == nil && info.IsDir() && !tree.config.skipDir(info.Name()) {
+				if err := fw.addTreeDirs(tree, event.Name); err != nil {
+					slog.Error("Failed to watch directory", "directory", event.Name, "error", err)
+				}
+				relevant = true
+			}
+		case tree.dirs[event.Name]:
+			fw.removeTreeDirs(tree, event.Name)
+			relevant = true
+		}
+		if !relevant {
+			continue
+		}
+
+		relPath, _ := filepath.Rel(root, event.Name)
+		fw.scheduleTreeNotification(root, tree, relPath)
+	}
+}
+
+// scheduleTreeNotification records a changed path and (re)starts the debounce
+// timer of a tree, like scheduleFileNotification; fw.mu must be held
+func (fw *FileWatcher) scheduleTreeNotification(root string, tree *watchedTree, relPath string) {
+	tree.pending[relPath] = true
+	if tree.timer != nil {
+		tree.timer.Stop()
+	}
+	tree.generation++
+	generation := tree.generation
+	tree.timer = time.AfterFunc(fw.debounce, func() { fw.notifyTree(root, tree, generation) })
+}
+
+// notifyTree calls the subscribers of a tree with each path changed since its
+// debounce timer started
+func (fw *FileWatcher) notifyTree(root string, tree *watchedTree, generation uint64) {
+	fw.mu.Lock()
+	if fw.trees[root] != tree || tree.generation != generation {
+		fw.mu.Unlock()
+		return
+	}
+	tree.timer = nil
+	paths := make([]string, 0, len(tree.pending))
+	for relPath := range tree.pending {
+		paths = append(paths, relPath)
+	}
+	tree.pending = make(map[string]bool)
+	callbacks := make([]func(string), 0, len(tree.subscribers))
+	keys := make(map[string]bool)
+	for sub, callback := range tree.subscribers {
+		if sub.key != "" {
+			if keys[sub.key] {
+				continue
+			}
+			keys[sub.key] = true
+		}
+		callbacks = append(callbacks, callback)
+	}
+	fw.mu.Unlock()
+
+	for _, relPath := range paths {
+		for _, callback := range callbacks {
+			callback(relPath)
+		}
+	}
+}
+
+func (fw *FileWatcher) close() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.backend.close()
 }
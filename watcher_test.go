@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileWatcher(t *testing.T) *FileWatcher {
+	t.Helper()
+
+	fw, err := newFileWatcher()
+	require.NoError(t, err)
+	fw.debounce = 20 * time.Millisecond
+	t.Cleanup(func() { _ = fw.close() })
+	return fw
+}
+
+// waitForCount waits until counter reaches want and stays there for a moment
+func waitForCount(t *testing.T, counter *atomic.Int32, want int32) {
+	t.Helper()
+	require.Eventually(t, func() bool { return counter.Load() >= want }, 2*time.Second, 5*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, want, counter.Load())
+}
+
+func TestFileWatcher_Subscriptions(t *testing.T) {
+	fw := newTestFileWatcher(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n"), 0644))
+
+	var first, second atomic.Int32
+	sub1, err := fw.subscribeFile(path, "", func() { first.Add(1) })
+	require.NoError(t, err)
+	sub2, err := fw.subscribeFile(path, "", func() { second.Add(1) })
+	require.NoError(t, err)
+	assert.Equal(t, 1, fw.dirs[dir], "subscribers of one file share a watch")
+
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n\nOne\n"), 0644))
+	waitForCount(t, &first, 1)
+	waitForCount(t, &second, 1)
+
+	// Closing one tab must not stop live reload in the other
+	sub1.close()
+	sub1.close()
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n\nTwo\n"), 0644))
+	waitForCount(t, &second, 2)
+	assert.Equal(t, int32(1), first.Load())
+
+	sub2.close()
+	assert.Empty(t, fw.files)
+	assert.Empty(t, fw.dirs, "the OS watch is released with the last subscriber")
+}
+
+func TestFileWatcher_Debounce(t *testing.T) {
+	fw := newTestFileWatcher(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n"), 0644))
+
+	var calls atomic.Int32
+	sub, err := fw.subscribeFile(path, "", func() { calls.Add(1) })
+	require.NoError(t, err)
+	defer sub.close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := f.WriteString("line\n")
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	waitForCount(t, &calls, 1)
+}
+
+// An event that arrives after the debounce timer fired, but before its
+// callback got hold of the lock, must not lead to a second notification
+func TestFileWatcher_DebounceBoundary(t *testing.T) {
+	fw := newTestFileWatcher(t)
+	root := t.TempDir()
+	path := filepath.Join(root, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n"), 0644))
+
+	var fileCalls, treeCalls atomic.Int32
+	fileSub, err := fw.subscribeFile(path, "", func() { fileCalls.Add(1) })
+	require.NoError(t, err)
+	defer fileSub.close()
+	treeSub, err := fw.subscribeTree(root, "", func(string) { treeCalls.Add(1) })
+	require.NoError(t, err)
+	defer treeSub.close()
+
+	fw.mu.Lock()
+	file, tree := fw.files[path], fw.trees[root]
+	fw.scheduleFileNotification(path, file)
+	fw.scheduleTreeNotification(root, tree, "doc.md")
+	// Let both timers fire and block on the lock
+	time.Sleep(5 * fw.debounce)
+	fw.scheduleFileNotification(path, file)
+	fw.scheduleTreeNotification(root, tree, "doc.md")
+	fw.mu.Unlock()
+
+	waitForCount(t, &fileCalls, 1)
+	waitForCount(t, &treeCalls, 1)
+}
+
+func TestFileWatcher_FileAndTreeShareDirectories(t *testing.T) {
+	fw := newTestFileWatcher(t)
+	root := t.TempDir()
+	path := filepath.Join(root, "doc.md")
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n"), 0644))
+
+	var fileCalls atomic.Int32
+	fileSub, err := fw.subscribeFile(path, "", func() { fileCalls.Add(1) })
+	require.NoError(t, err)
+	defer fileSub.close()
+
+	changed := make(chan string, 10)
+	treeSub, err := fw.subscribeTree(root, "", func(relPath string) { changed <- relPath })
+	require.NoError(t, err)
+	assert.Equal(t, 2, fw.dirs[root])
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "new.md"), []byte("# New\n"), 0644))
+	select {
+	case relPath := <-changed:
+		assert.Equal(t, "new.md", relPath)
+	case <-time.After(2 * time.Second):
+		t.Fatal("tree subscriber was not notified")
+	}
+
+	// The file keeps its watch when the tree goes away
+	treeSub.close()
+	assert.Equal(t, 1, fw.dirs[root])
+	require.NoError(t, os.WriteFile(path, []byte("# Doc\n\nChanged\n"), 0644))
+	waitForCount(t, &fileCalls, 1)
+}
+
+func TestSSEHub_WatchTopicShared(t *testing.T) {
+	fw := newTestFileWatcher(t)
+	hub := newSSEHub()
+	projectDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "doc.md"), []byte("# Doc\n"), 0644))
+	topic := sseTopic{projectDir, "doc.md"}
+
+	viewer := newSSEClient(projectDir, "doc.md")
+	hub.addClient(viewer, "")
+
+	releaseFirst := hub.watchTopic(fw, topic)
+	releaseSecond := hub.watchTopic(fw, topic)
+	assert.Len(t, fw.files, 1)
+
+	// A change is rendered and broadcast once, whatever the number of tabs
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "doc.md"), []byte("# Doc\n\nEdited\n"), 0644))
+	select {
+	case msg := <-viewer.Channel:
+		assert.Contains(t, string(msg), "event: document_rendered")
+		assert.Contains(t, string(msg), "Edited")
+	case <-time.After(2 * time.Second):
+		t.Fatal("no document_rendered event")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, viewer.Channel)
+
+	releaseFirst()
+	releaseFirst()
+	require.Len(t, fw.files, 1, "the second tab still watches the file")
+
+	releaseSecond()
+	assert.Empty(t, fw.files)
+	assert.Empty(t, fw.dirs)
+}